@@ -0,0 +1,127 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kubecd/kubecd/pkg/affected"
+	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/output"
+)
+
+var (
+	affectedBase   string
+	affectedHead   string
+	affectedOutput string
+)
+
+// affectedCmd represents the affected command
+var affectedCmd = &cobra.Command{
+	Use:   "affected",
+	Short: "list releases that differ between two git revisions",
+	Long: `Loads the environments file at two git revisions, and lists the releases whose
+inputs differ: chart directory contents, chart reference and version, values
+files, inline values, environment defaults and resource files.
+
+The head revision defaults to the working tree. The releases can also be
+applied, rendered or diffed with "--affected-since REF".`,
+	Args: matchAll(cobra.NoArgs, outputFormat(&affectedOutput)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if affectedBase == "" {
+			return fmt.Errorf(`--base is required`)
+		}
+		base, cleanupBase, err := affected.ConfigAt(environmentsFile, affectedBase)
+		if err != nil {
+			return err
+		}
+		defer cleanupBase()
+		var head *model.KubeCDConfig
+		if affectedHead == "" {
+			head, err = model.NewConfigFromFile(environmentsFile)
+		} else {
+			var cleanupHead func()
+			head, cleanupHead, err = affected.ConfigAt(environmentsFile, affectedHead)
+			if err == nil {
+				defer cleanupHead()
+			}
+		}
+		if err != nil {
+			return err
+		}
+		releases := affected.Compare(base, head)
+		if affectedOutput == output.FormatText {
+			for _, release := range releases {
+				fmt.Printf("%s -r %s (%s)\n", release.Environment, release.Name, release.Change)
+			}
+			return nil
+		}
+		result := output.AffectedReleases{Releases: make([]output.AffectedRelease, 0, len(releases))}
+		for _, release := range releases {
+			result.Releases = append(result.Releases, output.AffectedRelease{Environment: release.Environment, Name: release.Name, Change: release.Change})
+		}
+		return output.Write(os.Stdout, affectedOutput, result)
+	},
+}
+
+// affectedReleases limits environments to the releases affected since a git
+// revision, returning the environments with affected releases and the
+// releases of each. Without a revision, all environments are limited to the
+// given releases.
+func affectedReleases(kcdConfig *model.KubeCDConfig, envs []*model.Environment, releases []string, since string) ([]*model.Environment, map[string][]string, error) {
+	limits := make(map[string][]string)
+	if since == "" {
+		for _, env := range envs {
+			limits[env.Name] = releases
+		}
+		return envs, limits, nil
+	}
+	changes, err := affected.Since(environmentsFile, kcdConfig, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	affectedEnvs := make([]*model.Environment, 0)
+	for _, env := range envs {
+		for _, change := range changes {
+			if change.Environment == env.Name && change.Change != affected.Removed && (len(releases) == 0 || stringInSlice(change.Name, releases)) {
+				limits[env.Name] = append(limits[env.Name], change.Name)
+			}
+		}
+		if len(limits[env.Name]) > 0 {
+			affectedEnvs = append(affectedEnvs, env)
+		}
+	}
+	if len(affectedEnvs) == 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "No releases affected since %s.\n", since)
+	}
+	return affectedEnvs, limits, nil
+}
+
+func addAffectedSinceFlag(cmd *cobra.Command, since *string) {
+	cmd.Flags().StringVar(since, "affected-since", "", "only use releases that differ from git revision `REF`")
+}
+
+func init() {
+	rootCmd.AddCommand(affectedCmd)
+	affectedCmd.Flags().StringVar(&affectedBase, "base", "", "git revision to compare with")
+	affectedCmd.Flags().StringVar(&affectedHead, "head", "", "git revision to compare (default the working tree)")
+	addOutputFlag(affectedCmd, &affectedOutput)
+}
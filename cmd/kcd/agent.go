@@ -0,0 +1,193 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kubecd/kubecd/pkg/agent"
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/state"
+)
+
+var (
+	agentRepo      string
+	agentBranch    string
+	agentDir       string
+	agentCluster   string
+	agentInterval  time.Duration
+	agentListen    string
+	agentInit      bool
+	agentGitlab    bool
+	agentDryRun    bool
+	agentOnce      bool
+	agentWait      bool
+	agentTimeout   time.Duration
+	agentState     string
+	agentStateFile string
+)
+
+// agentCmd represents the agent command
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "keep releases in a cluster applied from a git repository",
+	Long: `Runs an agent that regularly fetches a git repository with the environments
+file, and applies the releases of the environments in its cluster that changed
+since they were last applied.
+
+The environments file is read from the repository, at the path given by
+--environments-file. The cluster is given by --cluster, or found from the
+cluster of the current kubectl context.
+
+The fingerprints of applied releases are stored like "kcd apply" stores them,
+so that releases are not applied again when the agent restarts. Dry runs keep
+them in memory only.
+
+The status of the last sync is served as JSON at /status, with status 503 if
+it failed.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if agentRepo == "" {
+			return fmt.Errorf(`--repo is required`)
+		}
+		if agentInterval <= 0 {
+			return fmt.Errorf(`--interval must be positive`)
+		}
+		dir := agentDir
+		if dir == "" {
+			cacheDir, err := os.UserCacheDir()
+			if err != nil {
+				return fmt.Errorf(`could not find checkout directory: %v`, err)
+			}
+			dir = filepath.Join(cacheDir, "kcd", "agent")
+		}
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+		var store state.Store
+		if !agentDryRun {
+			var err error
+			if store, err = state.NewStore(agentState, agentStateFile); err != nil {
+				return err
+			}
+		}
+		a := &agent.Agent{
+			Repo:             &agent.Repo{URL: agentRepo, Branch: agentBranch, Dir: dir},
+			EnvironmentsFile: environmentsFile,
+			Cluster:          agentCluster,
+			Apply:            agentApplyRelease,
+			Store:            store,
+			Logf:             logger.Printf,
+		}
+		if agentInit {
+			a.Init = agentInitEnvironments
+		}
+		if agentOnce {
+			return a.Sync()
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/status", a)
+		server := &http.Server{Addr: agentListen, Handler: mux}
+		serveErr := make(chan error, 1)
+		go func() {
+			logger.Printf("serving status on %s", agentListen)
+			serveErr <- server.ListenAndServe()
+		}()
+		stopAgent := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			a.Run(agentInterval, stopAgent)
+		}()
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		var err error
+		select {
+		case err = <-serveErr:
+		case sig := <-stop:
+			logger.Printf("received %s, shutting down", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = server.Shutdown(ctx)
+			cancel()
+		}
+		close(stopAgent)
+		<-done
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	},
+}
+
+func agentApplyRelease(release *model.Release) error {
+	commands, err := helm.DeployCommands(release.Environment, agentDryRun, false, []string{release.Name})
+	if err != nil {
+		return err
+	}
+	for _, argv := range commands {
+		if err = runCommand(false, false, argv); err != nil {
+			return err
+		}
+	}
+	if !agentDryRun && release.ShouldWait(agentWait) {
+		return waitForRollout(release, agentTimeout, os.Stdout)
+	}
+	return nil
+}
+
+func agentInitEnvironments(kcdConfig *model.KubeCDConfig, envs []*model.Environment) error {
+	commands := helm.RepoSetupCommands(kcdConfig.HelmRepos)
+	initCmds, err := commandsToInit(envs, agentGitlab)
+	if err != nil {
+		return err
+	}
+	for _, argv := range append(commands, initCmds...) {
+		if err = runCommand(false, false, argv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.Flags().StringVar(&agentRepo, "repo", "", "git repository to fetch, as a URL or local path")
+	agentCmd.Flags().StringVar(&agentBranch, "branch", "", "branch to apply (default the repository's HEAD)")
+	agentCmd.Flags().StringVar(&agentDir, "checkout-dir", "", "directory to check out the repository in (default kcd/agent in the user's cache directory)")
+	agentCmd.Flags().StringVarP(&agentCluster, "cluster", "c", os.Getenv("KCD_CLUSTER"), "cluster the agent runs in (default $KCD_CLUSTER, or the cluster of the current kubectl context)")
+	agentCmd.Flags().DurationVar(&agentInterval, "interval", time.Minute, "how often to fetch the repository")
+	agentCmd.Flags().StringVar(&agentListen, "listen", ":8081", "address to serve the status on")
+	agentCmd.Flags().BoolVar(&agentInit, "init", false, "initialize credentials and contexts before applying a new commit")
+	agentCmd.Flags().BoolVar(&agentGitlab, "gitlab", false, "initialize in gitlab mode")
+	agentCmd.Flags().BoolVarP(&agentDryRun, "dry-run", "n", false, "run helm and kubectl in dry run mode")
+	agentCmd.Flags().BoolVar(&agentWait, "wait", false, "wait for the workloads of each release to become ready")
+	agentCmd.Flags().DurationVar(&agentTimeout, "timeout", 5*time.Minute, "how long to wait for the workloads of a release with --wait")
+	agentCmd.Flags().StringVar(&agentState, "state", state.KindConfigMap, "where to store fingerprints of applied releases: configmap, secret, file or none")
+	agentCmd.Flags().StringVar(&agentStateFile, "state-file", "kcd-state.json", "state file used with --state file")
+	agentCmd.Flags().BoolVar(&agentOnce, "once", false, "sync once and exit, without serving the status")
+}
@@ -19,8 +19,16 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
 	"github.com/kubecd/kubecd/pkg/helm"
 	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/output"
+	"github.com/kubecd/kubecd/pkg/rollout"
+	"github.com/kubecd/kubecd/pkg/state"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -31,14 +39,36 @@ var applyInit bool
 var applyGitlab bool
 var applyDryRun bool
 var applyDebug bool
+var applyOutput string
+var applyAffectedSince string
+var applyForce bool
+var applyState string
+var applyStateFile string
+var applyParallelism int
+var applyWait bool
+var applyTimeout time.Duration
 
 // applyCmd represents the apply command
 var applyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "apply changes to Kubernetes",
-	Long:  ``,
-	Args:  clusterFlagOrEnvArg(&applyCluster),
+	Long: `Applies releases with "helm upgrade" or "kubectl apply".
+
+The fingerprint of each applied release, a hash of its chart, values and
+resource files, is stored in a ConfigMap (or Secret) in the environment's
+namespace, or in a local state file, and releases whose fingerprint did not
+change since they were last applied are skipped unless --force is given. Dry
+runs neither read nor update the stored fingerprints.
+
+With --wait, or "wait: true" on a release, apply waits for the Deployments,
+StatefulSets, DaemonSets and Jobs of each release to become ready, and fails
+the release if they do not within --timeout (or the release's "timeout"), or
+if a pod of their new revision is in CrashLoopBackOff.`,
+	Args: matchAll(clusterFlagOrEnvArg(&applyCluster), outputFormat(&applyOutput)),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if applyParallelism < 1 {
+			return fmt.Errorf(`--parallelism must be at least 1`)
+		}
 		kcdConfig, err := model.NewConfigFromFile(environmentsFile)
 		if err != nil {
 			return err
@@ -47,36 +77,154 @@ var applyCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		commandsToRun, err := commandsToApply(envsToApply)
+		envsToApply, releasesToApply, err := affectedReleases(kcdConfig, envsToApply, applyReleases, applyAffectedSince)
 		if err != nil {
 			return err
 		}
-		for _, argv := range commandsToRun {
-			if err = runCommand(false, false, argv); err != nil {
-				return err
-			}
+		result := output.Commands{}
+		result.Commands, err = applyChangedReleases(kcdConfig, envsToApply, releasesToApply)
+		if applyOutput == output.FormatText {
+			return err
+		}
+		if writeErr := output.Write(os.Stdout, applyOutput, result); writeErr != nil && err == nil {
+			err = writeErr
 		}
-		return nil
+		return err
 	},
 }
 
-func commandsToApply(envsToApply []*model.Environment) ([][]string, error) {
-	commandsToRun := make([][]string, 0)
-	for _, env := range envsToApply {
-		if applyInit {
-			initCmds, err := commandsToInit(envsToApply, applyGitlab)
-			if err != nil {
-				return nil, err
-			}
-			commandsToRun = append(commandsToRun, initCmds...)
-		}
-		deployCmds, err := helm.DeployCommands(env, applyDryRun, applyDebug, applyReleases)
+// applyChangedReleases runs the init commands with --init, which create the
+// contexts used for loading the stored fingerprints, and then applies the
+// releases whose fingerprints changed. Dry runs neither read nor update the
+// stored fingerprints.
+func applyChangedReleases(kcdConfig *model.KubeCDConfig, envsToApply []*model.Environment, releasesToApply map[string][]string) ([]output.Command, error) {
+	runner := &applyRunner{}
+	if applyInit {
+		initCmds, err := commandsToInit(envsToApply, applyGitlab)
 		if err != nil {
 			return nil, err
 		}
-		commandsToRun = append(commandsToRun, deployCmds...)
+		for _, argv := range initCmds {
+			if err = runner.run(argv, os.Stdout, os.Stderr); err != nil {
+				return runner.records, err
+			}
+		}
+	}
+	storeKind := applyState
+	if applyDryRun {
+		storeKind = state.KindNone
+	}
+	store, err := state.NewStore(storeKind, applyStateFile)
+	if err != nil {
+		return runner.records, err
+	}
+	err = runner.applyReleases(kcdConfig, store, envsToApply, releasesToApply)
+	return runner.records, err
+}
+
+// newRolloutClient returns a client for a kubectl context, replaced in tests
+var newRolloutClient = rollout.NewClient
+
+// waitForRollout waits for the Deployments, StatefulSets, DaemonSets and Jobs
+// of an applied release to become ready, writing their progress to out.
+func waitForRollout(release *model.Release, defaultTimeout time.Duration, out io.Writer) error {
+	env := release.Environment
+	client, err := newRolloutClient(model.KubeContextName(env.Name))
+	if err != nil {
+		return err
+	}
+	var workloads []rollout.Workload
+	if release.Chart != nil {
+		workloads, err = rollout.ReleaseWorkloads(client, env.KubeNamespace, release.Name)
+	} else {
+		files := make([]string, len(release.ResourceFiles))
+		for i, path := range release.ResourceFiles {
+			files[i] = release.AbsPath(path)
+		}
+		workloads, err = rollout.ResourceFileWorkloads(files, env.KubeNamespace)
+	}
+	if err != nil || len(workloads) == 0 {
+		return err
+	}
+	timeout := release.WaitTimeout(defaultTimeout)
+	_, _ = fmt.Fprintf(out, "waiting up to %s for %d workloads to become ready\n", timeout, len(workloads))
+	waiter := &rollout.Waiter{Client: client, Out: out}
+	return waiter.Wait(workloads, timeout)
+}
+
+// applyRunner runs the commands of kcd apply, recording them for the output.
+type applyRunner struct {
+	mu, outputMu sync.Mutex
+	records      []output.Command
+}
+
+func (r *applyRunner) run(argv []string, stdout, stderr io.Writer) error {
+	if applyOutput != output.FormatText {
+		stdout = stderr
+	}
+	record, err := runCommandOutput(false, false, argv, stdout, stderr)
+	r.mu.Lock()
+	r.records = append(r.records, record)
+	r.mu.Unlock()
+	return err
+}
+
+// applyReleases applies the given releases of each environment, or all of its
+// releases if none are given, that changed since they were last applied. They
+// are applied after the releases they depend on and at most --parallelism at a
+// time. When a release fails, the releases depending on it are not applied,
+// while other releases are.
+func (r *applyRunner) applyReleases(kcdConfig *model.KubeCDConfig, store state.Store, envs []*model.Environment, releasesToApply map[string][]string) error {
+	info := infoWriter(applyOutput)
+	result, err := state.ApplyChanged(store, kcdConfig, envs, state.ApplyOptions{
+		Releases:    releasesToApply,
+		Force:       applyForce,
+		Parallelism: applyParallelism,
+		Apply:       r.applyRelease,
+		Skipped: func(release *model.Release) {
+			_, _ = fmt.Fprintf(info, "env %q release %q is unchanged, skipping\n", release.Environment.Name, release.Name)
+		},
+	})
+	if result == nil {
+		return err
+	}
+	var failures []error
+	for _, release := range result.Releases {
+		if releaseErr := result.Errors[release.ID()]; releaseErr != nil {
+			failures = append(failures, fmt.Errorf(`release %q: %v`, release.ID(), releaseErr))
+		}
+	}
+	if err != nil {
+		failures = append(failures, err)
+	}
+	if len(failures) > 0 {
+		return model.NewAggregateError(failures)
+	}
+	return nil
+}
+
+// applyRelease runs the commands applying a release, with their output
+// prefixed with its name, and waits for it to become ready with --wait.
+func (r *applyRunner) applyRelease(release *model.Release) error {
+	commands, err := helm.DeployCommands(release.Environment, applyDryRun, applyDebug, []string{release.Name})
+	if err != nil {
+		return err
+	}
+	stdout := newPrefixWriter(os.Stdout, &r.outputMu, release.ID())
+	stderr := newPrefixWriter(os.Stderr, &r.outputMu, release.ID())
+	defer func() {
+		_ = stdout.Flush()
+		_ = stderr.Flush()
+	}()
+	for _, argv := range commands {
+		if err = r.run(argv, stdout, stderr); err != nil {
+			return err
+		}
+	}
+	if !applyDryRun && release.ShouldWait(applyWait) {
+		return waitForRollout(release, applyTimeout, stdout)
 	}
-	return commandsToRun, nil
+	return nil
 }
 
 func environmentsFromArgs(kcdConfig *model.KubeCDConfig, cluster string, args []string) ([]*model.Environment, error) {
@@ -109,4 +257,12 @@ func init() {
 	applyCmd.Flags().StringVarP(&applyCluster, "cluster", "c", "", "apply all environments in CLUSTER")
 	applyCmd.Flags().BoolVar(&applyInit, "init", false, "initialize credentials and contexts")
 	applyCmd.Flags().BoolVar(&applyGitlab, "gitlab", false, "initialize in gitlab mode")
+	applyCmd.Flags().BoolVar(&applyForce, "force", false, "apply releases even if they did not change since they were last applied")
+	applyCmd.Flags().StringVar(&applyState, "state", state.KindConfigMap, "where to store fingerprints of applied releases: configmap, secret, file or none")
+	applyCmd.Flags().StringVar(&applyStateFile, "state-file", "kcd-state.json", "state file used with --state file")
+	applyCmd.Flags().BoolVar(&applyWait, "wait", false, "wait for the workloads of each release to become ready")
+	applyCmd.Flags().DurationVar(&applyTimeout, "timeout", 5*time.Minute, "how long to wait for the workloads of a release with --wait")
+	applyCmd.Flags().IntVar(&applyParallelism, "parallelism", 1, "how many releases to apply at a time, in the order of their dependsOn")
+	addAffectedSinceFlag(applyCmd, &applyAffectedSince)
+	addOutputFlag(applyCmd, &applyOutput)
 }
@@ -0,0 +1,245 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/rollout"
+	"github.com/kubecd/kubecd/pkg/state"
+)
+
+// fakeKubectl is a kubectl logging its arguments to $KCD_TEST_LOG, which
+// fails to get objects until a context was set
+const fakeKubectl = `#!/bin/sh
+echo "$*" >> "$KCD_TEST_LOG"
+case "$*" in
+*" get "*) grep -q set-context "$KCD_TEST_LOG" || { echo "context not found" >&2; exit 1; } ;;
+esac
+cat > /dev/null
+`
+
+// useFakeKubectl puts a kubectl script in dir first in $PATH, and returns the
+// file it may log to, along with a function restoring the environment
+func useFakeKubectl(t *testing.T, dir, script string) (string, func()) {
+	binDir := filepath.Join(dir, "bin")
+	require.NoError(t, os.Mkdir(binDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "kubectl"), []byte(script), 0755))
+	logFile := filepath.Join(dir, "kubectl.log")
+	oldPath := os.Getenv("PATH")
+	require.NoError(t, os.Setenv("PATH", binDir+string(os.PathListSeparator)+oldPath))
+	require.NoError(t, os.Setenv("KCD_TEST_LOG", logFile))
+	return logFile, func() {
+		_ = os.Setenv("PATH", oldPath)
+		_ = os.Unsetenv("KCD_TEST_LOG")
+	}
+}
+
+func TestApplyChangedReleasesInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-apply")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	files := map[string]string{
+		"environments.yaml": `
+clusters:
+  - name: test
+    provider:
+      minikube: {}
+environments:
+  - name: test
+    clusterName: test
+    kubeNamespace: default
+    releasesFiles: [releases.yaml]
+`,
+		"releases.yaml": "releases:\n  - name: app\n    resourceFiles: [app.yaml]\n",
+		"app.yaml":      "kind: Deployment",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	logFile, restore := useFakeKubectl(t, dir, fakeKubectl)
+	defer restore()
+	oldInit, oldState, oldDryRun, oldParallelism := applyInit, applyState, applyDryRun, applyParallelism
+	defer func() {
+		applyInit, applyState, applyDryRun, applyParallelism = oldInit, oldState, oldDryRun, oldParallelism
+	}()
+	applyInit, applyState, applyDryRun, applyParallelism = true, state.KindConfigMap, false, 1
+
+	kcdConfig, err := model.NewConfigFromFile(filepath.Join(dir, "environments.yaml"))
+	require.NoError(t, err)
+	records, err := applyChangedReleases(kcdConfig, kcdConfig.Environments, map[string][]string{})
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	appFile := filepath.Join(dir, "app.yaml")
+	log, err := ioutil.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Equal(t, `config set-context env:test --cluster minikube --user minikube --namespace default
+--context env:test --namespace default get configmap kcd-fingerprints-test --ignore-not-found --output json
+--context env:test apply -f `+appFile+`
+--context env:test apply --filename -
+`, string(log))
+
+	require.NoError(t, os.Remove(logFile))
+	applyInit, applyDryRun = false, true
+	_, err = applyChangedReleases(kcdConfig, kcdConfig.Environments, map[string][]string{})
+	require.NoError(t, err)
+	log, err = ioutil.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Equal(t, "--context env:test apply --dry-run -f "+appFile+"\n", string(log), "dry runs do not use the stored fingerprints")
+}
+
+func TestApplyReleases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-apply")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	files := map[string]string{
+		"environments.yaml": `
+clusters:
+  - name: test
+    provider:
+      minikube: {}
+environments:
+  - name: test
+    clusterName: test
+    kubeNamespace: default
+    releasesFiles: [releases.yaml]
+`,
+		"releases.yaml": `
+releases:
+  - name: crds
+    resourceFiles: [crds.yaml]
+  - name: operator
+    resourceFiles: [operator.yaml]
+    dependsOn: [crds]
+  - name: app
+    resourceFiles: [app.yaml]
+    dependsOn: [operator]
+  - name: web
+    resourceFiles: [web.yaml]
+`,
+	}
+	for _, name := range []string{"crds", "operator", "app", "web"} {
+		files[name+".yaml"] = "kind: Deployment"
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	_, restore := useFakeKubectl(t, dir, "#!/bin/sh\ncase \"$*\" in *operator.yaml*) echo \"no operator\" >&2; exit 1 ;; esac\n")
+	defer restore()
+	oldParallelism := applyParallelism
+	defer func() { applyParallelism = oldParallelism }()
+	applyParallelism = 2
+
+	kcdConfig, err := model.NewConfigFromFile(filepath.Join(dir, "environments.yaml"))
+	require.NoError(t, err)
+	store := &state.FileStore{Path: filepath.Join(dir, "state.json")}
+	runner := &applyRunner{}
+	err = runner.applyReleases(kcdConfig, store, kcdConfig.Environments, map[string][]string{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `release "test/operator": command failed`)
+	assert.Contains(t, err.Error(), `release "test/app": not run, as "test/operator" failed`)
+	assert.Len(t, runner.records, 3)
+	stored, err := store.Load(kcdConfig.Environments[0])
+	require.NoError(t, err)
+	assert.Len(t, stored, 2)
+	assert.Contains(t, stored, "crds")
+	assert.Contains(t, stored, "web")
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	w := newPrefixWriter(&out, &mu, "test/app")
+	_, _ = w.Write([]byte("Release \"app\" has been "))
+	_, _ = w.Write([]byte("upgraded.\nREVISION: 2\nSTATUS"))
+	assert.Equal(t, "[test/app] Release \"app\" has been upgraded.\n[test/app] REVISION: 2\n", out.String())
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "[test/app] Release \"app\" has been upgraded.\n[test/app] REVISION: 2\n[test/app] STATUS\n", out.String())
+}
+
+// testRolloutClient returns the same Deployment for any workload
+type testRolloutClient struct {
+	deployment *appsv1.Deployment
+}
+
+func (c *testRolloutClient) Get(workload rollout.Workload) (runtime.Object, error) {
+	return c.deployment, nil
+}
+
+func (c *testRolloutClient) List(namespace string) ([]runtime.Object, error) {
+	return []runtime.Object{c.deployment}, nil
+}
+
+func (c *testRolloutClient) Pods(namespace string, selector *metav1.LabelSelector) ([]corev1.Pod, error) {
+	return nil, nil
+}
+
+func (c *testRolloutClient) ReplicaSets(namespace string, selector *metav1.LabelSelector) ([]appsv1.ReplicaSet, error) {
+	return nil, nil
+}
+
+func (c *testRolloutClient) ControllerRevisions(namespace string, selector *metav1.LabelSelector) ([]appsv1.ControllerRevision, error) {
+	return nil, nil
+}
+
+func TestWaitForRollout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-wait")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "web.yaml"), []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n"), 0644))
+	env := &model.Environment{Name: "test", KubeNamespace: "default"}
+	release := &model.Release{Name: "web", ResourceFiles: []string{"web.yaml"}, FromFile: filepath.Join(dir, "releases.yaml"), Environment: env}
+	replicas := int32(1)
+	client := &testRolloutClient{deployment: &appsv1.Deployment{
+		Spec:   appsv1.DeploymentSpec{Replicas: &replicas},
+		Status: appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1},
+	}}
+	oldNewRolloutClient := newRolloutClient
+	defer func() { newRolloutClient = oldNewRolloutClient }()
+	var contextName string
+	newRolloutClient = func(name string) (rollout.Client, error) {
+		contextName = name
+		return client, nil
+	}
+
+	var out bytes.Buffer
+	err = waitForRollout(release, time.Nanosecond, &out)
+	assert.EqualError(t, err, "timed out after 1ns waiting for deployment/web")
+	assert.Equal(t, "env:test", contextName)
+	assert.Equal(t, "waiting up to 1ns for 1 workloads to become ready\ndeployment/web: 0 of 1 updated replicas available\n", out.String())
+
+	client.deployment.Status.AvailableReplicas = 1
+	out.Reset()
+	assert.NoError(t, waitForRollout(release, time.Minute, &out))
+	assert.Contains(t, out.String(), "deployment/web: ready, 1 of 1 replicas available\n")
+}
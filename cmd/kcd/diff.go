@@ -0,0 +1,121 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+var (
+	diffReleases      []string
+	diffCluster       string
+	diffExitCode      bool
+	diffAffectedSince string
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [ENV]",
+	Short: "show what would change in the cluster by applying",
+	Long: `Runs "helm diff upgrade" for every chart release and "kubectl diff" for every
+resourceFiles release, and summarizes which releases differ per environment.
+Requires the helm-diff plugin (https://github.com/databus23/helm-diff).`,
+	Args: clusterFlagOrEnvArg(&diffCluster),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kcdConfig, err := model.NewConfigFromFile(environmentsFile)
+		if err != nil {
+			return err
+		}
+		envsToDiff, err := environmentsFromArgs(kcdConfig, diffCluster, args)
+		if err != nil {
+			return err
+		}
+		envsToDiff, releasesToDiff, err := affectedReleases(kcdConfig, envsToDiff, diffReleases, diffAffectedSince)
+		if err != nil {
+			return err
+		}
+		var failures []error
+		changedReleases := make(map[string][]string)
+		failedReleases := make(map[string][]string)
+		for _, env := range envsToDiff {
+			diffCmds, err := helm.DiffCommands(env, releasesToDiff[env.Name])
+			if err != nil {
+				return err
+			}
+			for _, diffCmd := range diffCmds {
+				exitCode, err := runDiffCommand(diffCmd.Argv)
+				if err == nil && exitCode != 0 && exitCode != diffCmd.ChangedExitCode {
+					err = fmt.Errorf(`env %q release %q: %q failed with exit status %d`, env.Name, diffCmd.Release.Name, strings.Join(diffCmd.Argv, " "), exitCode)
+				}
+				if err != nil {
+					failures = append(failures, err)
+					failedReleases[env.Name] = append(failedReleases[env.Name], diffCmd.Release.Name)
+				} else if exitCode == diffCmd.ChangedExitCode {
+					changedReleases[env.Name] = append(changedReleases[env.Name], diffCmd.Release.Name)
+				}
+			}
+		}
+		numChanged := 0
+		for _, env := range envsToDiff {
+			changed, failed := changedReleases[env.Name], failedReleases[env.Name]
+			if len(changed) > 0 {
+				fmt.Printf("env %s: %d release(s) differ: %s\n", env.Name, len(changed), strings.Join(changed, ", "))
+				numChanged += len(changed)
+			}
+			if len(failed) > 0 {
+				fmt.Printf("env %s: %d release(s) failed: %s\n", env.Name, len(failed), strings.Join(failed, ", "))
+			}
+			if len(changed) == 0 && len(failed) == 0 {
+				fmt.Printf("env %s: no differences\n", env.Name)
+			}
+		}
+		if len(failures) > 0 {
+			return model.NewAggregateError(failures)
+		}
+		if diffExitCode && numChanged > 0 {
+			return fmt.Errorf(`found differences in %d release(s)`, numChanged)
+		}
+		return nil
+	},
+}
+
+// runDiffCommand runs a diff command and returns its exit status, which tells
+// whether there are differences, so it is only an error if it did not exit.
+func runDiffCommand(argv []string) (int, error) {
+	record, err := runCommandOutput(false, false, argv, os.Stdout, os.Stderr)
+	if record.ExitStatus > 0 {
+		return record.ExitStatus, nil
+	}
+	return record.ExitStatus, err
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringSliceVarP(&diffReleases, "releases", "r", []string{}, "diff only these releases")
+	diffCmd.Flags().StringVarP(&diffCluster, "cluster", "c", "", "diff all environments in CLUSTER")
+	addAffectedSinceFlag(diffCmd, &diffAffectedSince)
+	diffCmd.Flags().BoolVar(&diffExitCode, "exit-code", false, "exit with a non-zero status if any differences were found")
+}
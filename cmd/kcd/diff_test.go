@@ -0,0 +1,38 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDiffCommand(t *testing.T) {
+	exitCode, err := runDiffCommand([]string{"true"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+
+	exitCode, err = runDiffCommand([]string{"sh", "-c", "exit 2"})
+	assert.NoError(t, err, "exiting with a status tells whether there are differences")
+	assert.Equal(t, 2, exitCode)
+
+	exitCode, err = runDiffCommand([]string{"kcd-no-such-command"})
+	assert.Error(t, err)
+	assert.Equal(t, -1, exitCode)
+}
@@ -18,29 +18,202 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/model"
 )
 
+var (
+	dumpReleases []string
+	dumpOutput   string
+)
+
+type dumpValue struct {
+	Key    string      `json:"key"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+	File   string      `json:"file,omitempty"`
+}
+
+type dumpRelease struct {
+	Name              string                       `json:"name"`
+	FromFile          string                       `json:"fromFile"`
+	Chart             *model.Chart                 `json:"chart,omitempty"`
+	DefaultValuesFile string                       `json:"defaultValuesFile,omitempty"`
+	ValuesFile        string                       `json:"valuesFile,omitempty"`
+	ResourceFiles     []string                     `json:"resourceFiles,omitempty"`
+	DependsOn         []string                     `json:"dependsOn,omitempty"`
+	Wait              *bool                        `json:"wait,omitempty"`
+	Timeout           string                       `json:"timeout,omitempty"`
+	Triggers          []model.ReleaseUpdateTrigger `json:"triggers,omitempty"`
+	Values            []dumpValue                  `json:"values,omitempty"`
+}
+
+type dumpEnvironment struct {
+	Name          string         `json:"name"`
+	FromFile      string         `json:"fromFile"`
+	KubeNamespace string         `json:"kubeNamespace"`
+	ReleasesFiles []string       `json:"releasesFiles,omitempty"`
+	Cluster       *model.Cluster `json:"cluster"`
+	Releases      []dumpRelease  `json:"releases"`
+}
+
+type dumpDocument struct {
+	Environments []dumpEnvironment `json:"environments"`
+}
+
 // dumpCmd represents the dump command
 var dumpCmd = &cobra.Command{
-	Use:   "dump",
-	Short: "[not yet implemented]",
-	Long:  ``,
+	Use:   "dump [ENV]",
+	Short: "dump the fully resolved environments and releases",
+	Long: `Prints every environment and release after all releases files have been loaded,
+with absolute paths, the environment's cluster and the merged chart values of each
+release. Every value is annotated with where it was defined.`,
+	Args: cobra.RangeArgs(0, 1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return NotYetImplementedError("dump")
+		if dumpOutput != "yaml" && dumpOutput != "json" {
+			return fmt.Errorf(`unknown output format %q, must be "yaml" or "json"`, dumpOutput)
+		}
+		kcdConfig, err := model.NewConfigFromFile(environmentsFile)
+		if err != nil {
+			return err
+		}
+		envs := kcdConfig.Environments
+		if len(args) == 1 {
+			env := kcdConfig.GetEnvironment(args[0])
+			if env == nil {
+				return fmt.Errorf(`unknown environment: %q`, args[0])
+			}
+			envs = []*model.Environment{env}
+		}
+		doc, err := makeDumpDocument(envs, dumpReleases)
+		if err != nil {
+			return err
+		}
+		return writeDumpDocument(doc, dumpOutput)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(dumpCmd)
+	dumpCmd.Flags().StringSliceVarP(&dumpReleases, "releases", "r", []string{}, "dump only these releases")
+	dumpCmd.Flags().StringVarP(&dumpOutput, "output", "o", "yaml", "output format, one of: yaml, json")
+}
 
-	// Here you will define your flags and configuration settings.
+func makeDumpDocument(envs []*model.Environment, releaseNames []string) (*dumpDocument, error) {
+	doc := &dumpDocument{Environments: make([]dumpEnvironment, 0, len(envs))}
+	for _, env := range envs {
+		dumpEnv := dumpEnvironment{
+			Name:          env.Name,
+			FromFile:      absPath(env.FromFile()),
+			KubeNamespace: env.KubeNamespace,
+			Cluster:       env.GetCluster(),
+			Releases:      make([]dumpRelease, 0),
+		}
+		for _, file := range env.ReleasesFiles {
+			dumpEnv.ReleasesFiles = append(dumpEnv.ReleasesFiles, absPath(model.ResolvePathFromFile(file, env.FromFile())))
+		}
+		for _, release := range env.AllReleases() {
+			if len(releaseNames) > 0 && !stringInSlice(release.Name, releaseNames) {
+				continue
+			}
+			dumpRel, err := makeDumpRelease(release)
+			if err != nil {
+				return nil, err
+			}
+			dumpEnv.Releases = append(dumpEnv.Releases, *dumpRel)
+		}
+		doc.Environments = append(doc.Environments, dumpEnv)
+	}
+	return doc, nil
+}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// dumpCmd.PersistentFlags().String("foo", "", "A help for foo")
+func makeDumpRelease(release *model.Release) (*dumpRelease, error) {
+	dumpRel := &dumpRelease{
+		Name:      release.Name,
+		FromFile:  absPath(release.FromFile),
+		DependsOn: release.DependsOn,
+		Wait:      release.Wait,
+		Timeout:   release.Timeout,
+		Triggers:  release.Triggers,
+	}
+	if release.Chart != nil {
+		chart := *release.Chart
+		if chart.Dir != nil {
+			chartDir := absPath(release.AbsPath(*chart.Dir))
+			chart.Dir = &chartDir
+		}
+		dumpRel.Chart = &chart
+	}
+	if env := release.Environment; env.DefaultValuesFile != "" && !release.SkipDefaultValues {
+		dumpRel.DefaultValuesFile = absPath(release.AbsPath(env.DefaultValuesFile))
+	}
+	if release.ValuesFile != nil {
+		dumpRel.ValuesFile = absPath(release.AbsPath(*release.ValuesFile))
+	}
+	for _, file := range release.ResourceFiles {
+		dumpRel.ResourceFiles = append(dumpRel.ResourceFiles, absPath(release.AbsPath(file)))
+	}
+	if release.Chart == nil {
+		return dumpRel, nil
+	}
+	values, err := helm.GetResolvedValues(release)
+	if err != nil {
+		return nil, errors.Wrapf(err, `env %q release %q`, release.Environment.Name, release.Name)
+	}
+	sources, err := helm.GetResolvedValueSources(release)
+	if err != nil {
+		return nil, errors.Wrapf(err, `env %q release %q`, release.Environment.Name, release.Name)
+	}
+	for key, value := range helm.FlattenValues(values) {
+		source := sources[key]
+		var file string
+		if source.File != "" {
+			file = absPath(source.File)
+		}
+		dumpRel.Values = append(dumpRel.Values, dumpValue{Key: key, Value: value, Source: source.Kind, File: file})
+	}
+	sort.Slice(dumpRel.Values, func(i, j int) bool { return dumpRel.Values[i].Key < dumpRel.Values[j].Key })
+	return dumpRel, nil
+}
+
+func writeDumpDocument(doc *dumpDocument, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(doc)
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return errors.Wrap(err, `encoding YAML`)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// dumpCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+func stringInSlice(str string, slice []string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+	return false
 }
@@ -18,29 +18,61 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
+
+	"github.com/kubecd/kubecd/pkg/image"
+	"github.com/kubecd/kubecd/pkg/lint"
+	"github.com/kubecd/kubecd/pkg/model"
 )
 
+var lintListRules bool
+
 // lintCmd represents the lint command
 var lintCmd = &cobra.Command{
 	Use:   "lint",
-	Short: "[not yet implemented]",
-	Long:  ``,
+	Short: "check environments and releases for common mistakes",
+	Long: `Runs a set of rules against the environments file and all releases files,
+and exits with a non-zero status if any errors were found.
+
+Rules may be enabled or disabled in the environments file:
+
+lint:
+  rules:
+    unused-cluster: false
+`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return NotYetImplementedError("lint")
+		if lintListRules {
+			for _, rule := range lint.Rules() {
+				fmt.Printf("%-20s %-8s %s\n", rule.ID, rule.Severity, rule.Description)
+			}
+			return nil
+		}
+		kcdConfig, err := model.NewConfigFromFile(environmentsFile)
+		if err != nil {
+			return err
+		}
+		if issues := lint.CheckConfig(kcdConfig); len(issues) > 0 {
+			return model.NewAggregateError(issues)
+		}
+		if err = image.UseRegistries(kcdConfig.Registries); err != nil {
+			return err
+		}
+		findings := lint.Run(kcdConfig)
+		for _, finding := range findings {
+			fmt.Println(finding)
+		}
+		errorCount := lint.CountBySeverity(findings, lint.SeverityError)
+		if errorCount > 0 {
+			return fmt.Errorf(`lint found %d error(s) and %d warning(s)`, errorCount, lint.CountBySeverity(findings, lint.SeverityWarning))
+		}
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(lintCmd)
-
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// lintCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// lintCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	lintCmd.Flags().BoolVar(&lintListRules, "list-rules", false, "list available rules and exit")
 }
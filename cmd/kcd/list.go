@@ -19,24 +19,31 @@ package main
 
 import (
 	"fmt"
+	"os"
+
 	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/output"
 	"github.com/spf13/cobra"
 )
 
 var listLongDetails bool
+var listOutput string
 
 // listCmd represents the list command
 var listCmd = &cobra.Command{
 	Use:       "list {env,release,cluster}",
 	Short:     "list clusters, environments or releases",
 	Long:      ``,
-	Args:      matchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Args:      matchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs, outputFormat(&listOutput)),
 	ValidArgs: []string{"env", "envs", "release", "releases", "cluster", "clusters"},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		kcdConfig, err := model.NewConfigFromFile(environmentsFile)
 		if err != nil {
 			return err
 		}
+		if listOutput != output.FormatText {
+			return output.Write(os.Stdout, listOutput, makeListResult(kcdConfig, args[0]))
+		}
 		switch args[0] {
 		case "env", "envs":
 			for _, env := range kcdConfig.Environments {
@@ -61,8 +68,33 @@ var listCmd = &cobra.Command{
 	},
 }
 
+func makeListResult(kcdConfig *model.KubeCDConfig, what string) interface{} {
+	switch what {
+	case "env", "envs":
+		result := output.Environments{Environments: make([]output.Environment, 0)}
+		for _, env := range kcdConfig.Environments {
+			result.Environments = append(result.Environments, output.Environment{Name: env.Name, Cluster: env.ClusterName, KubeNamespace: env.KubeNamespace})
+		}
+		return result
+	case "release", "releases":
+		result := output.Releases{Releases: make([]output.Release, 0)}
+		for _, env := range kcdConfig.Environments {
+			for _, release := range env.AllReleases() {
+				result.Releases = append(result.Releases, output.Release{Environment: env.Name, Name: release.Name, File: release.FromFile})
+			}
+		}
+		return result
+	}
+	result := output.Clusters{Clusters: make([]output.Cluster, 0)}
+	for _, cluster := range kcdConfig.AllClusters() {
+		result.Clusters = append(result.Clusters, output.Cluster{Name: cluster.Name})
+	}
+	return result
+}
+
 func init() {
 	rootCmd.AddCommand(listCmd)
+	addOutputFlag(listCmd, &listOutput)
 
 	listCmd.Flags().BoolVarP(&listLongDetails, "long-details", "l", false, "Enable to get extra details (debugging)")
 	// Here you will define your flags and configuration settings.
@@ -19,15 +19,20 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 
+	"github.com/kubecd/kubecd/pkg/helm"
 	"github.com/kubecd/kubecd/pkg/image"
 	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/output"
 	"github.com/kubecd/kubecd/pkg/updates"
+	"github.com/kubecd/kubecd/pkg/yamlpatch"
 )
 
 var (
@@ -36,6 +41,7 @@ var (
 	observeImage    string
 	observeChart    string
 	observeVerify   bool
+	observeOutput   string
 )
 
 // observeCmd represents the observe command
@@ -43,16 +49,25 @@ var observeCmd = &cobra.Command{
 	Use:   "observe [ENV]",
 	Short: "observe a new version of an image or chart",
 	Long:  ``,
-	Args:  matchAll(cobra.RangeArgs(0, 1), imageOrChart(&observeImage, &observeChart)),
+	Args:  matchAll(cobra.RangeArgs(0, 1), imageOrChart(&observeImage, &observeChart), taggedImage(&observeImage), outputFormat(&observeOutput)),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		kcdConfig, err := model.NewConfigFromFile(environmentsFile)
 		if err != nil {
 			return err
 		}
+		if err = image.UseRegistries(kcdConfig.Registries); err != nil {
+			return err
+		}
+		var records []output.Update
 		if observeImage != "" {
-			return observeImageTag(kcdConfig, cmd, args)
+			records, err = observeImageTag(kcdConfig, cmd, args)
+		} else {
+			records, err = observeChartVersion(kcdConfig, args)
+		}
+		if err != nil || observeOutput == output.FormatText {
+			return err
 		}
-		return observeChartVersion(kcdConfig)
+		return output.Write(os.Stdout, observeOutput, output.Updates{Updates: records})
 	},
 }
 
@@ -63,6 +78,7 @@ func init() {
 	observeCmd.Flags().StringVar(&observeChart, "chart", "", "a new chart version")
 	observeCmd.Flags().BoolVar(&observePatch, "patch", false, "patch release files with updated tags")
 	observeCmd.Flags().BoolVar(&observeVerify, "verify", false, "verify that image:tag exists")
+	addOutputFlag(observeCmd, &observeOutput)
 }
 
 func observeVerifyImage(imageRepo string) error {
@@ -79,32 +95,50 @@ func observeVerifyImage(imageRepo string) error {
 	return fmt.Errorf(`tag %q not found for imageRepo %q`, imageRef.Tag, imageRef.WithoutTag())
 }
 
-func observeImageTag(kcdConfig *model.KubeCDConfig, cmd *cobra.Command, args []string) error {
+func observeImageTag(kcdConfig *model.KubeCDConfig, cmd *cobra.Command, args []string) ([]output.Update, error) {
 	if observeVerify {
 		if err := observeVerifyImage(observeImage); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	releaseFilters := makeObserveReleaseFilters(args)
+	return observeNewImage(infoWriter(observeOutput), kcdConfig, observeImage, makeObserveReleaseFilters(args), observePatch)
+}
+
+// observeNewImage updates the releases using an image to its new tag, if
+// their triggers want it, and patches their files if patch is set.
+func observeNewImage(out io.Writer, kcdConfig *model.KubeCDConfig, newImageRef string, releaseFilters []updates.ReleaseFilterFunc, patch bool) ([]output.Update, error) {
 	imageIndex, err := updates.ImageReleaseIndex(kcdConfig, releaseFilters...)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	newImage := image.NewDockerImageRef(newImageRef)
+	imageTags, err := updates.BuildTagIndexFromNewImageRef(newImage, imageIndex)
+	if err != nil {
+		return nil, err
 	}
-	newImage := image.NewDockerImageRef(observeImage)
-	imageTags := updates.BuildTagIndexFromNewImageRef(newImage, imageIndex)
 	allUpdates := make([]updates.ImageUpdate, 0)
 	for _, release := range imageIndex[newImage.WithoutTag()] {
 		imageUpdates, err := updates.FindImageUpdatesForRelease(release, imageTags)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		for i := range imageUpdates {
+			if imageUpdates[i].NewTag == newImage.Tag {
+				imageUpdates[i].NewDigest = newImage.Digest
+			}
 		}
 		allUpdates = append(allUpdates, imageUpdates...)
 	}
 	if len(allUpdates) == 0 {
-		fmt.Printf("No matching release found for image %s.\n", observeImage)
-		return nil
+		_, _ = fmt.Fprintf(out, "No matching release found for image %s.\n", newImageRef)
+	}
+	if err = updates.ResolveDigests(allUpdates); err != nil {
+		return nil, err
 	}
-	return patchReleasesFilesMaybe(allUpdates, observePatch)
+	if err = patchReleasesFilesMaybe(out, allUpdates, patch); err != nil {
+		return nil, err
+	}
+	return imageUpdateRecords(allUpdates, patch), nil
 }
 
 func makeObserveReleaseFilters(args []string) []updates.ReleaseFilterFunc {
@@ -118,6 +152,11 @@ func makeObserveReleaseFilters(args []string) []updates.ReleaseFilterFunc {
 	if observeImage != "" {
 		filters = append(filters, updates.ImageReleaseFilter(observeImage))
 	}
+	if observeChart != "" {
+		if chartRef, _, err := parseChartVersion(observeChart); err == nil {
+			filters = append(filters, updates.ChartReleaseFilter(chartRef))
+		}
+	}
 	return filters
 }
 
@@ -171,24 +210,40 @@ func makeObserveReleaseFilters(args []string) []updates.ReleaseFilterFunc {
 //	return nil
 //}
 
-func patchReleasesFilesMaybe(imageUpdates []updates.ImageUpdate, patch bool) error {
+func patchReleasesFilesMaybe(out io.Writer, imageUpdates []updates.ImageUpdate, patch bool) error {
 	verb := "May"
 	if patch {
 		verb = "Will"
 	}
 	updatesPerFile := make(map[string][]updates.ImageUpdate)
+	files := make([]string, 0)
 	for _, update := range imageUpdates {
-		fmt.Printf("%s update release %q image %q tag %s -> %s\n", verb, update.Release.Name, update.ImageRepo, update.OldTag, update.NewTag)
-		file := update.Release.FromFile
-		if _, found := updatesPerFile[file]; !found {
-			updatesPerFile[file] = make([]updates.ImageUpdate, 0)
+		oldTag := update.OldTag
+		if update.OldDigest != "" {
+			oldTag += "@" + update.OldDigest
+		}
+		newTag := update.NewTag
+		if update.NewDigest != "" {
+			newTag += "@" + update.NewDigest
+		}
+		fmt.Fprintf(out, "%s update release %q image %q tag %s -> %s\n", verb, update.Release.Name, update.ImageRepo, oldTag, newTag)
+		// the tag and digest may be defined in different files
+		updateFiles := make(map[string]bool)
+		for _, edit := range imageUpdateEdits(update) {
+			if updateFiles[edit.file] {
+				continue
+			}
+			updateFiles[edit.file] = true
+			if _, found := updatesPerFile[edit.file]; !found {
+				files = append(files, edit.file)
+			}
+			updatesPerFile[edit.file] = append(updatesPerFile[edit.file], update)
 		}
-		updatesPerFile[file] = append(updatesPerFile[file], update)
 	}
 	if patch {
-		for file, fileUpdates := range updatesPerFile {
-			fmt.Printf("Patching file: %s\n", file)
-			if err := patchImageUpdatesYamlNode(file, fileUpdates); err != nil {
+		for _, file := range files {
+			fmt.Fprintf(out, "Patching file: %s\n", file)
+			if err := patchImageUpdatesYamlNode(file, updatesPerFile[file]); err != nil {
 				return err
 			}
 		}
@@ -196,55 +251,137 @@ func patchReleasesFilesMaybe(imageUpdates []updates.ImageUpdate, patch bool) err
 	return nil
 }
 
-func patchImageUpdatesYamlNode(releasesFile string, imageUpdates []updates.ImageUpdate) error {
-	var doc yaml.Node
-	data, err := ioutil.ReadFile(releasesFile)
-	if err != nil {
-		return errors.Wrapf(err, `error reading %q`, releasesFile)
-	}
-	err = yaml.Unmarshal(data, &doc)
-	if err != nil {
-		return errors.Wrapf(err, `error decoding yaml in %q`, releasesFile)
-	}
-	releases := yamlNodeMapEntry(doc.Content[0], "releases")
-	if releases.Kind != yaml.SequenceNode {
-		return fmt.Errorf(`%s: "releases" is not a list`, releasesFile)
+// valueEdit is a value to set in the file defining it
+type valueEdit struct {
+	file   string
+	source helm.ValueSource
+	key    string
+	value  string
+}
+
+// imageUpdateEdits returns the edits of an image update: the tag value, and
+// the digest value for updates pinning digests to one.
+func imageUpdateEdits(update updates.ImageUpdate) []valueEdit {
+	edits := []valueEdit{{source: update.TagSource, key: update.TagValue, value: update.NewTagValue()}}
+	if update.DigestValue != "" && update.NewDigest != "" {
+		edits = append(edits, valueEdit{source: update.DigestSource, key: update.DigestValue, value: update.NewDigest})
 	}
-	madeChanges := false
-	for _, release := range releases.Content {
-		name := yamlNodeMapEntry(release, "name")
-		if name == nil || name.Kind != yaml.ScalarNode {
-			continue
+	for i := range edits {
+		edits[i].file = edits[i].source.File
+		if edits[i].file == "" {
+			edits[i].file = update.Release.FromFile
 		}
+	}
+	return edits
+}
+
+// patchImageUpdatesYamlNode patches the image tags and digests of updates that
+// are defined in a file, which depending on the source of each value is a
+// releases file, a values file or the environments file.
+func patchImageUpdatesYamlNode(file string, imageUpdates []updates.ImageUpdate) error {
+	return yamlpatch.PatchFile(file, func(doc *yamlpatch.Document) error {
 		for _, update := range imageUpdates {
-			if update.Release.Name != name.Value {
-				continue
-			}
-			values := yamlNodeMapEntry(release, "values")
-			if values == nil {
-				continue
-			}
-			for _, chartValue := range values.Content {
-				key := yamlNodeMapEntry(chartValue, "key")
-				value := yamlNodeMapEntry(chartValue, "value")
-				if key == nil || value == nil {
+			for _, edit := range imageUpdateEdits(update) {
+				if edit.file != file {
 					continue
 				}
-				if key.Value == update.TagValue {
-					value.Value = update.NewTag
-					madeChanges = true
+				if err := patchValueYamlNode(doc, update.Release, edit); err != nil {
+					return fmt.Errorf(`%s: %v`, file, err)
 				}
 			}
 		}
+		return nil
+	})
+}
+
+func patchValueYamlNode(doc *yamlpatch.Document, release *model.Release, edit valueEdit) error {
+	switch edit.source.Kind {
+	case helm.ValueSourceValuesFile:
+		return setYamlValueByPath(doc, doc.Root, strings.Split(edit.key, "."), edit.value)
+	case helm.ValueSourceDefaultValues:
+		envNode := yamlNodeListEntry(yamlNodeMapEntry(doc.Root, "environments"), "name", release.Environment.Name)
+		if envNode == nil {
+			return fmt.Errorf(`environment %q not found`, release.Environment.Name)
+		}
+		return setChartValueNode(doc, envNode, "defaultValues", edit.key, edit.value)
+	default:
+		releaseNode := yamlNodeListEntry(yamlNodeMapEntry(doc.Root, "releases"), "name", release.Name)
+		if releaseNode == nil {
+			return fmt.Errorf(`release %q not found`, release.Name)
+		}
+		return setChartValueNode(doc, releaseNode, "values", edit.key, edit.value)
 	}
-	if !madeChanges {
+}
+
+// yamlNodeListEntry returns the first mapping in a list with a given value for field.
+func yamlNodeListEntry(list *yaml.Node, field, value string) *yaml.Node {
+	if list == nil || list.Kind != yaml.SequenceNode {
 		return nil
 	}
-	return writeIndentedYamlToFile(releasesFile, &doc)
+	for _, entry := range list.Content {
+		if node := yamlNodeMapEntry(entry, field); node != nil && node.Kind == yaml.ScalarNode && node.Value == value {
+			return entry
+		}
+	}
+	return nil
+}
+
+// setChartValueNode sets a key/value entry in a list of chart values (like a
+// release's "values" or an environment's "defaultValues"), adding the entry
+// and the list itself if they do not exist.
+func setChartValueNode(doc *yamlpatch.Document, parent *yaml.Node, listName, key, value string) error {
+	list := yamlNodeMapEntry(parent, listName)
+	if list == nil || list.Kind != yaml.SequenceNode {
+		list = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if err := doc.SetMapEntry(parent, listName, list); err != nil {
+			return err
+		}
+	}
+	for _, chartValue := range list.Content {
+		keyNode := yamlNodeMapEntry(chartValue, "key")
+		valueNode := yamlNodeMapEntry(chartValue, "value")
+		if keyNode != nil && valueNode != nil && keyNode.Value == key {
+			// make sure values like "1.10" are not read back as numbers
+			valueNode.Tag = "!!str"
+			return doc.SetScalar(valueNode, value)
+		}
+	}
+	entry := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	entry.Content = append(entry.Content, yamlStringNode("key"), yamlStringNode(key), yamlStringNode("value"), yamlStringNode(value))
+	return doc.AppendToSequence(list, entry)
+}
+
+// setYamlValueByPath sets a value in nested mappings, such as a Helm values file,
+// creating any missing mappings along the way.
+func setYamlValueByPath(doc *yamlpatch.Document, node *yaml.Node, path []string, value string) error {
+	for i, name := range path {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf(`%q is not a map`, strings.Join(path[:i], "."))
+		}
+		child := yamlNodeMapEntry(node, name)
+		if i == len(path)-1 {
+			if child != nil && child.Kind != yaml.ScalarNode {
+				return fmt.Errorf(`%q is not a scalar`, strings.Join(path, "."))
+			}
+			return doc.SetMapEntry(node, name, yamlStringNode(value))
+		}
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			if err := doc.SetMapEntry(node, name, child); err != nil {
+				return err
+			}
+		}
+		node = child
+	}
+	return nil
+}
+
+func yamlStringNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
 }
 
 func yamlNodeMapEntry(node *yaml.Node, name string) *yaml.Node {
-	if node.Kind == yaml.MappingNode {
+	if node != nil && node.Kind == yaml.MappingNode {
 		for i := 0; i < len(node.Content); i += 2 {
 			if node.Content[i].Kind == yaml.ScalarNode && node.Content[i].Value == name {
 				return node.Content[i+1]
@@ -254,8 +391,88 @@ func yamlNodeMapEntry(node *yaml.Node, name string) *yaml.Node {
 	return nil
 }
 
-func observeChartVersion(kcdConfig *model.KubeCDConfig) error {
-	return NotYetImplementedError("observe --chart")
+// parseChartVersion splits a "reference:version" string, such as "stable/nginx-ingress:1.2.3".
+func parseChartVersion(chartVersion string) (string, string, error) {
+	colonIndex := strings.LastIndexByte(chartVersion, ':')
+	if colonIndex <= 0 || colonIndex == len(chartVersion)-1 {
+		return "", "", fmt.Errorf(`chart %q must be on the form "reference:version"`, chartVersion)
+	}
+	return chartVersion[:colonIndex], chartVersion[colonIndex+1:], nil
+}
+
+func observeChartVersion(kcdConfig *model.KubeCDConfig, args []string) ([]output.Update, error) {
+	chartRef, version, err := parseChartVersion(observeChart)
+	if err != nil {
+		return nil, err
+	}
+	chartIndex := updates.ChartReleaseIndex(kcdConfig, makeObserveReleaseFilters(args)...)
+	allUpdates := make([]updates.ChartUpdate, 0)
+	for _, release := range chartIndex[chartRef] {
+		chartUpdates, err := updates.FindChartUpdatesForRelease(release, []string{version}, nil)
+		if err != nil {
+			return nil, err
+		}
+		allUpdates = append(allUpdates, chartUpdates...)
+	}
+	out := infoWriter(observeOutput)
+	if len(allUpdates) == 0 {
+		_, _ = fmt.Fprintf(out, "No matching release found for chart %s.\n", observeChart)
+	}
+	if err = patchChartReleasesFilesMaybe(out, allUpdates, observePatch); err != nil {
+		return nil, err
+	}
+	return chartUpdateRecords(allUpdates, observePatch), nil
+}
+
+func patchChartReleasesFilesMaybe(out io.Writer, chartUpdates []updates.ChartUpdate, patch bool) error {
+	verb := "May"
+	if patch {
+		verb = "Will"
+	}
+	updatesPerFile := make(map[string][]updates.ChartUpdate)
+	files := make([]string, 0)
+	for _, update := range chartUpdates {
+		fmt.Fprintf(out, "%s update release %q chart %q version %s -> %s\n", verb, update.Release.Name, update.Chart, update.OldVersion, update.NewVersion)
+		file := update.Release.FromFile
+		if _, found := updatesPerFile[file]; !found {
+			files = append(files, file)
+		}
+		updatesPerFile[file] = append(updatesPerFile[file], update)
+	}
+	if patch {
+		for _, file := range files {
+			fmt.Fprintf(out, "Patching file: %s\n", file)
+			if err := patchChartUpdatesYamlNode(file, updatesPerFile[file]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func patchChartUpdatesYamlNode(releasesFile string, chartUpdates []updates.ChartUpdate) error {
+	return yamlpatch.PatchFile(releasesFile, func(doc *yamlpatch.Document) error {
+		releases := yamlNodeMapEntry(doc.Root, "releases")
+		if releases == nil || releases.Kind != yaml.SequenceNode {
+			return fmt.Errorf(`%s: "releases" is not a list`, releasesFile)
+		}
+		for _, update := range chartUpdates {
+			release := yamlNodeListEntry(releases, "name", update.Release.Name)
+			if release == nil {
+				continue
+			}
+			version := yamlNodeMapEntry(yamlNodeMapEntry(release, "chart"), "version")
+			if version == nil || version.Kind != yaml.ScalarNode {
+				return fmt.Errorf(`%s: release %q has no chart.version`, releasesFile, update.Release.Name)
+			}
+			// make sure versions like "1.10" are not read back as numbers
+			version.Tag = "!!str"
+			if err := doc.SetScalar(version, update.NewVersion); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func imageOrChart(image, chart *string) cobra.PositionalArgs {
@@ -266,3 +483,21 @@ func imageOrChart(image, chart *string) cobra.PositionalArgs {
 		return nil
 	}
 }
+
+// taggedImage checks that an image reference, if set, is valid and has a tag.
+// It may also be pinned to a digest.
+func taggedImage(imageRef *string) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if *imageRef == "" {
+			return nil
+		}
+		ref, err := image.ParseDockerImageRef(*imageRef)
+		if err != nil {
+			return err
+		}
+		if ref.Tag == "" {
+			return fmt.Errorf(`image %q has no tag`, *imageRef)
+		}
+		return nil
+	}
+}
@@ -17,3 +17,106 @@
  */
 
 package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/updates"
+)
+
+func TestParseChartVersion(t *testing.T) {
+	ref, version, err := parseChartVersion("stable/nginx-ingress:1.2.3")
+	assert.NoError(t, err)
+	assert.Equal(t, "stable/nginx-ingress", ref)
+	assert.Equal(t, "1.2.3", version)
+	for _, bad := range []string{"stable/nginx-ingress", "stable/nginx-ingress:", ":1.2.3"} {
+		_, _, err = parseChartVersion(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPatchImageUpdatesYamlNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-observe")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	env := &model.Environment{Name: "test"}
+	release := &model.Release{Name: "app", Environment: env, FromFile: path.Join(dir, "releases.yaml")}
+	patch := func(file, input string, source helm.ValueSource) string {
+		require.NoError(t, ioutil.WriteFile(file, []byte(input), 0644))
+		source.File = file
+		update := updates.ImageUpdate{Release: release, TagValue: "image.tag", OldTag: "1.0", NewTag: "1.1", TagSource: source}
+		require.NoError(t, patchImageUpdatesYamlNode(file, []updates.ImageUpdate{update}))
+		output, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		return string(output)
+	}
+
+	t.Run("inline values", func(t *testing.T) {
+		output := patch(release.FromFile, "releases:\n\n    - name: app # comment\n      values:\n          - key: image.tag\n            value: '1.0'\n",
+			helm.ValueSource{Kind: helm.ValueSourceValues})
+		assert.Equal(t, "releases:\n\n    - name: app # comment\n      values:\n          - key: image.tag\n            value: '1.1'\n", output)
+	})
+	t.Run("inline values are added", func(t *testing.T) {
+		output := patch(release.FromFile, "releases:\n- name: app\n",
+			helm.ValueSource{Kind: helm.ValueSourceValues})
+		assert.Equal(t, "releases:\n- name: app\n  values:\n  - key: image.tag\n    value: \"1.1\"\n", output)
+	})
+	t.Run("values file", func(t *testing.T) {
+		output := patch(path.Join(dir, "values.yaml"), "# comment\nimage:\n  repository: app\n  tag: \"1.0\"\n",
+			helm.ValueSource{Kind: helm.ValueSourceValuesFile})
+		assert.Equal(t, "# comment\nimage:\n  repository: app\n  tag: \"1.1\"\n", output)
+	})
+	t.Run("values file key is added", func(t *testing.T) {
+		output := patch(path.Join(dir, "values.yaml"), "replicas: 1\n",
+			helm.ValueSource{Kind: helm.ValueSourceValuesFile})
+		assert.Equal(t, "replicas: 1\nimage:\n  tag: \"1.1\"\n", output)
+	})
+	t.Run("unquoted versions stay strings", func(t *testing.T) {
+		update := updates.ImageUpdate{Release: release, TagValue: "image.tag", OldTag: "1.9", NewTag: "1.10"}
+		valuesFile := path.Join(dir, "values.yaml")
+		require.NoError(t, ioutil.WriteFile(valuesFile, []byte("image:\n  tag: 1.9\n"), 0644))
+		update.TagSource = helm.ValueSource{Kind: helm.ValueSourceValuesFile, File: valuesFile}
+		require.NoError(t, patchImageUpdatesYamlNode(valuesFile, []updates.ImageUpdate{update}))
+		output, err := ioutil.ReadFile(valuesFile)
+		require.NoError(t, err)
+		assert.Equal(t, "image:\n  tag: \"1.10\"\n", string(output))
+
+		require.NoError(t, ioutil.WriteFile(release.FromFile, []byte("releases:\n- name: app\n  values:\n  - key: image.tag\n    value: 1.9\n"), 0644))
+		update.TagSource = helm.ValueSource{Kind: helm.ValueSourceValues, File: release.FromFile}
+		require.NoError(t, patchImageUpdatesYamlNode(release.FromFile, []updates.ImageUpdate{update}))
+		output, err = ioutil.ReadFile(release.FromFile)
+		require.NoError(t, err)
+		assert.Equal(t, "releases:\n- name: app\n  values:\n  - key: image.tag\n    value: \"1.10\"\n", string(output))
+	})
+	t.Run("environment default values", func(t *testing.T) {
+		output := patch(path.Join(dir, "environments.yaml"), "environments:\n- name: other\n- name: test\n  defaultValues:\n  - key: image.tag\n    value: \"1.0\"\n",
+			helm.ValueSource{Kind: helm.ValueSourceDefaultValues})
+		assert.Equal(t, "environments:\n- name: other\n- name: test\n  defaultValues:\n  - key: image.tag\n    value: \"1.1\"\n", output)
+	})
+	t.Run("tag and digest in different files", func(t *testing.T) {
+		valuesFile := path.Join(dir, "values.yaml")
+		require.NoError(t, ioutil.WriteFile(release.FromFile, []byte("releases:\n- name: app\n  values:\n  - key: image.tag\n    value: \"1.0\"\n"), 0644))
+		require.NoError(t, ioutil.WriteFile(valuesFile, []byte("image:\n  digest: sha256:old\n"), 0644))
+		update := updates.ImageUpdate{
+			Release: release, TagValue: "image.tag", OldTag: "1.0", NewTag: "1.1",
+			TagSource: helm.ValueSource{Kind: helm.ValueSourceValues, File: release.FromFile},
+			PinDigest: true, OldDigest: "sha256:old", NewDigest: "sha256:new", DigestValue: "image.digest",
+			DigestSource: helm.ValueSource{Kind: helm.ValueSourceValuesFile, File: valuesFile},
+		}
+		require.NoError(t, patchReleasesFilesMaybe(ioutil.Discard, []updates.ImageUpdate{update}, true))
+		output, err := ioutil.ReadFile(release.FromFile)
+		require.NoError(t, err)
+		assert.Equal(t, "releases:\n- name: app\n  values:\n  - key: image.tag\n    value: \"1.1\"\n", string(output))
+		output, err = ioutil.ReadFile(valuesFile)
+		require.NoError(t, err)
+		assert.Equal(t, "image:\n  digest: sha256:new\n", string(output))
+	})
+}
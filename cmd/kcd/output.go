@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kubecd/kubecd/pkg/output"
+	"github.com/kubecd/kubecd/pkg/updates"
+)
+
+func addOutputFlag(cmd *cobra.Command, format *string) {
+	cmd.Flags().StringVarP(format, "output", "o", output.FormatText, "output format: table, json or yaml (default plain text)")
+}
+
+func outputFormat(format *string) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		return output.CheckFormat(*format)
+	}
+}
+
+// infoWriter is where commands print progress messages, which is stderr when
+// stdout is used for formatted output.
+func infoWriter(format string) io.Writer {
+	if format != output.FormatText {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func imageUpdateRecords(imageUpdates []updates.ImageUpdate, patched bool) []output.Update {
+	records := make([]output.Update, 0, len(imageUpdates))
+	for _, update := range imageUpdates {
+		file := update.TagSource.File
+		if file == "" {
+			file = update.Release.FromFile
+		}
+		records = append(records, output.Update{
+			Kind:        output.UpdateKindImage,
+			Environment: update.Release.Environment.Name,
+			Release:     update.Release.Name,
+			File:        file,
+			Image:       update.ImageRepo,
+			OldTag:      update.OldTag,
+			NewTag:      update.NewTag,
+			OldDigest:   update.OldDigest,
+			NewDigest:   update.NewDigest,
+			Reason:      update.Reason,
+			Patched:     patched,
+		})
+	}
+	return records
+}
+
+func chartUpdateRecords(chartUpdates []updates.ChartUpdate, patched bool) []output.Update {
+	records := make([]output.Update, 0, len(chartUpdates))
+	for _, update := range chartUpdates {
+		records = append(records, output.Update{
+			Kind:        output.UpdateKindChart,
+			Environment: update.Release.Environment.Name,
+			Release:     update.Release.Name,
+			File:        update.Release.FromFile,
+			Chart:       update.Chart,
+			OldVersion:  update.OldVersion,
+			NewVersion:  update.NewVersion,
+			Reason:      update.Reason,
+			Patched:     patched,
+		})
+	}
+	return records
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommandRecord(t *testing.T) {
+	var stdout bytes.Buffer
+	record, err := runCommandRecord(false, true, []string{"sh", "-c", "echo hello"}, &stdout)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, record.ExitStatus)
+	assert.Equal(t, "hello\n", stdout.String())
+
+	record, err = runCommandRecord(false, true, []string{"sh", "-c", "exit 3"}, &stdout)
+	assert.Error(t, err)
+	assert.Equal(t, 3, record.ExitStatus)
+
+	record, err = runCommandRecord(true, true, []string{"sh", "-c", "exit 3"}, &stdout)
+	assert.NoError(t, err)
+	assert.True(t, record.DryRun)
+	assert.Equal(t, -1, record.ExitStatus)
+}
@@ -19,7 +19,14 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/kubecd/kubecd/pkg/image"
 	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/output"
 	"github.com/kubecd/kubecd/pkg/updates"
 
 	"github.com/spf13/cobra"
@@ -30,19 +37,39 @@ var (
 	pollReleases []string
 	pollImage    string
 	pollCluster  string
+	pollExplain  bool
+	pollOutput   string
+	pollPlatform string
+	pollNoCache  bool
+	pollOffline  bool
+	pollCacheTTL time.Duration
 )
 
 // pollCmd represents the poll command
 var pollCmd = &cobra.Command{
 	Use:   "poll",
-	Short: "poll for new images in registries",
+	Short: "poll for new images in registries and new charts in helm repos",
 	Long:  ``,
-	Args:  matchAll(cobra.RangeArgs(0, 1), clusterFlagOrEnvArg(&pollCluster)),
+	Args:  matchAll(cobra.RangeArgs(0, 1), clusterFlagOrEnvArg(&pollCluster), outputFormat(&pollOutput)),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		kcdConfig, err := model.NewConfigFromFile(environmentsFile)
 		if err != nil {
 			return err
 		}
+		if err = image.UseRegistries(kcdConfig.Registries); err != nil {
+			return err
+		}
+		platform := kcdConfig.ImagePlatform
+		if pollPlatform != "" {
+			platform = pollPlatform
+		}
+		if err = image.UsePlatform(platform); err != nil {
+			return err
+		}
+		if err = usePollTagCache(); err != nil {
+			return err
+		}
+		out := infoWriter(pollOutput)
 		releaseFilters := makePollReleaseFilters(cmd, args)
 		imageIndex, err := updates.ImageReleaseIndex(kcdConfig, releaseFilters...)
 		if err != nil {
@@ -53,24 +80,124 @@ var pollCmd = &cobra.Command{
 			return err
 		}
 		allUpdates := make([]updates.ImageUpdate, 0)
-		for repo, releases := range imageIndex {
-			fmt.Printf("image: %s\n", repo)
-			for _, release := range releases {
-				imageUpdates, err := updates.FindImageUpdatesForRelease(release, imageTags)
+		allDecisions := make([]updates.Decision, 0)
+		seenReleases := make(map[*model.Release]bool)
+		for _, repo := range sortedKeys(imageIndex) {
+			_, _ = fmt.Fprintf(out, "image: %s\n", repo)
+			for _, release := range imageIndex[repo] {
+				// releases with several image triggers are listed for each image
+				if seenReleases[release] {
+					continue
+				}
+				seenReleases[release] = true
+				imageUpdates, decisions, err := updates.ExplainImageUpdatesForRelease(release, imageTags)
 				if err != nil {
 					return err
 				}
 				allUpdates = append(allUpdates, imageUpdates...)
+				allDecisions = append(allDecisions, decisions...)
+			}
+		}
+		if pollExplain && !output.IsStructured(pollOutput) {
+			writeDecisions(os.Stdout, allDecisions)
+		}
+		chartUpdates := make([]updates.ChartUpdate, 0)
+		if pollImage == "" {
+			if chartUpdates, err = pollChartUpdates(out, kcdConfig, releaseFilters); err != nil {
+				return err
 			}
 		}
-		if len(allUpdates) == 0 {
-			fmt.Println("No updates found.")
+		if allUpdates, chartUpdates, err = skipUpdatesInCooldown(out, allUpdates, chartUpdates); err != nil {
+			return err
+		}
+		if len(allUpdates) == 0 && len(chartUpdates) == 0 {
+			_, _ = fmt.Fprintln(out, "No updates found.")
+		}
+		if err = resolvePollDigests(allUpdates); err != nil {
+			return err
+		}
+		if err = patchReleasesFilesMaybe(out, allUpdates, pollPatch); err != nil {
+			return err
+		}
+		if err = patchChartReleasesFilesMaybe(out, chartUpdates, pollPatch); err != nil {
+			return err
+		}
+		if pollOutput == output.FormatText {
 			return nil
 		}
-		return patchReleasesFilesMaybe(allUpdates, pollPatch)
+		result := pollResult{Updates: append(imageUpdateRecords(allUpdates, pollPatch), chartUpdateRecords(chartUpdates, pollPatch)...)}
+		if pollExplain {
+			result.Decisions = allDecisions
+		}
+		return output.Write(os.Stdout, pollOutput, result)
 	},
 }
 
+// pollResult is the machine-readable output of poll. Decisions are included with --explain.
+type pollResult struct {
+	Updates   []output.Update    `json:"updates"`
+	Decisions []updates.Decision `json:"decisions,omitempty"`
+}
+
+func (r pollResult) Table() output.Table {
+	return output.Updates{Updates: r.Updates}.Table()
+}
+
+func sortedKeys(index map[string][]*model.Release) []string {
+	keys := make([]string, 0, len(index))
+	for key := range index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeDecisions prints how new image tags were chosen, or why they were not.
+func writeDecisions(w io.Writer, decisions []updates.Decision) {
+	for _, d := range decisions {
+		_, _ = fmt.Fprintf(w, "env %q release %q value %q", d.Environment, d.Release, d.TagValue)
+		if d.Image != "" {
+			_, _ = fmt.Fprintf(w, " image %s:%s", d.Image, d.CurrentTag)
+		}
+		if d.Track != "" {
+			_, _ = fmt.Fprintf(w, " track %s", d.Track)
+		}
+		_, _ = fmt.Fprintf(w, "\n  %s\n", d.Reason)
+		for _, c := range d.Candidates {
+			verdict := "chosen"
+			if c.Rejected != "" {
+				verdict = "rejected: " + c.Rejected
+			}
+			created := "-"
+			if c.Timestamp > 0 {
+				created = time.Unix(c.Timestamp, 0).UTC().Format(time.RFC3339)
+			}
+			_, _ = fmt.Fprintf(w, "    %-20s %-20s %s\n", c.Tag, created, verdict)
+		}
+	}
+}
+
+func pollChartUpdates(out io.Writer, kcdConfig *model.KubeCDConfig, releaseFilters []updates.ReleaseFilterFunc) ([]updates.ChartUpdate, error) {
+	chartIndex := updates.ChartReleaseIndex(kcdConfig, releaseFilters...)
+	chartVersions, chartCreated, err := updates.BuildChartVersionIndexFromHelmRepos(kcdConfig, chartIndex)
+	if err != nil {
+		return nil, err
+	}
+	allUpdates := make([]updates.ChartUpdate, 0)
+	for _, chartRef := range sortedKeys(chartIndex) {
+		releases := chartIndex[chartRef]
+		_, _ = fmt.Fprintf(out, "chart: %s\n", chartRef)
+		for _, release := range releases {
+			chartUpdates, err := updates.FindChartUpdatesForRelease(release, chartVersions[chartRef], chartCreated[chartRef])
+			if err != nil {
+				return nil, err
+			}
+			allUpdates = append(allUpdates, chartUpdates...)
+		}
+	}
+	return allUpdates, nil
+}
+
 func makePollReleaseFilters(cmd *cobra.Command, args []string) []updates.ReleaseFilterFunc {
 	filters := make([]updates.ReleaseFilterFunc, 0)
 	if pollCluster != "" {
@@ -89,8 +216,77 @@ func makePollReleaseFilters(cmd *cobra.Command, args []string) []updates.Release
 
 func init() {
 	rootCmd.AddCommand(pollCmd)
-	pollCmd.Flags().BoolVarP(&pollPatch, "patch", "p", false, "patch releases.yaml files with updated version")
+	pollCmd.Flags().BoolVarP(&pollPatch, "patch", "p", false, "patch releases.yaml files with updated image tags and chart versions")
 	pollCmd.Flags().StringSliceVarP(&pollReleases, "releases", "r", []string{}, "poll one or more specific releases")
 	pollCmd.Flags().StringVarP(&pollImage, "image", "i", "", "poll releases using this image")
 	pollCmd.Flags().StringVarP(&pollCluster, "cluster", "c", "", "poll all releases in this cluster")
+	pollCmd.Flags().BoolVar(&pollExplain, "explain", false, "explain how new image tags were chosen, or why not")
+	pollCmd.Flags().BoolVar(&pollNoCache, "no-cache", false, "do not use or update the tag cache")
+	pollCmd.Flags().BoolVar(&pollOffline, "offline", false, "only use tags from the tag cache, without contacting registries")
+	pollCmd.Flags().DurationVar(&pollCacheTTL, "cache-ttl", image.DefaultTagCacheTTL, "how long cached tag lists are used before listing tags again")
+	pollCmd.Flags().StringVar(&pollPlatform, "platform", "", "platform of multi-arch images to get tag timestamps for (default from imagePlatform, or "+image.DefaultPlatform+")")
+	addOutputFlag(pollCmd, &pollOutput)
+}
+
+func usePollTagCache() error {
+	if pollNoCache {
+		if pollOffline {
+			return fmt.Errorf(`--offline needs the tag cache, and can not be used with --no-cache`)
+		}
+		image.UseTagCache(nil)
+		return nil
+	}
+	dir, err := image.DefaultTagCacheDir()
+	if err != nil {
+		return fmt.Errorf(`could not find tag cache directory: %v`, err)
+	}
+	image.UseTagCache(&image.TagCache{Dir: dir, TTL: pollCacheTTL, Offline: pollOffline})
+	return nil
+}
+
+// skipUpdatesInCooldown leaves out updates of values that were changed less
+// than their trigger's cooldown ago.
+func skipUpdatesInCooldown(out io.Writer, imageUpdates []updates.ImageUpdate, chartUpdates []updates.ChartUpdate) ([]updates.ImageUpdate, []updates.ChartUpdate, error) {
+	now := time.Now()
+	keptImageUpdates := make([]updates.ImageUpdate, 0, len(imageUpdates))
+	for _, update := range imageUpdates {
+		remaining, err := update.CooldownRemaining(now)
+		if err != nil {
+			return nil, nil, err
+		}
+		if remaining > 0 {
+			_, _ = fmt.Fprintf(out, "env %q release %q: skipping %s:%s, %s is in cooldown for %s\n",
+				update.Release.Environment.Name, update.Release.Name, update.ImageRepo, update.NewTag, update.OldTag, remaining.Round(time.Minute))
+			continue
+		}
+		keptImageUpdates = append(keptImageUpdates, update)
+	}
+	keptChartUpdates := make([]updates.ChartUpdate, 0, len(chartUpdates))
+	for _, update := range chartUpdates {
+		remaining, err := update.CooldownRemaining(now)
+		if err != nil {
+			return nil, nil, err
+		}
+		if remaining > 0 {
+			_, _ = fmt.Fprintf(out, "env %q release %q: skipping %s %s, %s is in cooldown for %s\n",
+				update.Release.Environment.Name, update.Release.Name, update.Chart, update.NewVersion, update.OldVersion, remaining.Round(time.Minute))
+			continue
+		}
+		keptChartUpdates = append(keptChartUpdates, update)
+	}
+	return keptImageUpdates, keptChartUpdates, nil
+}
+
+// resolvePollDigests gets digests for updates pinning them, which is not
+// possible offline, in which case pinned tags are not patched.
+func resolvePollDigests(imageUpdates []updates.ImageUpdate) error {
+	if !pollOffline {
+		return updates.ResolveDigests(imageUpdates)
+	}
+	for _, update := range imageUpdates {
+		if update.PinDigest && pollPatch {
+			return fmt.Errorf(`release %q pins image digests, which can not be resolved with --offline`, update.Release.Name)
+		}
+	}
+	return nil
 }
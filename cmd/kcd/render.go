@@ -24,11 +24,12 @@ import (
 )
 
 var (
-	renderDryRun   bool
-	renderReleases []string
-	renderCluster  string
-	renderInit     bool
-	renderGitlab   bool
+	renderDryRun        bool
+	renderReleases      []string
+	renderCluster       string
+	renderInit          bool
+	renderGitlab        bool
+	renderAffectedSince string
 )
 
 var renderCmd = &cobra.Command{
@@ -41,11 +42,15 @@ var renderCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		envsToApply, err := environmentsToApply(kcdConfig, args)
+		envsToApply, err := environmentsFromArgs(kcdConfig, renderCluster, args)
 		if err != nil {
 			return err
 		}
-		commandsToRun, err := commandsToRender(envsToApply)
+		envsToApply, releasesToRender, err := affectedReleases(kcdConfig, envsToApply, renderReleases, renderAffectedSince)
+		if err != nil {
+			return err
+		}
+		commandsToRun, err := commandsToRender(envsToApply, releasesToRender)
 		if err != nil {
 			return err
 		}
@@ -58,7 +63,7 @@ var renderCmd = &cobra.Command{
 	},
 }
 
-func commandsToRender(envsToApply []*model.Environment) ([][]string, error) {
+func commandsToRender(envsToApply []*model.Environment, releasesToRender map[string][]string) ([][]string, error) {
 	commandsToRun := make([][]string, 0)
 	for _, env := range envsToApply {
 		if renderInit {
@@ -68,7 +73,7 @@ func commandsToRender(envsToApply []*model.Environment) ([][]string, error) {
 			}
 			commandsToRun = append(commandsToRun, initCmds...)
 		}
-		deployCmds, err := helm.TemplateCommands(env, renderReleases)
+		deployCmds, err := helm.TemplateCommands(env, releasesToRender[env.Name])
 		if err != nil {
 			return nil, err
 		}
@@ -84,4 +89,5 @@ func init() {
 	renderCmd.Flags().StringVarP(&renderCluster, "cluster", "c", "", "template all environments in CLUSTER")
 	renderCmd.Flags().BoolVar(&renderInit, "init", false, "initialize credentials and contexts")
 	renderCmd.Flags().BoolVar(&renderGitlab, "gitlab", false, "initialize in gitlab mode")
+	addAffectedSinceFlag(renderCmd, &renderAffectedSince)
 }
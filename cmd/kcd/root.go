@@ -18,16 +18,22 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"github.com/mitchellh/colorstring"
-	"github.com/pkg/errors"
-	"github.com/spf13/cobra"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/mitchellh/colorstring"
 	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/kubecd/kubecd/pkg/output"
 )
 
 var cfgFile string
@@ -107,25 +113,85 @@ func initConfig() {
 }
 
 func runCommand(dryRun, disableColors bool, argv []string) error {
-	printCmd := strings.Join(argv, " ")
+	_, err := runCommandRecord(dryRun, disableColors, argv, os.Stdout)
+	return err
+}
+
+// runCommandRecord runs a command like runCommand, but sends its stdout to the
+// given writer and returns a record of how it went.
+func runCommandRecord(dryRun, disableColors bool, argv []string, stdout io.Writer) (output.Command, error) {
+	return runCommandOutput(dryRun, disableColors, argv, stdout, os.Stderr)
+}
 
+// runCommandOutput is like runCommandRecord, also sending the command and its
+// stderr to the given writer.
+func runCommandOutput(dryRun, disableColors bool, argv []string, stdout, stderr io.Writer) (output.Command, error) {
+	record := output.Command{Argv: argv, ExitStatus: -1, DryRun: dryRun}
+	printCmd := strings.Join(argv, " ")
 	if !disableColors {
-		_, _ = colorstring.Fprintf(os.Stderr, "[yellow]%s\n", printCmd)
+		_, _ = colorstring.Fprintf(stderr, "[yellow]%s\n", printCmd)
+	}
+	if dryRun || len(argv) == 0 {
+		return record, nil
 	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	start := time.Now()
+	err := cmd.Run()
+	record.Duration = time.Since(start).Seconds()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		record.ExitStatus = exitErr.ExitCode()
+	} else if err == nil {
+		record.ExitStatus = 0
+	}
+	if err != nil {
+		return record, errors.Wrapf(err, "command failed: %q", printCmd)
+	}
+	return record, nil
+}
+
+// prefixWriter writes lines to w, prefixed with a name, so that the output of
+// commands running concurrently can be told apart. Incomplete lines are kept
+// until they are completed or flushed.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, mu *sync.Mutex, name string) *prefixWriter {
+	return &prefixWriter{w: w, mu: mu, prefix: "[" + name + "] "}
+}
 
-	if !dryRun && len(argv) > 0 {
-		var cmd *exec.Cmd
-		if len(argv) == 1 {
-			cmd = exec.Command(argv[0])
-		} else {
-			cmd = exec.Command(argv[0], argv[1:]...)
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		newline := bytes.IndexByte(p.buf, '\n')
+		if newline < 0 {
+			return len(data), nil
 		}
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
-			return errors.Wrapf(err, "command failed: %q", printCmd)
+		if err := p.writeLine(p.buf[:newline+1]); err != nil {
+			return len(data), err
 		}
+		p.buf = p.buf[newline+1:]
+	}
+}
+
+// Flush writes an incomplete last line.
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
 	}
-	return nil
+	err := p.writeLine(append(p.buf, '\n'))
+	p.buf = nil
+	return err
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(append([]byte(p.prefix), line...))
+	return err
 }
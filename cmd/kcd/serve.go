@@ -0,0 +1,159 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kubecd/kubecd/pkg/image"
+	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/updates"
+	"github.com/kubecd/kubecd/pkg/webhook"
+)
+
+var (
+	serveListen    string
+	serveSecret    string
+	serveQueueSize int
+	serveDryRun    bool
+	serveReleases  []string
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve [ENV]",
+	Short: "receive registry webhooks and observe the pushed images",
+	Long: `Runs an HTTP server receiving push events from container registries, and
+updates the releases using the pushed images like "kcd observe --patch".
+
+Webhooks are received at /hooks/<source>, with source being one of:
+
+  dockerhub     Docker Hub, with the secret in the "token" query parameter
+  harbor        Harbor, with the secret in the Authorization header
+  gitlab        the GitLab container registry, with the secret in X-Gitlab-Token
+  github        GitHub Packages, signing payloads with the secret
+  distribution  the distribution registry notification envelope, with the
+                secret in the Authorization header
+
+Events are queued and handled one at a time. /healthz reports the server's health.
+`,
+	Args: cobra.RangeArgs(0, 1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if serveSecret == "" {
+			serveSecret = os.Getenv("KCD_WEBHOOK_SECRET")
+		}
+		if serveSecret == "" {
+			return fmt.Errorf(`a webhook secret is required, use --secret or $KCD_WEBHOOK_SECRET`)
+		}
+		if serveQueueSize < 1 {
+			return fmt.Errorf(`--queue-size must be at least 1`)
+		}
+		// fail early on broken configs, as they are loaded again for each event
+		if _, err := model.NewConfigFromFile(environmentsFile); err != nil {
+			return err
+		}
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+		queue := make(chan webhook.Event, serveQueueSize)
+		server := &http.Server{
+			Addr:    serveListen,
+			Handler: &webhook.Handler{Secret: serveSecret, Queue: queue, Logf: logger.Printf},
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for event := range queue {
+				serveHandleEvent(logger, event, args)
+			}
+		}()
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		serveErr := make(chan error, 1)
+		go func() {
+			logger.Printf("listening on %s", serveListen)
+			serveErr <- server.ListenAndServe()
+		}()
+		var err error
+		select {
+		case err = <-serveErr:
+		case sig := <-stop:
+			logger.Printf("received %s, shutting down", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = server.Shutdown(ctx)
+			cancel()
+		}
+		close(queue)
+		<-done
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	},
+}
+
+// serveHandleEvent handles an event, logging any error, and recovering from
+// panics so that a bad event does not stop the server.
+func serveHandleEvent(logger *log.Logger, event webhook.Event, args []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Printf("%s event for %s failed: %v", event.Source, event.Image, r)
+		}
+	}()
+	if err := serveObserveEvent(event, args); err != nil {
+		logger.Printf("%s event for %s failed: %v", event.Source, event.Image, err)
+	}
+}
+
+// serveObserveEvent updates the releases using the image of an event,
+// reloading the config to see the changes of previous events.
+func serveObserveEvent(event webhook.Event, args []string) error {
+	kcdConfig, err := model.NewConfigFromFile(environmentsFile)
+	if err != nil {
+		return err
+	}
+	if err = image.UseRegistries(kcdConfig.Registries); err != nil {
+		return err
+	}
+	filters := []updates.ReleaseFilterFunc{updates.ImageReleaseFilter(event.Image)}
+	if len(serveReleases) > 0 {
+		filters = append(filters, updates.ReleaseFilter(serveReleases))
+	}
+	if len(args) == 1 {
+		filters = append(filters, updates.EnvironmentReleaseFilter(args[0]))
+	}
+	_, err = observeNewImage(os.Stdout, kcdConfig, event.Image, filters, !serveDryRun)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveListen, "listen", ":8080", "address to listen on")
+	serveCmd.Flags().StringVar(&serveSecret, "secret", "", "shared secret for verifying webhooks (default $KCD_WEBHOOK_SECRET)")
+	serveCmd.Flags().IntVar(&serveQueueSize, "queue-size", 100, "how many events may wait to be handled")
+	serveCmd.Flags().BoolVar(&serveDryRun, "dry-run", false, "only print updates, without patching release files")
+	serveCmd.Flags().StringSliceVarP(&serveReleases, "releases", "r", []string{}, "limit updates to one or more specific releases")
+}
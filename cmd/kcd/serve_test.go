@@ -0,0 +1,78 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/webhook"
+)
+
+func TestServeObserveEventWithTwoTriggers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-serve")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	files := map[string]string{
+		"environments.yaml": `clusters:
+  - name: test
+    provider:
+      minikube: {}
+environments:
+  - name: test
+    clusterName: test
+    kubeNamespace: default
+    releasesFiles: [releases.yaml]
+`,
+		"releases.yaml": `releases:
+  - name: app
+    chart: {dir: chart}
+    values:
+      - {key: image.repository, value: registry.example.com/app}
+      - {key: image.tag, value: "1.0"}
+      - {key: sidecar.repository, value: registry.example.com/sidecar}
+      - {key: sidecar.tag, value: "2.0"}
+    triggers:
+      - image: {track: Newest}
+      - image: {track: Newest, repoValue: sidecar.repository, tagValue: sidecar.tag}
+`,
+		"chart/Chart.yaml": "name: app\n",
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "chart"), 0755))
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	oldEnvironmentsFile, oldDryRun := environmentsFile, serveDryRun
+	defer func() { environmentsFile, serveDryRun = oldEnvironmentsFile, oldDryRun }()
+	environmentsFile = filepath.Join(dir, "environments.yaml")
+	serveDryRun = false
+
+	for _, pushed := range []string{"registry.example.com/app:1.1", "registry.example.com/sidecar:2.1"} {
+		assert.NoError(t, serveObserveEvent(webhook.Event{Source: webhook.SourceDockerHub, Image: pushed}, nil), pushed)
+	}
+	releases, err := ioutil.ReadFile(filepath.Join(dir, "releases.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(releases), `{key: image.tag, value: "1.1"}`)
+	assert.Contains(t, string(releases), `{key: sidecar.tag, value: "2.1"}`)
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package affected finds the releases whose inputs differ between two
+// revisions of the environments and releases files.
+package affected
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubecd/kubecd/pkg/exec"
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// runner is replaced in tests
+var runner exec.Runner = exec.RealRunner{}
+
+// Kinds of Release changes.
+const (
+	Added   = "added"
+	Changed = "changed"
+	Removed = "removed"
+)
+
+// Release is a release that differs between two revisions.
+type Release struct {
+	Environment string
+	Name        string
+	// Change is one of "added", "changed" or "removed"
+	Change string
+}
+
+// Compare returns the releases whose fingerprints differ between the base
+// and head configs, in the order of head, followed by removed releases.
+// Releases that can not be fingerprinted are considered changed.
+func Compare(base, head *model.KubeCDConfig) []Release {
+	baseFingerprints := make(map[string]string)
+	for _, release := range base.AllReleases() {
+		fingerprint, err := helm.Fingerprint(release)
+		if err != nil {
+			fingerprint = ""
+		}
+		baseFingerprints[release.Environment.Name+"/"+release.Name] = fingerprint
+	}
+	var result []Release
+	seen := make(map[string]bool)
+	for _, release := range head.AllReleases() {
+		key := release.Environment.Name + "/" + release.Name
+		seen[key] = true
+		baseFingerprint, found := baseFingerprints[key]
+		if !found {
+			result = append(result, Release{Environment: release.Environment.Name, Name: release.Name, Change: Added})
+			continue
+		}
+		fingerprint, err := helm.Fingerprint(release)
+		if err != nil || baseFingerprint == "" || fingerprint != baseFingerprint {
+			result = append(result, Release{Environment: release.Environment.Name, Name: release.Name, Change: Changed})
+		}
+	}
+	for _, release := range base.AllReleases() {
+		if !seen[release.Environment.Name+"/"+release.Name] {
+			result = append(result, Release{Environment: release.Environment.Name, Name: release.Name, Change: Removed})
+		}
+	}
+	return result
+}
+
+// Since returns the releases that differ between a git revision and the
+// working tree, with head loaded from environmentsFile.
+func Since(environmentsFile string, head *model.KubeCDConfig, ref string) ([]Release, error) {
+	base, cleanup, err := ConfigAt(environmentsFile, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return Compare(base, head), nil
+}
+
+// ConfigAt loads the environments file, in the git repository of the working
+// tree, as it was at a revision. The files of the revision are extracted in
+// a temporary directory, which cleanup removes.
+func ConfigAt(environmentsFile, ref string) (config *model.KubeCDConfig, cleanup func(), err error) {
+	dir := filepath.Dir(environmentsFile)
+	prefix, err := git("-C", dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, nil, err
+	}
+	top, err := git("-C", dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, nil, err
+	}
+	archive, err := runner.Run("git", "-C", top, "archive", "--format=tar", ref)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`could not read git revision %q: %v`, ref, err)
+	}
+	tmpDir, err := ioutil.TempDir("", "kcd-affected")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() { _ = os.RemoveAll(tmpDir) }
+	if err = extractTar(archive, tmpDir); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf(`could not extract git revision %q: %v`, ref, err)
+	}
+	config, err = model.NewConfigFromFile(filepath.Join(tmpDir, prefix, filepath.Base(environmentsFile)))
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf(`git revision %q: %v`, ref, err)
+	}
+	return config, cleanup, nil
+}
+
+func git(args ...string) (string, error) {
+	output, err := runner.Run("git", args...)
+	if err != nil {
+		return "", fmt.Errorf(`git %s failed: %v`, strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func extractTar(archive []byte, dir string) error {
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf(`invalid path in archive: %q`, header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = writeFile(path, reader, os.FileMode(header.Mode))
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+				err = os.Symlink(header.Linkname, path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
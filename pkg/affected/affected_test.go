@@ -0,0 +1,154 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package affected
+
+import (
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+const testEnvironments = `
+clusters:
+  - name: test
+    provider:
+      minikube: {}
+environments:
+  - name: test
+    clusterName: test
+    kubeNamespace: default
+    releasesFiles: [releases.yaml]
+  - name: prod
+    clusterName: test
+    kubeNamespace: prod
+    releasesFiles: [releases.yaml]
+    defaultValues:
+      - {key: replicas, value: "3"}
+`
+
+const testReleases = `
+releases:
+  - name: app
+    chart: {dir: chart}
+    valuesFile: values-app.yaml
+    values:
+      - {key: image.tag, value: "1.0"}
+  - name: db
+    resourceFiles: [db.yaml]
+  - name: cache
+    resourceFiles: [cache.yaml]
+`
+
+func commitFiles(t *testing.T, dir string, files map[string]string) {
+	git := func(args ...string) {
+		args = append([]string{"-C", dir, "-c", "user.name=kcd", "-c", "user.email=kcd@example.com"}, args...)
+		output, err := osexec.Command("git", args...).CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		git("init", "--quiet")
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	git("add", "--all")
+	git("commit", "--quiet", "--message", "update")
+}
+
+func TestCompare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-affected")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	deploy := filepath.Join(dir, "deploy")
+	commitFiles(t, dir, map[string]string{
+		"deploy/environments.yaml":     testEnvironments,
+		"deploy/releases.yaml":         testReleases,
+		"deploy/values-app.yaml":       "replicas: 1",
+		"deploy/chart/Chart.yaml":      "name: app",
+		"deploy/chart/templates/a.yml": "kind: Deployment",
+		"deploy/db.yaml":               "kind: StatefulSet",
+		"deploy/cache.yaml":            "kind: Deployment",
+	})
+	environmentsFile := filepath.Join(deploy, "environments.yaml")
+
+	head, err := model.NewConfigFromFile(environmentsFile)
+	require.NoError(t, err)
+	releases, err := Since(environmentsFile, head, "HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, releases, "the working tree is unchanged")
+
+	commitFiles(t, dir, map[string]string{
+		"deploy/chart/templates/a.yml": "kind: StatefulSet",
+		"deploy/db.yaml":               "kind: Deployment",
+		"deploy/releases.yaml": `
+releases:
+  - name: app
+    chart: {dir: chart}
+    valuesFile: values-app.yaml
+    values:
+      - {key: image.tag, value: "1.0"}
+  - name: db
+    resourceFiles: [db.yaml]
+  - name: web
+    resourceFiles: [cache.yaml]
+`,
+	})
+	base, cleanup, err := ConfigAt(environmentsFile, "HEAD~1")
+	require.NoError(t, err)
+	defer cleanup()
+	head, cleanupHead, err := ConfigAt(environmentsFile, "HEAD")
+	require.NoError(t, err)
+	defer cleanupHead()
+	assert.Equal(t, []Release{
+		{Environment: "test", Name: "app", Change: Changed},
+		{Environment: "test", Name: "db", Change: Changed},
+		{Environment: "test", Name: "web", Change: Added},
+		{Environment: "prod", Name: "app", Change: Changed},
+		{Environment: "prod", Name: "db", Change: Changed},
+		{Environment: "prod", Name: "web", Change: Added},
+		{Environment: "test", Name: "cache", Change: Removed},
+		{Environment: "prod", Name: "cache", Change: Removed},
+	}, Compare(base, head))
+
+	commitFiles(t, dir, map[string]string{
+		"deploy/environments.yaml": testEnvironments + `      - {key: domain, value: example.com}`,
+	})
+	releases, err = Since(environmentsFile, mustLoad(t, environmentsFile), "HEAD~1")
+	require.NoError(t, err)
+	assert.Equal(t, []Release{{Environment: "prod", Name: "app", Change: Changed}}, releases,
+		"environment defaults only apply to charts")
+
+	_, _, err = ConfigAt(environmentsFile, "no-such-ref")
+	assert.Error(t, err)
+}
+
+func mustLoad(t *testing.T, environmentsFile string) *model.KubeCDConfig {
+	config, err := model.NewConfigFromFile(environmentsFile)
+	require.NoError(t, err)
+	return config
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package agent keeps the releases in a cluster applied as they are defined
+// in a git repository.
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/provider"
+	"github.com/kubecd/kubecd/pkg/state"
+)
+
+// Agent syncs a git repository and applies the releases of the environments
+// in its cluster whose inputs changed since they were last applied.
+type Agent struct {
+	Repo *Repo
+	// EnvironmentsFile is the path of the environments file in the repository
+	EnvironmentsFile string
+	// Cluster is the name of the cluster the agent runs in, which if empty is
+	// the cluster of the current kubectl context
+	Cluster string
+	// Init is called with the config and environments to apply before
+	// applying releases of a new commit
+	Init func(config *model.KubeCDConfig, envs []*model.Environment) error
+	// Apply applies a release
+	Apply func(release *model.Release) error
+	// Store keeps the fingerprints of applied releases across restarts. If
+	// nil, they are kept in memory, and all releases are applied again by the
+	// first sync after a restart.
+	Store state.Store
+	Logf  func(format string, args ...interface{})
+
+	mu      sync.Mutex
+	status  Status
+	applied memoryStore // fingerprints of applied releases, without a Store
+}
+
+// memoryStore keeps fingerprints in memory, by environment name
+type memoryStore map[string]state.Fingerprints
+
+func (m memoryStore) Load(env *model.Environment) (state.Fingerprints, error) {
+	fingerprints := state.Fingerprints{}
+	for release, fingerprint := range m[env.Name] {
+		fingerprints[release] = fingerprint
+	}
+	return fingerprints, nil
+}
+
+func (m memoryStore) Save(env *model.Environment, fingerprints state.Fingerprints) error {
+	m[env.Name] = fingerprints
+	return nil
+}
+
+// Status tells how the agent's syncs went, and is served as JSON.
+type Status struct {
+	Cluster      string   `json:"cluster,omitempty"`
+	Environments []string `json:"environments,omitempty"`
+	// Commit is the last commit fetched, and SyncedCommit the last commit
+	// with all releases applied
+	Commit       string    `json:"commit,omitempty"`
+	SyncedCommit string    `json:"syncedCommit,omitempty"`
+	LastSync     time.Time `json:"lastSync"`
+	LastSuccess  time.Time `json:"lastSuccess"`
+	// Applied lists the releases applied by the last sync, as "env/release"
+	Applied []string `json:"applied"`
+	Error   string   `json:"error,omitempty"`
+	Syncs   int      `json:"syncs"`
+}
+
+// Status returns the status of the last sync.
+func (a *Agent) Status() Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status
+}
+
+// Run syncs every interval until stop is closed.
+func (a *Agent) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Sync(); err != nil {
+			a.logf("sync failed: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync fetches the repository and applies the releases that changed. Releases
+// that fail to apply are tried again by the next sync.
+func (a *Agent) Sync() error {
+	status := a.Status()
+	status.LastSync = time.Now()
+	status.Syncs++
+	status.Applied = []string{}
+	commit, err := a.Repo.Sync()
+	if err == nil {
+		status.Commit = commit
+		if commit != status.SyncedCommit {
+			err = a.applyChanged(&status)
+		}
+	}
+	if err == nil {
+		status.SyncedCommit = commit
+		status.LastSuccess = status.LastSync
+		status.Error = ""
+	} else {
+		status.Error = err.Error()
+	}
+	a.mu.Lock()
+	a.status = status
+	a.mu.Unlock()
+	return err
+}
+
+func (a *Agent) applyChanged(status *Status) error {
+	config, err := model.NewConfigFromFile(filepath.Join(a.Repo.Dir, a.EnvironmentsFile))
+	if err != nil {
+		return err
+	}
+	cluster := a.Cluster
+	if cluster == "" {
+		if cluster, err = detectCluster(config); err != nil {
+			return err
+		}
+	} else if !config.HasCluster(cluster) {
+		return fmt.Errorf(`unknown cluster: %q`, cluster)
+	}
+	envs := config.GetEnvironmentsInCluster(cluster)
+	status.Cluster = cluster
+	status.Environments = make([]string, len(envs))
+	for i, env := range envs {
+		status.Environments[i] = env.Name
+	}
+	if a.Init != nil {
+		if err = a.Init(config, envs); err != nil {
+			return err
+		}
+	}
+	store := a.Store
+	if store == nil {
+		if a.applied == nil {
+			a.applied = make(memoryStore)
+		}
+		store = a.applied
+	}
+	count := 0
+	for _, env := range envs {
+		count += len(env.AllReleases())
+	}
+	result, err := state.ApplyChanged(store, config, envs, state.ApplyOptions{
+		Parallelism: 1,
+		Apply: func(release *model.Release) error {
+			a.logf("applying %s", release.ID())
+			return a.Apply(release)
+		},
+	})
+	if result == nil {
+		return err
+	}
+	var failed []string
+	for _, release := range result.Releases {
+		if releaseErr := result.Errors[release.ID()]; releaseErr != nil {
+			a.logf("applying %s failed: %v", release.ID(), releaseErr)
+			failed = append(failed, release.ID())
+			continue
+		}
+		status.Applied = append(status.Applied, release.ID())
+	}
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf(`%d of %d releases failed: %s`, len(failed), count, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// detectCluster finds the cluster of the current kubectl context.
+func detectCluster(config *model.KubeCDConfig) (string, error) {
+	output, err := runner.Run("kubectl", "config", "view", "--minify", "-o", "jsonpath={.contexts[0].context.cluster}")
+	if err != nil {
+		return "", fmt.Errorf(`could not find the cluster of the current kubectl context: %v`, err)
+	}
+	kubeCluster := strings.TrimSpace(string(output))
+	for _, cluster := range config.AllClusters() {
+		cp, err := provider.GetClusterProvider(cluster, false)
+		if err == nil && cp.GetClusterName() == kubeCluster {
+			return cluster.Name, nil
+		}
+	}
+	return "", fmt.Errorf(`no cluster matches the current kubectl context's cluster %q`, kubeCluster)
+}
+
+// ServeHTTP serves the status as JSON, with status 503 if the last sync failed.
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := a.Status()
+	code := http.StatusOK
+	if status.Error != "" || status.Syncs == 0 {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func (a *Agent) logf(format string, args ...interface{}) {
+	if a.Logf != nil {
+		a.Logf(format, args...)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package agent
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/state"
+)
+
+const testEnvironments = `
+clusters:
+  - name: here
+    provider:
+      minikube: {}
+  - name: elsewhere
+    provider:
+      dockerForDesktop: {}
+environments:
+  - name: test
+    clusterName: here
+    kubeNamespace: default
+    releasesFiles: [releases.yaml]
+  - name: other
+    clusterName: elsewhere
+    kubeNamespace: default
+    releasesFiles: [releases.yaml]
+`
+
+const testReleases = `
+releases:
+  - name: app
+    resourceFiles: [app.yaml]
+  - name: db
+    resourceFiles: [db.yaml]
+`
+
+func withTempDir(t *testing.T, f func(dir string)) {
+	dir, err := ioutil.TempDir("", "kcd-agent")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	f(dir)
+}
+
+// commitFiles writes files to a git repository in dir, creating it if needed,
+// and commits them.
+func commitFiles(t *testing.T, dir string, files map[string]string) {
+	git := func(args ...string) {
+		args = append([]string{"-C", dir, "-c", "user.name=kcd", "-c", "user.email=kcd@example.com"}, args...)
+		output, err := osexec.Command("git", args...).CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		git("init", "--quiet")
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	git("add", "--all")
+	git("commit", "--quiet", "--message", "update")
+}
+
+func TestRepoSync(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		remote := filepath.Join(dir, "remote")
+		require.NoError(t, os.Mkdir(remote, 0755))
+		commitFiles(t, remote, map[string]string{"file": "1"})
+		for _, url := range []string{remote, "file://" + remote} {
+			repo := &Repo{URL: url, Dir: filepath.Join(dir, "checkout-"+filepath.Base(url))}
+			first, err := repo.Sync()
+			require.NoError(t, err, url)
+			assert.Len(t, first, 40)
+			commitFiles(t, remote, map[string]string{"file": first})
+			second, err := repo.Sync()
+			require.NoError(t, err, url)
+			assert.NotEqual(t, first, second)
+			content, err := ioutil.ReadFile(filepath.Join(repo.Dir, "file"))
+			require.NoError(t, err)
+			assert.Equal(t, first, string(content))
+		}
+		_, err := (&Repo{URL: filepath.Join(dir, "nothing"), Dir: filepath.Join(dir, "nothing-checkout")}).Sync()
+		assert.Error(t, err)
+	})
+}
+
+func TestAgentSync(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		remote := filepath.Join(dir, "remote")
+		require.NoError(t, os.Mkdir(remote, 0755))
+		commitFiles(t, remote, map[string]string{
+			"environments.yaml": testEnvironments,
+			"releases.yaml":     testReleases,
+			"app.yaml":          "app: 1",
+			"db.yaml":           "db: 1",
+		})
+		var applied []string
+		failing := ""
+		a := &Agent{
+			Repo:             &Repo{URL: remote, Dir: filepath.Join(dir, "checkout")},
+			EnvironmentsFile: "environments.yaml",
+			Cluster:          "here",
+			Apply: func(release *model.Release) error {
+				if release.Name == failing {
+					return errors.New("failed")
+				}
+				applied = append(applied, release.Environment.Name+"/"+release.Name)
+				return nil
+			},
+		}
+		require.NoError(t, a.Sync())
+		assert.Equal(t, []string{"test/app", "test/db"}, applied)
+		status := a.Status()
+		assert.Equal(t, "here", status.Cluster)
+		assert.Equal(t, []string{"test"}, status.Environments)
+		assert.Equal(t, status.Commit, status.SyncedCommit)
+		assert.Equal(t, []string{"test/app", "test/db"}, status.Applied)
+
+		applied = nil
+		require.NoError(t, a.Sync())
+		assert.Empty(t, applied, "nothing changed")
+
+		commitFiles(t, remote, map[string]string{"app.yaml": "app: 2", "db.yaml": "db: 2"})
+		failing = "db"
+		assert.EqualError(t, a.Sync(), "1 of 2 releases failed: test/db")
+		assert.Equal(t, []string{"test/app"}, applied)
+		status = a.Status()
+		assert.NotEqual(t, status.Commit, status.SyncedCommit)
+		assert.Equal(t, "1 of 2 releases failed: test/db", status.Error)
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		applied = nil
+		failing = ""
+		require.NoError(t, a.Sync())
+		assert.Equal(t, []string{"test/db"}, applied, "failed releases are tried again")
+		status = a.Status()
+		assert.Equal(t, status.Commit, status.SyncedCommit)
+		assert.Empty(t, status.Error)
+		assert.Equal(t, 4, status.Syncs)
+		w = httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"applied":["test/db"]`)
+
+		a.Cluster = "nowhere"
+		commitFiles(t, remote, map[string]string{"app.yaml": "app: 3"})
+		assert.EqualError(t, a.Sync(), `unknown cluster: "nowhere"`)
+	})
+}
+
+func TestAgentSyncDependencies(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		remote := filepath.Join(dir, "remote")
+		require.NoError(t, os.Mkdir(remote, 0755))
+		commitFiles(t, remote, map[string]string{
+			"environments.yaml": testEnvironments,
+			"releases.yaml": `
+releases:
+  - name: web
+    dependsOn: [ingress]
+    resourceFiles: [app.yaml]
+  - name: ingress
+    dependsOn: [cert-manager]
+    resourceFiles: [app.yaml]
+  - name: db
+    resourceFiles: [db.yaml]
+  - name: cert-manager
+    resourceFiles: [app.yaml]
+`,
+			"app.yaml": "app: 1",
+			"db.yaml":  "db: 1",
+		})
+		var applied []string
+		failing := "cert-manager"
+		a := &Agent{
+			Repo:             &Repo{URL: remote, Dir: filepath.Join(dir, "checkout")},
+			EnvironmentsFile: "environments.yaml",
+			Cluster:          "here",
+			Apply: func(release *model.Release) error {
+				if release.Name == failing {
+					return errors.New("failed")
+				}
+				applied = append(applied, release.ID())
+				return nil
+			},
+		}
+		assert.EqualError(t, a.Sync(), "3 of 4 releases failed: test/web, test/ingress, test/cert-manager")
+		assert.Equal(t, []string{"test/db"}, applied, "dependents of a failed release are not applied")
+
+		applied = nil
+		failing = ""
+		require.NoError(t, a.Sync())
+		assert.Equal(t, []string{"test/cert-manager", "test/ingress", "test/web"}, applied)
+	})
+}
+
+func TestAgentSyncStore(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		remote := filepath.Join(dir, "remote")
+		require.NoError(t, os.Mkdir(remote, 0755))
+		commitFiles(t, remote, map[string]string{
+			"environments.yaml": testEnvironments,
+			"releases.yaml":     testReleases,
+			"app.yaml":          "app: 1",
+			"db.yaml":           "db: 1",
+		})
+		store := &state.FileStore{Path: filepath.Join(dir, "state.json")}
+		var applied []string
+		newAgent := func() *Agent {
+			return &Agent{
+				Repo:             &Repo{URL: remote, Dir: filepath.Join(dir, "checkout")},
+				EnvironmentsFile: "environments.yaml",
+				Cluster:          "here",
+				Store:            store,
+				Apply: func(release *model.Release) error {
+					applied = append(applied, release.ID())
+					return nil
+				},
+			}
+		}
+		require.NoError(t, newAgent().Sync())
+		assert.Equal(t, []string{"test/app", "test/db"}, applied)
+
+		applied = nil
+		require.NoError(t, newAgent().Sync())
+		assert.Empty(t, applied, "a restarted agent does not apply unchanged releases")
+
+		commitFiles(t, remote, map[string]string{"db.yaml": "db: 2"})
+		require.NoError(t, newAgent().Sync())
+		assert.Equal(t, []string{"test/db"}, applied)
+	})
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubecd/kubecd/pkg/exec"
+)
+
+// runner is replaced in tests
+var runner exec.Runner = exec.RealRunner{}
+
+// Repo is a git checkout in Dir, kept up to date with a branch of a remote
+// repository, which may be a URL, a file:// URL or a local path.
+type Repo struct {
+	URL string
+	// Branch defaults to the remote's HEAD
+	Branch string
+	Dir    string
+}
+
+// Sync clones the repository, or fetches it and resets the checkout to the
+// newest commit of the branch, returning the commit.
+func (r *Repo) Sync() (string, error) {
+	if _, err := os.Stat(filepath.Join(r.Dir, ".git")); os.IsNotExist(err) {
+		args := []string{"clone", "--quiet"}
+		if r.Branch != "" {
+			args = append(args, "--branch", r.Branch)
+		}
+		if _, err = r.git(append(args, "--", r.URL, r.Dir)...); err != nil {
+			return "", err
+		}
+	} else {
+		ref := r.Branch
+		if ref == "" {
+			ref = "HEAD"
+		}
+		if _, err = r.git("-C", r.Dir, "fetch", "--quiet", r.URL, ref); err != nil {
+			return "", err
+		}
+		if _, err = r.git("-C", r.Dir, "reset", "--quiet", "--hard", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+	}
+	commit, err := r.git("-C", r.Dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(commit), nil
+}
+
+func (r *Repo) git(args ...string) (string, error) {
+	output, err := runner.Run("git", args...)
+	if err != nil {
+		if exitErr, ok := err.(*osexec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf(`%v: %s`, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf(`git %s failed: %v`, strings.Join(args, " "), err)
+	}
+	return string(output), nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package dag runs tasks that depend on each other concurrently.
+package dag
+
+import (
+	"fmt"
+)
+
+// Node is a task that runs once the nodes it depends on succeeded.
+// Dependencies on IDs that are not among the nodes run are satisfied.
+type Node struct {
+	ID        string
+	DependsOn []string
+	Run       func() error
+}
+
+// DependencyFailedError is the error of nodes that did not run because a
+// node they depend on failed.
+type DependencyFailedError struct {
+	Dependency string
+}
+
+func (e DependencyFailedError) Error() string {
+	return fmt.Sprintf(`not run, as %q failed`, e.Dependency)
+}
+
+// Run runs the nodes, at most parallelism at a time, starting ready nodes in
+// the order given. When a node fails, the nodes depending on it are not run,
+// while unrelated nodes are. It returns the errors of the nodes that failed
+// or did not run, by ID.
+func Run(nodes []Node, parallelism int) map[string]error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	index := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		index[node.ID] = true
+	}
+	type result struct {
+		id  string
+		err error
+	}
+	errs := make(map[string]error)
+	done := make(map[string]bool)
+	started := make(map[string]bool)
+	finished := make(chan result)
+	running := 0
+	// start starts the nodes that are ready, and fails those whose
+	// dependencies failed, telling whether any node was started or failed
+	start := func() bool {
+		changed := false
+		for _, node := range nodes {
+			if started[node.ID] || running >= parallelism {
+				continue
+			}
+			ready := true
+			for _, dependency := range node.DependsOn {
+				if !index[dependency] || dependency == node.ID {
+					continue
+				}
+				if !done[dependency] {
+					ready = false
+				} else if errs[dependency] != nil {
+					errs[node.ID] = DependencyFailedError{Dependency: dependency}
+					break
+				}
+			}
+			if errs[node.ID] != nil {
+				started[node.ID], done[node.ID], changed = true, true, true
+				continue
+			}
+			if !ready {
+				continue
+			}
+			started[node.ID], changed = true, true
+			running++
+			go func(node Node) {
+				finished <- result{id: node.ID, err: node.Run()}
+			}(node)
+		}
+		return changed
+	}
+	for len(done) < len(nodes) {
+		for start() {
+		}
+		if running == 0 {
+			for _, node := range nodes {
+				if !done[node.ID] {
+					errs[node.ID] = fmt.Errorf(`not run, as it is part of a dependency cycle`)
+					done[node.ID] = true
+				}
+			}
+			break
+		}
+		r := <-finished
+		running--
+		done[r.id] = true
+		if r.err != nil {
+			errs[r.id] = r.err
+		}
+	}
+	return errs
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package dag
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+type recorder struct {
+	mu      sync.Mutex
+	order   []string
+	running int
+	maxRun  int
+}
+
+func (r *recorder) node(id string, err error, dependsOn ...string) Node {
+	return Node{ID: id, DependsOn: dependsOn, Run: func() error {
+		r.mu.Lock()
+		r.order = append(r.order, id)
+		r.running++
+		if r.running > r.maxRun {
+			r.maxRun = r.running
+		}
+		r.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		r.mu.Lock()
+		r.running--
+		r.mu.Unlock()
+		return err
+	}}
+}
+
+func TestRunInOrder(t *testing.T) {
+	r := &recorder{}
+	errs := Run([]Node{
+		r.node("app", nil, "operator"),
+		r.node("operator", nil, "crds", "elsewhere"),
+		r.node("crds", nil),
+		r.node("web", nil),
+	}, 1)
+	assert.Empty(t, errs)
+	assert.Equal(t, []string{"crds", "operator", "app", "web"}, r.order)
+	assert.Equal(t, 1, r.maxRun)
+}
+
+func TestRunParallel(t *testing.T) {
+	r := &recorder{}
+	failed := errors.New("failed")
+	errs := Run([]Node{
+		r.node("crds", nil),
+		r.node("operator", failed, "crds"),
+		r.node("app", nil, "operator"),
+		r.node("worker", nil, "app"),
+		r.node("web", nil),
+		r.node("db", nil),
+		r.node("cache", nil, "db"),
+	}, 2)
+	assert.Equal(t, map[string]error{
+		"operator": failed,
+		"app":      DependencyFailedError{Dependency: "operator"},
+		"worker":   DependencyFailedError{Dependency: "app"},
+	}, errs)
+	assert.ElementsMatch(t, []string{"crds", "operator", "web", "db", "cache"}, r.order)
+	assert.Equal(t, 2, r.maxRun)
+	assert.EqualError(t, errs["app"], `not run, as "operator" failed`)
+}
+
+func TestRunCycle(t *testing.T) {
+	r := &recorder{}
+	errs := Run([]Node{
+		r.node("a", nil, "b"),
+		r.node("b", nil, "a"),
+		r.node("c", nil, "c"),
+	}, 4)
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs, "a")
+	assert.Contains(t, errs, "b")
+	assert.Equal(t, []string{"c"}, r.order)
+}
+
+func TestReleaseNodes(t *testing.T) {
+	test, prod := &model.Environment{Name: "test"}, &model.Environment{Name: "prod"}
+	test.Releases = []*model.Release{
+		{Name: "app", DependsOn: []string{"db", "prod/crds"}, Environment: test},
+		{Name: "db", Environment: test},
+	}
+	prod.Releases = []*model.Release{{Name: "crds", Environment: prod}}
+	config := &model.KubeCDConfig{Environments: []*model.Environment{test, prod}}
+	var ran []string
+	nodes, err := ReleaseNodes(config, test.Releases, func(release *model.Release) error {
+		ran = append(ran, release.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "test/app", nodes[0].ID)
+	assert.Equal(t, []string{"test/db", "prod/crds"}, nodes[0].DependsOn)
+	assert.Empty(t, nodes[1].DependsOn)
+	assert.Empty(t, Run(nodes, 1))
+	assert.Equal(t, []string{"db", "app"}, ran)
+
+	test.Releases[1].DependsOn = []string{"cache"}
+	_, err = ReleaseNodes(config, test.Releases, func(*model.Release) error { return nil })
+	assert.Error(t, err)
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package dag
+
+import (
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// ReleaseNodes returns a node per release, identified by its ID and running
+// run, which depends on the releases the release depends on in the config.
+func ReleaseNodes(config *model.KubeCDConfig, releases []*model.Release, run func(release *model.Release) error) ([]Node, error) {
+	nodes := make([]Node, 0, len(releases))
+	for _, release := range releases {
+		release := release
+		dependencies, err := config.Dependencies(release)
+		if err != nil {
+			return nil, err
+		}
+		node := Node{ID: release.ID(), Run: func() error { return run(release) }}
+		for _, dependency := range dependencies {
+			node.DependsOn = append(node.DependsOn, dependency.ID())
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
@@ -19,6 +19,7 @@
 package exec
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	osexec "os/exec"
@@ -27,6 +28,8 @@ import (
 
 type Runner interface {
 	Run(string, ...string) ([]byte, error)
+	// RunWithInput is like Run, with input written to the command's stdin
+	RunWithInput([]byte, string, ...string) ([]byte, error)
 }
 
 type RealRunner struct{}
@@ -35,3 +38,10 @@ func (r RealRunner) Run(cmd string, args ...string) ([]byte, error) {
 	_, _ = fmt.Fprintf(os.Stderr, "%s %s\n", cmd, strings.Join(args, " "))
 	return osexec.Command(cmd, args...).Output()
 }
+
+func (r RealRunner) RunWithInput(input []byte, cmd string, args ...string) ([]byte, error) {
+	_, _ = fmt.Fprintf(os.Stderr, "%s %s\n", cmd, strings.Join(args, " "))
+	command := osexec.Command(cmd, args...)
+	command.Stdin = bytes.NewReader(input)
+	return command.Output()
+}
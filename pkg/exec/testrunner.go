@@ -19,8 +19,10 @@
 package exec
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	osexec "os/exec"
 	"reflect"
@@ -29,12 +31,18 @@ import (
 
 type TestRunner struct {
 	ExpectedCommand []string
-	Output          []byte
-	ExtraEnv        map[string]string
-	ExitCode        int
+	// ExpectedInput is compared to stdin when set
+	ExpectedInput []byte
+	Output        []byte
+	ExtraEnv      map[string]string
+	ExitCode      int
 }
 
 func (r TestRunner) Run(command string, args ...string) ([]byte, error) {
+	return r.RunWithInput(nil, command, args...)
+}
+
+func (r TestRunner) RunWithInput(input []byte, command string, args ...string) ([]byte, error) {
 	cs := []string{"-test.run=TestHelperProcess", "--", command}
 	cs = append(cs, args...)
 	cmd := osexec.Command(os.Args[0], cs...)
@@ -55,6 +63,10 @@ func (r TestRunner) Run(command string, args ...string) ([]byte, error) {
 		}
 		cmd.Env = append(cmd.Env, "GO_HELPER_EXPECTED_COMMAND_JSON="+string(jsonArr))
 	}
+	if r.ExpectedInput != nil {
+		cmd.Env = append(cmd.Env, "GO_HELPER_EXPECTED_INPUT="+string(r.ExpectedInput))
+	}
+	cmd.Stdin = bytes.NewReader(input)
 	out, err := cmd.CombinedOutput()
 	return out, err
 }
@@ -81,6 +93,16 @@ func InsideHelperProcess() {
 			}
 		}
 	}
+	if expectedInput, found := os.LookupEnv("GO_HELPER_EXPECTED_INPUT"); found {
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			panic(err)
+		}
+		if string(input) != expectedInput {
+			fmt.Printf("expected input %q, got %q", expectedInput, string(input))
+			os.Exit(127)
+		}
+	}
 	fmt.Print(os.Getenv("GO_HELPER_MOCK_STDOUT"))
 	os.Exit(exitCode)
 }
@@ -46,3 +46,12 @@ func TestTestRunner(t *testing.T) {
 func TestHelperProcess(t *testing.T) {
 	InsideHelperProcess()
 }
+
+func TestTestRunnerWithInput(t *testing.T) {
+	runner := TestRunner{ExpectedInput: []byte("input"), Output: []byte("output")}
+	output, err := runner.RunWithInput([]byte("input"), "command")
+	assert.NoError(t, err)
+	assert.Equal(t, "output", string(output))
+	_, err = runner.RunWithInput([]byte("other"), "command")
+	assert.Error(t, err)
+}
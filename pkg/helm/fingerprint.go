@@ -0,0 +1,161 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// Fingerprint returns a hash of everything that goes into applying a release:
+// the apply command with its chart and values, and the contents of the values
+// files, chart directory and resource files it uses. Releases with unchanged
+// fingerprints would be applied the same way again. Paths are hashed relative
+// to the environments file, so checkouts in different directories have the
+// same fingerprints, and values from valueFrom are hashed by their reference
+// without resolving them.
+func Fingerprint(rel *model.Release) (string, error) {
+	env := rel.Environment
+	var argv, paths, files []string
+	if rel.Chart != nil {
+		envCopy := *env
+		envCopy.DefaultValues = unresolvedValues(env.DefaultValues)
+		relCopy := *rel
+		relCopy.Values = unresolvedValues(rel.Values)
+		relCopy.Environment = &envCopy
+		rel, env = &relCopy, &envCopy
+		var err error
+		if argv, err = GenerateHelmApplyArgv(rel, env, NoDryRun, NoDebug); err != nil {
+			return "", err
+		}
+		if !rel.SkipDefaultValues && env.DefaultValuesFile != "" {
+			paths = append(paths, rel.AbsPath(env.DefaultValuesFile))
+		}
+		if rel.ValuesFile != nil {
+			paths = append(paths, rel.AbsPath(*rel.ValuesFile))
+		}
+		files = append(files, paths...)
+		if rel.Chart.Dir != nil {
+			chartDir := rel.AbsPath(*rel.Chart.Dir)
+			chartFiles, err := listFiles(chartDir)
+			if err != nil {
+				return "", err
+			}
+			paths = append(paths, chartDir)
+			files = append(files, chartFiles...)
+		}
+	} else if rel.ResourceFiles != nil {
+		for _, path := range rel.ResourceFiles {
+			paths = append(paths, model.ResolvePathFromFile(path, rel.FromFile))
+		}
+		files = paths
+		argv = KubectlApplyCommand(paths, NoDryRun, env.Name)
+	}
+	baseDir := ""
+	if env.FromFile() != "" {
+		baseDir = filepath.Dir(env.FromFile())
+	}
+	relativeArgv := make([]string, len(argv))
+	for i, arg := range argv {
+		relativeArgv[i] = arg
+		for _, path := range paths {
+			if arg == path {
+				relativeArgv[i] = relativePath(baseDir, arg)
+			}
+		}
+	}
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%q\n", relativeArgv)
+	for _, file := range files {
+		if err := hashFile(hash, file, relativePath(baseDir, file)); err != nil {
+			return "", fmt.Errorf(`release %q: %v`, rel.Name, err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// unresolvedValues replaces values from valueFrom with their reference.
+func unresolvedValues(values []model.ChartValue) []model.ChartValue {
+	if values == nil {
+		return nil
+	}
+	result := make([]model.ChartValue, len(values))
+	for i, value := range values {
+		result[i] = value
+		if value.ValueFrom != nil {
+			ref, _ := json.Marshal(value.ValueFrom)
+			result[i] = model.ChartValue{Key: value.Key, Value: "valueFrom:" + string(ref)}
+		}
+	}
+	return result
+}
+
+// relativePath returns path relative to baseDir if possible.
+func relativePath(baseDir, path string) string {
+	if baseDir == "" {
+		return path
+	}
+	absBase, err := filepath.Abs(baseDir)
+	if err != nil {
+		return path
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(absBase, absPath); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// listFiles returns all files below dir, sorted.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func hashFile(hash io.Writer, file, name string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	_, _ = fmt.Fprintf(hash, "%s\n", name)
+	_, err = io.Copy(hash, f)
+	return err
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+func TestFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-fingerprint")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	chartDir := "chart"
+	valuesFile := "values.yaml"
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, chartDir, "templates"), 0755))
+	writeFile := func(name, content string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	writeFile("chart/Chart.yaml", "name: app")
+	writeFile("chart/templates/app.yaml", "kind: Deployment")
+	writeFile(valuesFile, "replicas: 1")
+	env := &model.Environment{Name: "test", KubeNamespace: "default"}
+	release := &model.Release{
+		Name:        "app",
+		Chart:       &model.Chart{Dir: &chartDir},
+		ValuesFile:  &valuesFile,
+		Values:      []model.ChartValue{{Key: "image.tag", Value: "1.0"}},
+		FromFile:    filepath.Join(dir, "releases.yaml"),
+		Environment: env,
+	}
+	fingerprint := func() string {
+		result, err := Fingerprint(release)
+		require.NoError(t, err)
+		return result
+	}
+	first := fingerprint()
+	assert.Equal(t, first, fingerprint())
+
+	release.Values[0].Value = "1.1"
+	second := fingerprint()
+	assert.NotEqual(t, first, second, "changed value")
+	writeFile(valuesFile, "replicas: 2")
+	third := fingerprint()
+	assert.NotEqual(t, second, third, "changed values file")
+	writeFile("chart/templates/app.yaml", "kind: StatefulSet")
+	assert.NotEqual(t, third, fingerprint(), "changed chart")
+
+	release.Values = append(release.Values, model.ChartValue{
+		Key:       "ip",
+		ValueFrom: &model.ChartValueRef{GceResource: &model.GceValueRef{Address: &model.GceAddressValueRef{Name: "ingress"}}},
+	})
+	assert.NotEmpty(t, fingerprint(), "valueFrom is hashed without resolving it")
+
+	release.Chart = nil
+	release.ResourceFiles = []string{"missing.yaml"}
+	_, err = Fingerprint(release)
+	assert.Error(t, err)
+}
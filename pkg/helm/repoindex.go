@@ -0,0 +1,143 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package helm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// RepoIndex is the part of a Helm repository's index.yaml used by KubeCD.
+type RepoIndex struct {
+	Entries map[string][]RepoChartVersion `json:"entries"`
+}
+
+type RepoChartVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Created string `json:"created,omitempty"`
+}
+
+// Versions returns all versions of a chart found in the index.
+func (i *RepoIndex) Versions(chartName string) []string {
+	versions := make([]string, 0, len(i.Entries[chartName]))
+	for _, entry := range i.Entries[chartName] {
+		versions = append(versions, entry.Version)
+	}
+	return versions
+}
+
+// Created returns the creation time of each version of a chart found in the
+// index, for versions having one.
+func (i *RepoIndex) Created(chartName string) map[string]int64 {
+	created := make(map[string]int64)
+	for _, entry := range i.Entries[chartName] {
+		if timestamp, err := time.Parse(time.RFC3339Nano, entry.Created); err == nil {
+			created[entry.Version] = timestamp.Unix()
+		}
+	}
+	return created
+}
+
+// LoadRepoIndex reads index.yaml from a Helm repository. Besides http(s) URLs,
+// file:// URLs and plain directory paths are supported, with relative paths
+// being resolved from baseDir.
+func LoadRepoIndex(repo model.HelmRepo, baseDir string) (*RepoIndex, error) {
+	data, err := readRepoIndex(repo, baseDir)
+	if err != nil {
+		return nil, fmt.Errorf(`helm repo %q: %v`, repo.Name, err)
+	}
+	index := &RepoIndex{}
+	if err = yaml.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf(`helm repo %q: error while unmarshaling index.yaml: %v`, repo.Name, err)
+	}
+	return index, nil
+}
+
+func readRepoIndex(repo model.HelmRepo, baseDir string) ([]byte, error) {
+	repoURL, err := url.Parse(repo.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch repoURL.Scheme {
+	case "http", "https":
+		return fetchRepoIndex(repo)
+	case "file":
+		// file://repo parses "repo" as the host, so use everything after the scheme
+		repoPath := strings.TrimPrefix(repo.URL, "file://")
+		return ioutil.ReadFile(filepath.Join(model.ResolvePathFromDir(repoPath, baseDir), "index.yaml"))
+	case "":
+		return ioutil.ReadFile(filepath.Join(model.ResolvePathFromDir(repo.URL, baseDir), "index.yaml"))
+	}
+	return nil, fmt.Errorf(`unsupported URL scheme %q`, repoURL.Scheme)
+}
+
+func fetchRepoIndex(repo model.HelmRepo) ([]byte, error) {
+	client, err := repoHTTPClient(repo)
+	if err != nil {
+		return nil, err
+	}
+	indexURL := strings.TrimSuffix(repo.URL, "/") + "/index.yaml"
+	resp, err := client.Get(indexURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(`GET %s: %s`, indexURL, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func repoHTTPClient(repo model.HelmRepo) (*http.Client, error) {
+	caFile, certFile, keyFile := repo.GetCAFile(), repo.GetCertFile(), repo.GetKeyFile()
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return http.DefaultClient, nil
+	}
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		caData, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf(`no certificates found in %q`, caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
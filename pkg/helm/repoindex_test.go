@@ -0,0 +1,78 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package helm
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+const testRepoIndex = `apiVersion: v1
+entries:
+  nginx-ingress:
+  - name: nginx-ingress
+    version: 1.3.0
+    created: "2020-01-02T03:04:05Z"
+  - name: nginx-ingress
+    version: 1.2.0
+`
+
+func TestLoadRepoIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-repoindex")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	require.NoError(t, os.Mkdir(path.Join(dir, "repo"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "repo", "index.yaml"), []byte(testRepoIndex), 0644))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/charts/index.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(testRepoIndex))
+	}))
+	defer server.Close()
+
+	for name, url := range map[string]string{
+		"relative dir":  "repo",
+		"absolute dir":  path.Join(dir, "repo"),
+		"relative file": "file://repo",
+		"absolute file": "file://" + path.Join(dir, "repo"),
+		"http":          server.URL + "/charts/",
+	} {
+		t.Run(name, func(t *testing.T) {
+			index, err := LoadRepoIndex(model.HelmRepo{Name: "stable", URL: url}, dir)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"1.3.0", "1.2.0"}, index.Versions("nginx-ingress"))
+			assert.Empty(t, index.Versions("unknown"))
+		})
+	}
+
+	_, err = LoadRepoIndex(model.HelmRepo{Name: "stable", URL: server.URL + "/missing"}, dir)
+	assert.Error(t, err)
+}
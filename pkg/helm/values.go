@@ -116,6 +116,57 @@ func KubectlApplyCommand(resourceFiles []string, dryRun bool, envName string) []
 	return cmd
 }
 
+func KubectlDiffCommand(resourceFiles []string, envName string) []string {
+	cmd := []string{"kubectl", "--context", model.KubeContextName(envName), "diff"}
+	for _, file := range resourceFiles {
+		cmd = append(cmd, "-f", file)
+	}
+	return cmd
+}
+
+const (
+	// HelmDiffChangedExitCode is the exit status of "helm diff --detailed-exitcode" when there are changes
+	HelmDiffChangedExitCode = 2
+	// KubectlDiffChangedExitCode is the exit status of "kubectl diff" when there are changes
+	KubectlDiffChangedExitCode = 1
+)
+
+// DiffCommand is a command showing what would change in the cluster if a release was applied.
+type DiffCommand struct {
+	Release *model.Release
+	Argv    []string
+	// ChangedExitCode is the exit status of the command when there are changes
+	ChangedExitCode int
+}
+
+func DiffCommands(env *model.Environment, limitToReleases []string) ([]DiffCommand, error) {
+	var commands []DiffCommand
+	for _, releaseName := range limitToReleases {
+		if env.GetRelease(releaseName) == nil {
+			return nil, fmt.Errorf(`env %q: release not found: %q`, env.Name, releaseName)
+		}
+	}
+	for _, release := range env.AllReleases() {
+		if len(limitToReleases) == 0 || stringInSlice(release.Name, limitToReleases) {
+			if release.Chart != nil {
+				argv, err := GenerateHelmDiffArgv(release, env)
+				if err != nil {
+					return nil, err
+				}
+				commands = append(commands, DiffCommand{Release: release, Argv: argv, ChangedExitCode: HelmDiffChangedExitCode})
+			} else if release.ResourceFiles != nil {
+				absFiles := make([]string, len(release.ResourceFiles))
+				for i, path := range release.ResourceFiles {
+					absFiles[i] = release.AbsPath(path)
+				}
+				argv := KubectlDiffCommand(absFiles, env.Name)
+				commands = append(commands, DiffCommand{Release: release, Argv: argv, ChangedExitCode: KubectlDiffChangedExitCode})
+			}
+		}
+	}
+	return commands, nil
+}
+
 const (
 	DryRun   = true
 	NoDryRun = false
@@ -252,6 +303,7 @@ func GenerateHelmDiffArgv(rel *model.Release, env *model.Environment) ([]string,
 		return []string{}, err
 	}
 	argv = append(argv, chartArgs...)
+	argv = append(argv, "--namespace", env.KubeNamespace, "--allow-unreleased", "--detailed-exitcode")
 	valueArgs, err := GenerateHelmValuesArgv(rel, env)
 	if err != nil {
 		return []string{}, err
@@ -449,11 +501,31 @@ func LookupValueByPath(key []string, values map[string]interface{}) *string {
 }
 
 func KeyIsInValues(key string, values map[string]interface{}) bool {
-	return LookupValueByString(key, values) != nil
+	return LookupValueByPath(strings.Split(key, "."), values) != nil
 }
 
-func GetResolvedValues(release *model.Release) (map[string]interface{}, error) {
-	values := make(map[string]interface{})
+// Kinds of ValueSource, in increasing order of precedence.
+const (
+	ValueSourceChart         = "chart"
+	ValueSourceDefaultValues = "defaultValues"
+	ValueSourceValuesFile    = "valuesFile"
+	ValueSourceValues        = "values"
+)
+
+// ValueSource describes where a value used by a release was defined.
+type ValueSource struct {
+	Kind string `json:"kind"`
+	File string `json:"file,omitempty"`
+}
+
+type valuesLayer struct {
+	source ValueSource
+	values map[string]interface{}
+}
+
+// resolveValuesLayers returns every set of values that apply to a release, lowest precedence first.
+func resolveValuesLayers(release *model.Release) ([]valuesLayer, error) {
+	var layers []valuesLayer
 	forEnv := release.Environment
 	if release.Chart != nil && release.Chart.Dir != nil {
 		valuesFile := release.AbsPath(model.ResolvePathFromDir("values.yaml", *release.Chart.Dir))
@@ -462,7 +534,7 @@ func GetResolvedValues(release *model.Release) (map[string]interface{}, error) {
 			if err != nil {
 				return nil, fmt.Errorf(`failed to load values file %q for chart dir %q: %v`, valuesFile, *release.Chart.Dir, err)
 			}
-			values = MergeValues(chartValues, values)
+			layers = append(layers, valuesLayer{ValueSource{ValueSourceChart, valuesFile}, chartValues})
 		}
 	} else if release.Chart != nil && release.Chart.Reference != nil {
 		output, err := InspectChart(*release.Chart.Reference, *release.Chart.Version)
@@ -479,7 +551,7 @@ func GetResolvedValues(release *model.Release) (map[string]interface{}, error) {
 		if err != nil {
 			return nil, fmt.Errorf(`failed to resolve defaultValues for env %q and release %q: %v`, forEnv.Name, release.Name, err)
 		}
-		values = MergeValues(envDefaultValues, values)
+		layers = append(layers, valuesLayer{ValueSource{ValueSourceDefaultValues, forEnv.FromFile()}, envDefaultValues})
 	}
 	if release.ValuesFile != nil {
 		absPath := release.AbsPath(*release.ValuesFile)
@@ -487,18 +559,81 @@ func GetResolvedValues(release *model.Release) (map[string]interface{}, error) {
 		if err != nil {
 			return nil, fmt.Errorf(`failed to load release values file %q for release %q: %v`, absPath, release.Name, err)
 		}
-		values = MergeValues(releaseFileValues, values)
+		layers = append(layers, valuesLayer{ValueSource{ValueSourceValuesFile, absPath}, releaseFileValues})
 	}
 	if release.Values != nil {
 		releaseValues, err := ValuesListToMap(release.Values, forEnv)
 		if err != nil {
 			return nil, fmt.Errorf(`failed to resolve inline values for release %q: %v`, release.Name, err)
 		}
-		values = MergeValues(releaseValues, values)
+		layers = append(layers, valuesLayer{ValueSource{ValueSourceValues, release.FromFile}, releaseValues})
+	}
+	return layers, nil
+}
+
+func GetResolvedValues(release *model.Release) (map[string]interface{}, error) {
+	layers, err := resolveValuesLayers(release)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	for _, layer := range layers {
+		values = MergeValues(layer.values, values)
 	}
 	return values, nil
 }
 
+// GetResolvedValueSources returns the source of every value in
+// GetResolvedValues, keyed by the dotted path of the value.
+func GetResolvedValueSources(release *model.Release) (map[string]ValueSource, error) {
+	layers, err := resolveValuesLayers(release)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[string]ValueSource)
+	for _, layer := range layers {
+		for key, value := range FlattenValues(layer.values) {
+			_, isMap := value.(map[string]interface{})
+			// A scalar replaces any map defined below it, and vice versa (see MergeValues),
+			// while an empty map is merged into whatever is already there.
+			for oldKey := range sources {
+				if strings.HasPrefix(key, oldKey+".") || (!isMap && strings.HasPrefix(oldKey, key+".")) {
+					delete(sources, oldKey)
+				}
+			}
+			if isMap && hasKeyWithPrefix(sources, key+".") {
+				continue
+			}
+			sources[key] = layer.source
+		}
+	}
+	return sources, nil
+}
+
+func hasKeyWithPrefix(sources map[string]ValueSource, prefix string) bool {
+	for key := range sources {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// FlattenValues returns all non-map values, keyed by their dotted path.
+func FlattenValues(values map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, value := range values {
+		if nested, isMap := value.(map[string]interface{}); isMap && len(nested) > 0 {
+			for nestedKey, nestedValue := range FlattenValues(nested) {
+				result[key+"."+nestedKey] = nestedValue
+			}
+		} else {
+			result[key] = value
+		}
+	}
+	return result
+}
+
 func GetImageRefFromImageTrigger(trigger *model.ImageTrigger, values map[string]interface{}) *image.DockerImageRef {
 	repoValue := trigger.RepoValueString()
 	repo := LookupValueByString(repoValue, values).(*string)
@@ -509,11 +644,18 @@ func GetImageRefFromImageTrigger(trigger *model.ImageTrigger, values map[string]
 	if prefix != nil {
 		*repo = *prefix + *repo
 	}
+	imageRef := image.NewDockerImageRef(*repo)
+	// the tag value may be pinned to a digest, like "1.0@sha256:..."
 	tag := LookupValueByString(trigger.TagValueString(), values).(*string)
 	if tag != nil {
-		*repo = *repo + ":" + *tag
+		imageRef.Tag, imageRef.Digest = image.SplitTagDigest(*tag)
+	}
+	if trigger.DigestValue != "" {
+		if digest := LookupValueByString(trigger.DigestValue, values).(*string); digest != nil {
+			imageRef.Digest = *digest
+		}
 	}
-	return image.NewDockerImageRef(*repo)
+	return imageRef
 }
 
 func GetImageRefsFromRelease(release *model.Release, values map[string]interface{}) []*image.DockerImageRef {
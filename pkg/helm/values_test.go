@@ -25,6 +25,8 @@ import (
 	"github.com/kubecd/kubecd/pkg/model"
 	"github.com/kubecd/kubecd/pkg/semver"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
 	"os"
 	"path"
 	"testing"
@@ -179,8 +181,15 @@ func TestGetImageRepoFromImageTrigger(t *testing.T) {
 	valuesWithPrefix := map[string]interface{}{
 		"image": map[string]interface{}{"prefix": "example.io/", "repository": "test-image"},
 	}
-	assert.Equal(t, image.DefaultDockerRegistry+"/test-image", GetImageRefFromImageTrigger(trigger, valuesWithoutPrefix).WithoutTag())
+	assert.Equal(t, image.DefaultDockerRegistry+"/library/test-image", GetImageRefFromImageTrigger(trigger, valuesWithoutPrefix).WithoutTag())
 	assert.Equal(t, "example.io/test-image", GetImageRefFromImageTrigger(trigger, valuesWithPrefix).WithoutTag())
+
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	valuesWithPort := map[string]interface{}{
+		"image": map[string]interface{}{"repository": "localhost:5000/test-image", "tag": "1.0@" + digest},
+	}
+	imageRef := GetImageRefFromImageTrigger(trigger, valuesWithPort)
+	assert.Equal(t, image.DockerImageRef{Registry: "localhost", Port: "5000", Image: "test-image", Tag: "1.0", Digest: digest}, *imageRef)
 }
 
 func TestGenerateTemplateCommands(t *testing.T) {
@@ -217,3 +226,68 @@ func TestGenerateTemplateCommands(t *testing.T) {
 		assert.Equal(t, [][]string{{"mkdir", "-m", "700", "-p", tmpDir}, {"helm", "fetch", chartRef, "--version", chartVer, "--untar", "--untardir", tmpDir}, {"helm", "--kube-context", "env:" + envName, "template", tmpDir + "/" + releaseName, "-n", releaseName, "--namespace", envNamespace, "--values", expectedValuesFile}, {"rm", "-rf", tmpDir}}, cmds)
 	})
 }
+
+func TestGetResolvedValueSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-values")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	require.NoError(t, os.Mkdir(path.Join(dir, "chart"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "chart", "values.yaml"), []byte("image:\n  repository: chart-image\n  tag: latest\nreplicas: 1\nresources:\n  limits:\n    cpu: 1\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "values.yaml"), []byte("image:\n  tag: v1.0\nresources: {}\n"), 0644))
+	chartDir := "chart"
+	valuesFile := "values.yaml"
+	env := &model.Environment{
+		Name:          "test",
+		DefaultValues: []model.ChartValue{{Key: "image.repository", Value: "env-image"}},
+	}
+	release := &model.Release{
+		Name:        "test",
+		Chart:       &model.Chart{Dir: &chartDir},
+		ValuesFile:  &valuesFile,
+		Values:      []model.ChartValue{{Key: "replicas", Value: "3"}},
+		FromFile:    path.Join(dir, "releases.yaml"),
+		Environment: env,
+	}
+	sources, err := GetResolvedValueSources(release)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ValueSource{
+		"image.repository":     {Kind: ValueSourceDefaultValues},
+		"image.tag":            {Kind: ValueSourceValuesFile, File: path.Join(dir, "values.yaml")},
+		"replicas":             {Kind: ValueSourceValues, File: release.FromFile},
+		"resources.limits.cpu": {Kind: ValueSourceChart, File: path.Join(dir, "chart", "values.yaml")},
+	}, sources)
+	values, err := GetResolvedValues(release)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"image.repository":     "env-image",
+		"image.tag":            "v1.0",
+		"replicas":             "3",
+		"resources.limits.cpu": float64(1),
+	}, FlattenValues(values))
+}
+
+func TestDiffCommands(t *testing.T) {
+	chartDir := os.TempDir()
+	env := &model.Environment{Name: "test", KubeNamespace: "default"}
+	env.Releases = []*model.Release{
+		{Name: "chart", Chart: &model.Chart{Dir: &chartDir}, FromFile: "/tmp/releases.yaml", Environment: env},
+		{Name: "resources", ResourceFiles: []string{"resources.yaml"}, FromFile: "/tmp/releases.yaml", Environment: env},
+	}
+	cmds, err := DiffCommands(env, nil)
+	require.NoError(t, err)
+	require.Len(t, cmds, 2)
+	assert.Equal(t, []string{
+		"helm", "--kube-context", "env:test", "diff", "upgrade", "chart", chartDir,
+		"--namespace", "default", "--allow-unreleased", "--detailed-exitcode"}, cmds[0].Argv)
+	assert.Equal(t, HelmDiffChangedExitCode, cmds[0].ChangedExitCode)
+	assert.Equal(t, []string{"kubectl", "--context", "env:test", "diff", "-f", "/tmp/resources.yaml"}, cmds[1].Argv)
+	assert.Equal(t, KubectlDiffChangedExitCode, cmds[1].ChangedExitCode)
+
+	cmds, err = DiffCommands(env, []string{"resources"})
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+	assert.Equal(t, "resources", cmds[0].Release.Name)
+
+	_, err = DiffCommands(env, []string{"unknown"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterRegistryClient("acr", newACRClient, "*.azurecr.io")
+}
+
+// newACRClient lists tags in Azure Container Registry with
+// "az acr repository show-tags". The registry name is taken from hosts like
+// "myregistry.azurecr.io".
+func newACRClient(host string) (RegistryClient, error) {
+	name := strings.SplitN(host, ".", 2)[0]
+	return newListingClient(func(repo *DockerImageRef) ([]TimestampedTag, error) {
+		return getTagsForACRImage(repo, name)
+	}), nil
+}
+
+func getTagsForACRImage(repo *DockerImageRef, registryName string) ([]TimestampedTag, error) {
+	output, err := runner.Run("az", "acr", "repository", "show-tags",
+		"--name", registryName, "--repository", repo.Image, "--detail", "--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf(`failed listing tags for ACR image %q: %v`, repo.WithoutTag(), err)
+	}
+	var acrTags []struct {
+		Name        string `json:"name"`
+		CreatedTime string `json:"createdTime"`
+		Digest      string `json:"digest"`
+	}
+	if err = json.Unmarshal(output, &acrTags); err != nil {
+		return nil, fmt.Errorf(`failed decoding output when getting tags for ACR image %q: %v`, repo.WithoutTag(), err)
+	}
+	tags := make([]TimestampedTag, 0, len(acrTags))
+	for _, acrTag := range acrTags {
+		timestamp, err := ParseDockerTimestamp(acrTag.CreatedTime)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid createdTime for %s:%s: %v`, repo.WithoutTag(), acrTag.Name, err)
+		}
+		tags = append(tags, TimestampedTag{Tag: acrTag.Name, Timestamp: timestamp, Digest: acrTag.Digest})
+	}
+	return tags, nil
+}
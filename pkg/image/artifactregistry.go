@@ -0,0 +1,97 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ArtifactRegistryPattern matches Google Artifact Registry hosts, like "europe-docker.pkg.dev".
+const ArtifactRegistryPattern = "*-docker.pkg.dev"
+
+func init() {
+	RegisterRegistryClient("artifactregistry", newArtifactRegistryClient, ArtifactRegistryPattern)
+}
+
+// newArtifactRegistryClient lists tags in Google Artifact Registry with
+// "gcloud artifacts docker images list".
+func newArtifactRegistryClient(string) (RegistryClient, error) {
+	return newListingClient(getTagsForArtifactRegistryImage), nil
+}
+
+func getTagsForArtifactRegistryImage(repo *DockerImageRef) ([]TimestampedTag, error) {
+	fullRepo := repo.WithoutTag()
+	output, err := runner.Run("gcloud", "artifacts", "docker", "images", "list", fullRepo, "--include-tags", "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf(`failed listing tags for Artifact Registry image %q: %v`, fullRepo, err)
+	}
+	var images []struct {
+		Package    string `json:"package"`
+		CreateTime string `json:"createTime"`
+		// Version is the digest of the image
+		Version string `json:"version"`
+		// Tags is a comma separated string in some gcloud versions, and a list in others
+		Tags json.RawMessage `json:"tags"`
+	}
+	if err = json.Unmarshal(output, &images); err != nil {
+		return nil, fmt.Errorf(`failed decoding output when getting tags for Artifact Registry image %q: %v`, fullRepo, err)
+	}
+	tags := make([]TimestampedTag, 0)
+	for _, img := range images {
+		// images in sub-paths of the repo are listed too
+		if img.Package != fullRepo {
+			continue
+		}
+		imageTags, err := parseArtifactRegistryTags(img.Tags)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid tags for Artifact Registry image %q: %v`, fullRepo, err)
+		}
+		if len(imageTags) == 0 {
+			continue
+		}
+		timestamp, err := ParseDockerTimestamp(img.CreateTime)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid createTime for Artifact Registry image %q: %v`, fullRepo, err)
+		}
+		for _, tag := range imageTags {
+			tags = append(tags, TimestampedTag{Tag: tag, Timestamp: timestamp, Digest: img.Version})
+		}
+	}
+	return tags, nil
+}
+
+func parseArtifactRegistryTags(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var tags []string
+	if err := json.Unmarshal(raw, &tags); err == nil {
+		return tags, nil
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return nil, err
+	}
+	if str == "" {
+		return nil, nil
+	}
+	return strings.Split(str, ","), nil
+}
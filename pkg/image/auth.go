@@ -0,0 +1,212 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// Credentials for a Docker registry. Both are empty for anonymous access.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// DockerConfig is the part of Docker's config.json used for registry authentication.
+type DockerConfig struct {
+	Auths       map[string]DockerAuth `json:"auths,omitempty"`
+	CredHelpers map[string]string     `json:"credHelpers,omitempty"`
+	CredsStore  string                `json:"credsStore,omitempty"`
+}
+
+type DockerAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// dockerHubAuthKeys are the keys Docker Hub credentials may be stored under
+var dockerHubAuthKeys = []string{"https://index.docker.io/v1/", "index.docker.io", "docker.io", DefaultDockerRegistry}
+
+// registries configured in the KubeCD config, see UseRegistries
+var registries []model.Registry
+
+// UseRegistries sets the registries configured in the KubeCD config, whose
+// credentials are used before any found in Docker's config, and whose types
+// select the RegistryClient for matching hosts.
+func UseRegistries(configured []model.Registry) error {
+	for _, registry := range configured {
+		if _, err := path.Match(registry.Host, ""); err != nil {
+			return fmt.Errorf(`invalid registry host pattern %q: %v`, registry.Host, err)
+		}
+		if _, found := registryClients[registry.Type]; registry.Type != "" && !found {
+			return fmt.Errorf(`unknown type %q for registry %q, must be one of: %s`,
+				registry.Type, registry.Host, strings.Join(RegistryTypes(), ", "))
+		}
+	}
+	registries = configured
+	return nil
+}
+
+// DockerConfigDir returns $DOCKER_CONFIG, or ~/.docker.
+func DockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// LoadDockerConfig reads config.json from a directory. A missing file gives an empty config.
+func LoadDockerConfig(dir string) (*DockerConfig, error) {
+	config := &DockerConfig{}
+	if dir == "" {
+		return config, nil
+	}
+	configFile := filepath.Join(dir, "config.json")
+	data, err := ioutil.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf(`error while decoding %q: %v`, configFile, err)
+	}
+	return config, nil
+}
+
+// GetRegistryCredentials finds credentials for a registry host, looking at
+// the registries in the KubeCD config, then Docker's config.
+func GetRegistryCredentials(host string) (Credentials, error) {
+	for _, registry := range registries {
+		if (registry.Username != "" || registry.Password != "") && matchHost(registry.Host, host) {
+			return Credentials{Username: registry.GetUsername(), Password: registry.GetPassword()}, nil
+		}
+	}
+	config, err := LoadDockerConfig(DockerConfigDir())
+	if err != nil {
+		return Credentials{}, err
+	}
+	return config.Credentials(host)
+}
+
+// Credentials finds credentials for a registry host, the way Docker does:
+// a credential helper for the host, stored credentials, or the default
+// credentials store.
+func (c *DockerConfig) Credentials(host string) (Credentials, error) {
+	keys := []string{host}
+	if host == DefaultDockerRegistry {
+		keys = dockerHubAuthKeys
+	}
+	for _, key := range keys {
+		if helper, found := c.CredHelpers[key]; found {
+			return credentialsFromHelper(helper, key)
+		}
+	}
+	for _, key := range keys {
+		if auth, found := c.Auths[key]; found {
+			return auth.credentials()
+		}
+	}
+	for key, auth := range c.Auths {
+		if stringInSlice(authKeyHost(key), keys) {
+			return auth.credentials()
+		}
+	}
+	if c.CredsStore != "" {
+		return credentialsFromHelper(c.CredsStore, keys[0])
+	}
+	return Credentials{}, nil
+}
+
+// authKeyHost strips the scheme and path from keys like "https://index.docker.io/v1/"
+func authKeyHost(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	if key == dockerHubAuthKeys[0] {
+		return key
+	}
+	if slash := strings.IndexByte(host, '/'); slash != -1 {
+		host = host[:slash]
+	}
+	return host
+}
+
+func (a DockerAuth) credentials() (Credentials, error) {
+	if a.IdentityToken != "" {
+		return Credentials{Username: a.Username, Password: a.IdentityToken}, nil
+	}
+	if a.Auth == "" {
+		return Credentials{Username: a.Username, Password: a.Password}, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return Credentials{}, fmt.Errorf(`invalid "auth" in docker config: %v`, err)
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return Credentials{}, fmt.Errorf(`invalid "auth" in docker config: expected "username:password"`)
+	}
+	return Credentials{Username: parts[0], Password: parts[1]}, nil
+}
+
+// credentialsFromHelper runs "docker-credential-<helper> get" for a server.
+// The helper not having any credentials for it means anonymous access.
+func credentialsFromHelper(helper, serverURL string) (Credentials, error) {
+	output, err := runner.RunWithInput([]byte(serverURL), "docker-credential-"+helper, "get")
+	if err != nil {
+		if strings.Contains(string(output), "credentials not found") {
+			return Credentials{}, nil
+		}
+		return Credentials{}, fmt.Errorf(`docker-credential-%s failed for %q: %v`, helper, serverURL, err)
+	}
+	var result struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err = json.Unmarshal(output, &result); err != nil {
+		return Credentials{}, fmt.Errorf(`could not decode output of docker-credential-%s: %v`, helper, err)
+	}
+	return Credentials{Username: result.Username, Password: result.Secret}, nil
+}
+
+func stringInSlice(str string, slice []string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/exec"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+func TestDockerConfigCredentials(t *testing.T) {
+	oldRunner := runner
+	defer func() { runner = oldRunner }()
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	config := &DockerConfig{
+		Auths: map[string]DockerAuth{
+			"https://index.docker.io/v1/": {Auth: auth},
+			"https://harbor.example.com":  {Username: "harbor-user", Password: "harbor-pass"},
+			"artifactory.example.com":     {IdentityToken: "token"},
+			"https://broken.example.com/": {Auth: "not base64"},
+		},
+		CredHelpers: map[string]string{"gcr.io": "gcloud"},
+		CredsStore:  "desktop",
+	}
+
+	credentials, err := config.Credentials(DefaultDockerRegistry)
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{Username: "user", Password: "pass"}, credentials)
+
+	credentials, err = config.Credentials("harbor.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{Username: "harbor-user", Password: "harbor-pass"}, credentials)
+
+	credentials, err = config.Credentials("artifactory.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{Password: "token"}, credentials)
+
+	_, err = config.Credentials("broken.example.com")
+	assert.Error(t, err)
+
+	runner = exec.TestRunner{
+		ExpectedCommand: []string{"docker-credential-gcloud", "get"},
+		ExpectedInput:   []byte("gcr.io"),
+		Output:          []byte(`{"ServerURL":"gcr.io","Username":"_token","Secret":"secret"}`),
+	}
+	credentials, err = config.Credentials("gcr.io")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{Username: "_token", Password: "secret"}, credentials)
+
+	runner = exec.TestRunner{
+		ExpectedCommand: []string{"docker-credential-desktop", "get"},
+		ExpectedInput:   []byte("other.example.com"),
+		Output:          []byte("credentials not found in native keychain"),
+		ExitCode:        1,
+	}
+	credentials, err = config.Credentials("other.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{}, credentials)
+}
+
+func TestGetRegistryCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-docker-config")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"),
+		[]byte(`{"auths": {"harbor.example.com": {"username": "docker", "password": "docker"}}}`), 0600))
+	oldDockerConfig, hadDockerConfig := os.LookupEnv("DOCKER_CONFIG")
+	defer func() {
+		if hadDockerConfig {
+			_ = os.Setenv("DOCKER_CONFIG", oldDockerConfig)
+		} else {
+			_ = os.Unsetenv("DOCKER_CONFIG")
+		}
+		_ = UseRegistries(nil)
+	}()
+	require.NoError(t, os.Setenv("DOCKER_CONFIG", dir))
+	require.NoError(t, os.Setenv("KCD_TEST_PASSWORD", "secret"))
+	defer func() { _ = os.Unsetenv("KCD_TEST_PASSWORD") }()
+
+	credentials, err := GetRegistryCredentials("harbor.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{Username: "docker", Password: "docker"}, credentials)
+
+	require.NoError(t, UseRegistries([]model.Registry{
+		{Host: "*.example.com", Type: "v2"},
+		{Host: "harbor.example.com", Username: "kcd", Password: "${KCD_TEST_PASSWORD}"},
+	}))
+	credentials, err = GetRegistryCredentials("harbor.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{Username: "kcd", Password: "secret"}, credentials)
+
+	credentials, err = GetRegistryCredentials("quay.io")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{}, credentials)
+}
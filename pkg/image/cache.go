@@ -0,0 +1,124 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultTagCacheTTL is how long a cached tag list is used before it is listed again.
+const DefaultTagCacheTTL = 15 * time.Minute
+
+// noTimestamp marks cached tags without an image for a platform
+const noTimestamp = int64(-1)
+
+// TagCache stores the tags of images on disk, one file per image repo.
+// Tag lists are listed again when older than TTL, but tag timestamps are
+// never fetched again once cached.
+type TagCache struct {
+	Dir string
+	TTL time.Duration
+	// Offline means only cached tags are used, regardless of TTL
+	Offline bool
+}
+
+// cachedTags is the content of a tag cache file
+type cachedTags struct {
+	Repo string `json:"repo"`
+	// Listed is when Tags were listed, in seconds since the epoch
+	Listed int64    `json:"listed"`
+	Tags   []string `json:"tags"`
+	// Timestamps by platform and tag
+	Timestamps map[string]map[string]int64 `json:"timestamps"`
+}
+
+// DefaultTagCacheDir returns the tag cache directory in the user's cache directory.
+func DefaultTagCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "kcd", "tags"), nil
+}
+
+func (c *TagCache) fileName(repo string) string {
+	sum := sha256.Sum256([]byte(repo))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load returns the cached tags for an image repo, or nil if it is not cached.
+func (c *TagCache) load(repo string) (*cachedTags, error) {
+	data, err := ioutil.ReadFile(c.fileName(repo))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached := &cachedTags{}
+	if err = json.Unmarshal(data, cached); err != nil || cached.Repo != repo {
+		// a broken cache file is ignored and replaced
+		return nil, nil
+	}
+	return cached, nil
+}
+
+func (c *TagCache) save(cached *cachedTags) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	fileName := c.fileName(cached.Repo)
+	tmpFile, err := ioutil.TempFile(c.Dir, ".tags-")
+	if err != nil {
+		return err
+	}
+	if _, err = tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), fileName); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return fmt.Errorf(`could not write tag cache %q: %v`, fileName, err)
+	}
+	return nil
+}
+
+// expired tells whether a cached tag list should be listed again
+func (c *TagCache) expired(cached *cachedTags, now time.Time) bool {
+	if c.Offline {
+		return false
+	}
+	return now.Sub(time.Unix(cached.Listed, 0)) >= c.TTL
+}
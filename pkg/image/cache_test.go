@@ -0,0 +1,123 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegistryClient counts calls, and has no image for tags without a timestamp
+type fakeRegistryClient struct {
+	tags       []string
+	timestamps map[string]int64
+	mutex      sync.Mutex
+	listed     int
+	fetched    []string
+}
+
+func (f *fakeRegistryClient) Tags(*DockerImageRef) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.listed++
+	return f.tags, nil
+}
+
+func (f *fakeRegistryClient) Digest(*DockerImageRef, string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeRegistryClient) Timestamp(_ *DockerImageRef, tag string) (int64, bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.fetched = append(f.fetched, tag)
+	timestamp, found := f.timestamps[tag]
+	return timestamp, found, nil
+}
+
+func TestTagScanCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-tag-cache")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	source := &fakeRegistryClient{
+		tags:       []string{"1.0", "1.1", "windows"},
+		timestamps: map[string]int64{"1.0": 100, "1.1": 0},
+	}
+	cache := &TagCache{Dir: dir, TTL: time.Minute}
+	now := time.Unix(1000000, 0)
+	newScan := func() *tagScan {
+		return &tagScan{
+			slots:   make(chan struct{}, 2),
+			cache:   cache,
+			clients: map[string]RegistryClient{"registry.local": source},
+			now:     now,
+		}
+	}
+	expected := []TimestampedTag{{Tag: "1.0", Timestamp: 100}, {Tag: "1.1", Timestamp: 0}}
+
+	tags, err := newScan().repo("registry.local/app")
+	require.NoError(t, err)
+	assert.Equal(t, expected, tags)
+	assert.Equal(t, 1, source.listed)
+	assert.ElementsMatch(t, []string{"1.0", "1.1", "windows"}, source.fetched)
+
+	// within the TTL, nothing is fetched
+	source.fetched = nil
+	tags, err = newScan().repo("registry.local/app")
+	require.NoError(t, err)
+	assert.Equal(t, expected, tags)
+	assert.Equal(t, 1, source.listed)
+	assert.Empty(t, source.fetched)
+
+	// after the TTL, tags are listed again, but only timestamps of new tags are fetched
+	source.tags = append(source.tags, "1.2")
+	source.timestamps["1.2"] = 200
+	now = now.Add(time.Minute)
+	tags, err = newScan().repo("registry.local/app")
+	require.NoError(t, err)
+	assert.Equal(t, append(expected, TimestampedTag{Tag: "1.2", Timestamp: 200}), tags)
+	assert.Equal(t, 2, source.listed)
+	assert.Equal(t, []string{"1.2"}, source.fetched)
+
+	// offline, the cache is used regardless of the TTL
+	source.tags = append(source.tags, "1.3")
+	now = now.Add(time.Hour)
+	cache.Offline = true
+	tags, err = newScan().repo("registry.local/app")
+	require.NoError(t, err)
+	assert.Len(t, tags, 3)
+	assert.Equal(t, 2, source.listed)
+	_, err = newScan().repo("registry.local/other")
+	assert.Error(t, err)
+
+	// without a cache, everything is fetched
+	source.fetched = nil
+	scan := newScan()
+	scan.cache = nil
+	tags, err = scan.repo("registry.local/app")
+	require.NoError(t, err)
+	assert.Len(t, tags, 3)
+	assert.Len(t, source.fetched, 5)
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterRegistryClient("ecr", newECRClient, "*.dkr.ecr.*.amazonaws.com", "*.dkr.ecr.*.amazonaws.com.cn")
+}
+
+// newECRClient lists tags in AWS ECR with "aws ecr describe-images". The
+// registry ID and region are taken from hosts like
+// "123456789012.dkr.ecr.eu-west-1.amazonaws.com".
+func newECRClient(host string) (RegistryClient, error) {
+	parts := strings.Split(host, ".")
+	if len(parts) < 6 || parts[1] != "dkr" || parts[2] != "ecr" {
+		return nil, fmt.Errorf(`%q is not an ECR registry, expected "<account>.dkr.ecr.<region>.amazonaws.com"`, host)
+	}
+	registryID, region := parts[0], parts[3]
+	return newListingClient(func(repo *DockerImageRef) ([]TimestampedTag, error) {
+		return getTagsForECRImage(repo, registryID, region)
+	}), nil
+}
+
+func getTagsForECRImage(repo *DockerImageRef, registryID, region string) ([]TimestampedTag, error) {
+	output, err := runner.Run("aws", "ecr", "describe-images",
+		"--registry-id", registryID, "--region", region, "--repository-name", repo.Image,
+		"--filter", "tagStatus=TAGGED", "--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf(`failed listing tags for ECR image %q: %v`, repo.WithoutTag(), err)
+	}
+	var result struct {
+		ImageDetails []struct {
+			ImageTags   []string `json:"imageTags"`
+			ImageDigest string   `json:"imageDigest"`
+			// ImagePushedAt is seconds since the epoch from AWS CLI v1, and RFC 3339 from v2
+			ImagePushedAt json.RawMessage `json:"imagePushedAt"`
+		} `json:"imageDetails"`
+	}
+	if err = json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf(`failed decoding output when getting tags for ECR image %q: %v`, repo.WithoutTag(), err)
+	}
+	tags := make([]TimestampedTag, 0)
+	for _, details := range result.ImageDetails {
+		timestamp, err := parseECRTimestamp(details.ImagePushedAt)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid imagePushedAt for ECR image %q: %v`, repo.WithoutTag(), err)
+		}
+		for _, tag := range details.ImageTags {
+			tags = append(tags, TimestampedTag{Tag: tag, Timestamp: timestamp, Digest: details.ImageDigest})
+		}
+	}
+	return tags, nil
+}
+
+func parseECRTimestamp(raw json.RawMessage) (int64, error) {
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		timestamp, err := time.Parse(time.RFC3339Nano, str)
+		if err != nil {
+			return 0, err
+		}
+		return timestamp.Unix(), nil
+	}
+	seconds, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(seconds), nil
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"fmt"
+	"os"
+)
+
+func init() {
+	RegisterRegistryClient("ghcr", newGHCRClient, "ghcr.io")
+}
+
+// newGHCRClient uses the registry API of GitHub Container Registry. Without
+// other credentials, $GITHUB_TOKEN is used if set, as in GitHub Actions.
+func newGHCRClient(host string) (RegistryClient, error) {
+	credentials, err := GetRegistryCredentials(host)
+	if err != nil {
+		return nil, fmt.Errorf(`could not get credentials for Docker registry %q: %v`, host, err)
+	}
+	if credentials.Password == "" && os.Getenv("GITHUB_TOKEN") != "" {
+		credentials.Username = os.Getenv("GITHUB_ACTOR")
+		if credentials.Username == "" {
+			credentials.Username = "kcd"
+		}
+		credentials.Password = os.Getenv("GITHUB_TOKEN")
+	}
+	return newV2ClientWithCredentials(host, credentials)
+}
@@ -21,9 +21,10 @@ package image
 import (
 	"encoding/json"
 	"fmt"
-	mmsemver "github.com/Masterminds/semver"
-	registry2 "github.com/heroku/docker-registry-client/registry"
+	mmsemver "github.com/Masterminds/semver/v3"
+	"github.com/kubecd/kubecd/pkg/model"
 	"github.com/kubecd/kubecd/pkg/semver"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -41,18 +42,124 @@ func ParseDockerTimestamp(str string) (int64, error) {
 	return timestamp.Unix(), nil
 }
 
+// DockerImageRef is a parsed image reference, like
+// "registry.example.com:5000/team/app:1.0@sha256:...".
 type DockerImageRef struct {
+	// Registry is the registry host name, without port
 	Registry string
-	Image    string
-	Tag      string
+	Port     string
+	// Image is the path of the image in the registry
+	Image  string
+	Tag    string
+	Digest string
 }
 
+var (
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	tagRegexp           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp        = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+	portRegexp          = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// dockerHubHosts are names of Docker Hub used in image references
+var dockerHubHosts = []string{"docker.io", "index.docker.io", DefaultDockerRegistry}
+
+// NewDockerImageRef parses an image reference. Invalid references are parsed
+// as well as possible, see ParseDockerImageRef for validation.
 func NewDockerImageRef(repo string) *DockerImageRef {
 	return parseImageRepo(repo)
 }
 
+// ParseDockerImageRef parses an image reference following the grammar of the
+// Docker distribution reference package. The first path component is the
+// registry if it has a dot or a port, or is "localhost". Docker Hub images
+// get DefaultDockerRegistry as registry, and official images are prefixed
+// with "library/".
+func ParseDockerImageRef(repo string) (*DockerImageRef, error) {
+	if strings.HasSuffix(repo, ":") || strings.HasSuffix(repo, "@") || strings.Contains(repo, ":@") {
+		return nil, fmt.Errorf(`invalid image reference %q: empty tag or digest`, repo)
+	}
+	result := parseImageRepo(repo)
+	if err := result.validate(); err != nil {
+		return nil, fmt.Errorf(`invalid image reference %q: %v`, repo, err)
+	}
+	return result, nil
+}
+
+func parseImageRepo(repo string) *DockerImageRef {
+	result := &DockerImageRef{}
+	name := repo
+	if at := strings.IndexByte(name, '@'); at != -1 {
+		result.Digest = name[at+1:]
+		name = name[:at]
+	}
+	lastSlash := strings.LastIndexByte(name, '/')
+	if colon := strings.LastIndexByte(name, ':'); colon > lastSlash {
+		result.Tag = name[colon+1:]
+		name = name[:colon]
+	}
+	if slash := strings.IndexByte(name, '/'); slash != -1 {
+		first := name[:slash]
+		if strings.ContainsAny(first, ".:") || first == "localhost" || strings.ToLower(first) != first {
+			result.Registry, result.Port = splitHostPort(first)
+			name = name[slash+1:]
+		}
+	}
+	if result.Registry == "" || (result.Port == "" && stringInSlice(result.Registry, dockerHubHosts)) {
+		result.Registry = DefaultDockerRegistry
+		if !strings.ContainsRune(name, '/') {
+			name = "library/" + name
+		}
+	}
+	result.Image = name
+	return result
+}
+
+func splitHostPort(hostPort string) (string, string) {
+	colon := strings.LastIndexByte(hostPort, ':')
+	if colon == -1 || colon < strings.LastIndexByte(hostPort, ']') {
+		return hostPort, ""
+	}
+	return hostPort[:colon], hostPort[colon+1:]
+}
+
+func (r DockerImageRef) validate() error {
+	if r.Port != "" && !portRegexp.MatchString(r.Port) {
+		return fmt.Errorf(`invalid port %q`, r.Port)
+	}
+	for _, component := range strings.Split(r.Image, "/") {
+		if !pathComponentRegexp.MatchString(component) {
+			return fmt.Errorf(`invalid path component %q`, component)
+		}
+	}
+	if r.Tag != "" && !tagRegexp.MatchString(r.Tag) {
+		return fmt.Errorf(`invalid tag %q`, r.Tag)
+	}
+	if r.Digest != "" && !digestRegexp.MatchString(r.Digest) {
+		return fmt.Errorf(`invalid digest %q`, r.Digest)
+	}
+	return nil
+}
+
+// Host returns the registry host with port, if any.
+func (r DockerImageRef) Host() string {
+	if r.Port != "" {
+		return r.Registry + ":" + r.Port
+	}
+	return r.Registry
+}
+
 func (r DockerImageRef) RegistryURL() string {
-	return "https://" + r.Registry
+	return registryURL(r.Host())
+}
+
+// registryURL uses plain HTTP for registries on localhost, as Docker does.
+func registryURL(host string) string {
+	hostname, _ := splitHostPort(host)
+	if hostname == "localhost" || hostname == "127.0.0.1" || hostname == "[::1]" {
+		return "http://" + host
+	}
+	return "https://" + host
 }
 
 func (r DockerImageRef) WithTag() string {
@@ -62,27 +169,27 @@ func (r DockerImageRef) WithTag() string {
 func (r DockerImageRef) WithoutTag() string {
 	tmp := ""
 	if r.Registry != "" {
-		tmp += r.Registry + "/"
+		tmp += r.Host() + "/"
 	}
 	return tmp + r.Image
 }
 
-func parseImageRepo(repo string) *DockerImageRef {
-	result := &DockerImageRef{}
-	tmp := strings.Split(repo, "/")
-	if len(tmp) > 1 && strings.IndexByte(tmp[0], '.') != -1 {
-		result.Registry = tmp[0]
-		tmp = tmp[1:]
-	}
-	lastElem := tmp[len(tmp)-1]
-	colonIndex := strings.IndexByte(lastElem, ':')
-	if colonIndex != -1 {
-		result.Tag = lastElem[colonIndex+1:]
-		tmp[len(tmp)-1] = lastElem[0:colonIndex]
-	}
-	result.Image = strings.Join(tmp, "/")
-	if result.Registry == "" {
-		result.Registry = DefaultDockerRegistry
+// SplitTagDigest splits a tag that may be pinned to a digest, like "1.0@sha256:...".
+func SplitTagDigest(tag string) (string, string) {
+	if at := strings.IndexByte(tag, '@'); at != -1 {
+		return tag[:at], tag[at+1:]
+	}
+	return tag, ""
+}
+
+// String returns the full reference, with tag and digest if set.
+func (r DockerImageRef) String() string {
+	result := r.WithoutTag()
+	if r.Tag != "" {
+		result += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		result += "@" + r.Digest
 	}
 	return result
 }
@@ -90,7 +197,9 @@ func parseImageRepo(repo string) *DockerImageRef {
 type TimestampedTag struct {
 	Tag       string
 	Timestamp int64
-	semantic  *mmsemver.Version
+	// Digest of the tag's manifest, for registries listing it with the tags
+	Digest   string
+	semantic *mmsemver.Version
 }
 
 func (t *TimestampedTag) Semantic() *mmsemver.Version {
@@ -131,7 +240,7 @@ func GetTagsForGcrImage(repo *DockerImageRef) ([]TimestampedTag, error) {
 		ts := imgTag.Timestamp
 		timestamp := time.Date(ts.Year, time.Month(ts.Month+1), ts.Day, ts.Hour, ts.Minute, ts.Second, 0, time.UTC)
 		for _, tag := range imgTag.Tags {
-			result = append(result, TimestampedTag{Tag: tag, Timestamp: timestamp.Unix()})
+			result = append(result, TimestampedTag{Tag: tag, Timestamp: timestamp.Unix(), Digest: imgTag.Digest})
 		}
 	}
 	return result, nil
@@ -142,47 +251,16 @@ func GetTagsForDockerHubImage(repo *DockerImageRef) ([]TimestampedTag, error) {
 }
 
 func GetTagsForDockerV2RegistryImage(repo *DockerImageRef) ([]TimestampedTag, error) {
-	registry, err := registry2.New(repo.RegistryURL(), "", "")
-	result := make([]TimestampedTag, 0)
-	if err != nil {
-		return nil, fmt.Errorf(`could not access Docker registry %q: %v`, repo.Registry, err)
-	}
-	tags, err := registry.Tags(repo.Image)
-	if err != nil {
-		return nil, fmt.Errorf(`could not list tags for %s: %v`, repo.WithoutTag(), err)
-	}
-	for _, tag := range tags {
-		manifest, err := registry.Manifest(repo.Image, tag)
-		if err != nil {
-			return nil, fmt.Errorf(`could not get manifest for %s:%s: %v`, repo.WithoutTag(), tag, err)
-		}
-		type v1Compat struct {
-			Created string `json:"created"`
-		}
-		if len(manifest.History) > 0 {
-			var v1Compat v1Compat
-			if err = json.Unmarshal([]byte(manifest.History[0].V1Compatibility), &v1Compat); err != nil {
-				return nil, fmt.Errorf(`could not decode tag timestamp for %s:%s: %v`, repo.WithoutTag(), tag, err)
-			}
-			timestamp, err := ParseDockerTimestamp(v1Compat.Created)
-			if err != nil {
-				return nil, fmt.Errorf(`could not parse timestamp for %s:%s: %v`, repo.WithoutTag(), tag, err)
-			}
-			result = append(result, TimestampedTag{Tag: tag, Timestamp: timestamp})
-		}
-	}
-	return result, nil
+	return GetTagsForDockerImage(repo.WithoutTag())
 }
 
 func GetTagsForDockerImage(repo string) ([]TimestampedTag, error) {
-	imgRef := parseImageRepo(repo)
-	if strings.HasSuffix(imgRef.Registry, GCRRegistrySuffix) {
-		return GetTagsForGcrImage(imgRef)
-	}
-	if imgRef.Registry == DefaultDockerRegistry {
-		return GetTagsForDockerHubImage(imgRef)
+	repoWithoutTag := parseImageRepo(repo).WithoutTag()
+	tags, err := GetTagsForDockerImages([]string{repoWithoutTag})
+	if err != nil {
+		return nil, err
 	}
-	return GetTagsForDockerV2RegistryImage(imgRef)
+	return tags[repoWithoutTag], nil
 }
 
 func FilterSemverTags(tags []string) []string {
@@ -196,30 +274,10 @@ func FilterSemverTags(tags []string) []string {
 }
 
 // GetNewestMatchingTag returns the "newest" (as defined by the track parameter) candidate
-// tag, or the current tag if no better ones were found.
-func GetNewestMatchingTag(currentTag TimestampedTag, candidateTags []TimestampedTag, track string) TimestampedTag {
-	var foundTag = currentTag
-	if track == semver.TrackNewest {
-		for _, candidateTag := range candidateTags {
-			if candidateTag.Tag == "latest" {
-				continue
-			}
-			if candidateTag.Timestamp > foundTag.Timestamp {
-				foundTag = candidateTag
-			}
-		}
-	}
-	semanticTags := make([]*mmsemver.Version, 0)
-	semTagMap := make(map[string]TimestampedTag)
-	for _, ct := range candidateTags {
-		if st := ct.Semantic(); st != nil {
-			semanticTags = append(semanticTags, st)
-			semTagMap[st.String()] = ct
-		}
-	}
-	newest, err := semver.BestUpgrade(currentTag.Semantic(), semanticTags, track)
-	if err != nil {
-		return foundTag
-	}
-	return semTagMap[newest.String()]
+// tag, or the current tag if no better ones were found. Tags matching the exclude list
+// are never chosen.
+func GetNewestMatchingTag(currentTag TimestampedTag, candidateTags []TimestampedTag, track string, exclude []string) TimestampedTag {
+	trigger := &model.ImageTrigger{Track: track, Exclude: exclude}
+	selector := &TagSelector{Policy: triggerPolicy(trigger), SortBy: trigger.SortByString()}
+	return selector.Newest(currentTag, candidateTags)
 }
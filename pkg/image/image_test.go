@@ -33,17 +33,21 @@ func TestGetNewestMatchingTag(t *testing.T) {
 		current    string
 		candidates []string
 		track      string
+		exclude    []string
 		expected   string
 	}
 	for i, tc := range []testCase{
-		{"1.0", []string{"0.9.0", "1.0.1", "1.1", "2.0"}, semver.TrackPatchLevel, "1.0.1"},
-		{"1.0", []string{"0.9.0", "1.0.1", "1.1", "2.0"}, semver.TrackMinorVersion, "1.1"},
-		{"1.0", []string{"0.9.0", "1.0.1", "1.1", "2.0"}, semver.TrackMajorVersion, "2.0"},
-		{"1.0", []string{"0.9.0", "v1.0.1", "1.1", "2.0"}, semver.TrackPatchLevel, "v1.0.1"},
-		{"1.0", []string{"0.9.0", "1.0.1", "v1.1", "2.0"}, semver.TrackMinorVersion, "v1.1"},
-		{"1.0", []string{"0.9.0", "1.0.1", "1.1", "v2.0"}, semver.TrackMajorVersion, "v2.0"},
-		{"foo", []string{"c", "b", "a"}, semver.TrackNewest, "a"},
-		{"foo", []string{"a", "b", "c"}, semver.TrackNewest, "c"},
+		{"1.0", []string{"0.9.0", "1.0.1", "1.1", "2.0"}, semver.TrackPatchLevel, nil, "1.0.1"},
+		{"1.0", []string{"0.9.0", "1.0.1", "1.1", "2.0"}, semver.TrackMinorVersion, nil, "1.1"},
+		{"1.0", []string{"0.9.0", "1.0.1", "1.1", "2.0"}, semver.TrackMajorVersion, nil, "2.0"},
+		{"1.0", []string{"0.9.0", "v1.0.1", "1.1", "2.0"}, semver.TrackPatchLevel, nil, "v1.0.1"},
+		{"1.0", []string{"0.9.0", "1.0.1", "v1.1", "2.0"}, semver.TrackMinorVersion, nil, "v1.1"},
+		{"1.0", []string{"0.9.0", "1.0.1", "1.1", "v2.0"}, semver.TrackMajorVersion, nil, "v2.0"},
+		{"foo", []string{"c", "b", "a"}, semver.TrackNewest, nil, "a"},
+		{"foo", []string{"a", "b", "c"}, semver.TrackNewest, nil, "c"},
+		{"foo", []string{"a", "b", "c"}, semver.TrackNewest, []string{"c"}, "b"},
+		{"2.2", []string{"2.2.1", "2.5.0", "3.0.0"}, ">=2.3 <3", nil, "2.5.0"},
+		{"1.0", []string{"1.0.1", "1.0.2", "1.0.3"}, semver.TrackPatchLevel, []string{"1.0.3"}, "1.0.2"},
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
 			current := TimestampedTag{Tag: tc.current}
@@ -52,7 +56,7 @@ func TestGetNewestMatchingTag(t *testing.T) {
 				candidates[j] = TimestampedTag{Tag: tag, Timestamp: int64(j)}
 			}
 			expected := TimestampedTag{Tag: tc.expected}
-			assert.Equal(t, expected.Tag, GetNewestMatchingTag(current, candidates, tc.track).Tag)
+			assert.Equal(t, expected.Tag, GetNewestMatchingTag(current, candidates, tc.track, tc.exclude).Tag)
 		})
 	}
 }
@@ -94,8 +98,35 @@ func TestNewDockerImageRef(t *testing.T) {
 		{expected: DockerImageRef{Registry: "eu.gcr.io", Image: "kubecd-demo/prod-demo-app", Tag: "v1.1"}, image: "eu.gcr.io/kubecd-demo/prod-demo-app:v1.1"},
 		{expected: DockerImageRef{Registry: "eu.gcr.io", Image: "kubecd-demo/prod-demo-app", Tag: ""}, image: "eu.gcr.io/kubecd-demo/prod-demo-app"},
 		{expected: DockerImageRef{Registry: DefaultDockerRegistry, Image: "kubecd/kubecd", Tag: "latest"}, image: "kubecd/kubecd:latest"},
+		{expected: DockerImageRef{Registry: DefaultDockerRegistry, Image: "library/nginx", Tag: "1.19"}, image: "nginx:1.19"},
+		{expected: DockerImageRef{Registry: DefaultDockerRegistry, Image: "library/nginx"}, image: "docker.io/nginx"},
+		{expected: DockerImageRef{Registry: DefaultDockerRegistry, Image: "library/nginx"}, image: "index.docker.io/library/nginx"},
+		{expected: DockerImageRef{Registry: "localhost", Port: "5000", Image: "app", Tag: "1.0"}, image: "localhost:5000/app:1.0"},
+		{expected: DockerImageRef{Registry: "localhost", Image: "app"}, image: "localhost/app"},
+		{expected: DockerImageRef{Registry: "registry", Port: "5000", Image: "team/app"}, image: "registry:5000/team/app"},
+		{expected: DockerImageRef{Registry: "registry.example.com", Image: "a/b/c", Tag: "v1", Digest: testDigest}, image: "registry.example.com/a/b/c:v1@" + testDigest},
+		{expected: DockerImageRef{Registry: "registry.example.com", Port: "443", Image: "app", Digest: testDigest}, image: "registry.example.com:443/app@" + testDigest},
 	} {
 		ref := NewDockerImageRef(tc.image)
-		assert.Equal(t, tc.expected, *ref)
+		assert.Equal(t, tc.expected, *ref, tc.image)
+	}
+}
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseDockerImageRef(t *testing.T) {
+	ref, err := ParseDockerImageRef("localhost:5000/team/app:1.0@" + testDigest)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:5000", ref.Host())
+	assert.Equal(t, "http://localhost:5000", ref.RegistryURL())
+	assert.Equal(t, "localhost:5000/team/app", ref.WithoutTag())
+	assert.Equal(t, "localhost:5000/team/app:1.0", ref.WithTag())
+	assert.Equal(t, "localhost:5000/team/app:1.0@"+testDigest, ref.String())
+	assert.Equal(t, "https://registry.example.com", NewDockerImageRef("registry.example.com/app").RegistryURL())
+	for _, invalid := range []string{
+		"Team/App", "app:", "app:-1", "app@sha256:1234", "registry.example.com:port/app", "app//name",
+	} {
+		_, err = ParseDockerImageRef(invalid)
+		assert.Error(t, err, invalid)
 	}
 }
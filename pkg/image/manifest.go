@@ -0,0 +1,266 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	registry2 "github.com/heroku/docker-registry-client/registry"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Manifest media types kcd can read timestamps from.
+const (
+	MediaTypeSchema1Manifest       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeSchema1SignedManifest = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeSchema2Manifest       = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeSchema2ManifestList   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest           = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex              = "application/vnd.oci.image.index.v1+json"
+)
+
+// acceptedManifestTypes in order of preference
+var acceptedManifestTypes = []string{
+	MediaTypeOCIIndex,
+	MediaTypeSchema2ManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeSchema2Manifest,
+	MediaTypeSchema1SignedManifest,
+	MediaTypeSchema1Manifest,
+}
+
+// DefaultPlatform is the platform picked from multi-arch images unless
+// another is set with UsePlatform.
+const DefaultPlatform = "linux/amd64"
+
+// Platform of an image in a manifest list or index.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// ParsePlatform parses platforms like "linux/amd64" or "linux/arm/v7".
+func ParsePlatform(str string) (Platform, error) {
+	parts := strings.Split(str, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf(`invalid platform %q, must be "os/arch" or "os/arch/variant"`, str)
+	}
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// Matches tells whether an image for platform other can be used for p.
+// A variant only needs to match when p has one.
+func (p Platform) Matches(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	return p.Variant == "" || p.Variant == other.Variant
+}
+
+var platform, _ = ParsePlatform(DefaultPlatform)
+
+// UsePlatform sets the platform used for multi-arch images. An empty string means DefaultPlatform.
+func UsePlatform(str string) error {
+	if str == "" {
+		str = DefaultPlatform
+	}
+	parsed, err := ParsePlatform(str)
+	if err != nil {
+		return err
+	}
+	platform = parsed
+	return nil
+}
+
+type descriptor struct {
+	MediaType string        `json:"mediaType"`
+	Digest    digest.Digest `json:"digest"`
+	Platform  *Platform     `json:"platform,omitempty"`
+}
+
+// manifest has the fields kcd needs from any of the supported manifest types.
+type manifest struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	MediaType     string `json:"mediaType"`
+	// Config is set in schema2 and OCI image manifests
+	Config *descriptor `json:"config,omitempty"`
+	// Manifests is set in manifest lists and OCI indexes
+	Manifests []descriptor `json:"manifests,omitempty"`
+	// History is set in schema1 manifests
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history,omitempty"`
+}
+
+// getManifest fetches a manifest by tag or digest and returns it with its media type.
+func getManifest(registry *registry2.Registry, repository, reference string) (*manifest, string, error) {
+	url := strings.TrimSuffix(registry.URL, "/") + fmt.Sprintf("/v2/%s/manifests/%s", repository, reference)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", strings.Join(acceptedManifestTypes, ", "))
+	resp, err := registry.Client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	result := &manifest{}
+	if err = json.Unmarshal(body, result); err != nil {
+		return nil, "", err
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !stringInSlice(mediaType, acceptedManifestTypes) {
+		mediaType = result.MediaType
+	}
+	if mediaType == "" {
+		// OCI manifests and indexes need not have a mediaType
+		switch {
+		case result.Manifests != nil:
+			mediaType = MediaTypeOCIIndex
+		case result.Config != nil:
+			mediaType = MediaTypeOCIManifest
+		case result.SchemaVersion == 1:
+			mediaType = MediaTypeSchema1Manifest
+		}
+	}
+	return result, mediaType, nil
+}
+
+// getManifestDigest returns the digest of the manifest a tag or digest refers to.
+func getManifestDigest(registry *registry2.Registry, repository, reference string) (string, error) {
+	url := strings.TrimSuffix(registry.URL, "/") + fmt.Sprintf("/v2/%s/manifests/%s", repository, reference)
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join(acceptedManifestTypes, ", "))
+	resp, err := registry.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	if dgst := resp.Header.Get("Docker-Content-Digest"); dgst != "" {
+		return dgst, nil
+	}
+	// the registry does not tell, so the digest is computed from the manifest
+	req.Method = "GET"
+	if resp, err = registry.Client.Do(req); err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return digest.FromBytes(body).String(), nil
+}
+
+// getTagTimestamp returns the creation time of the image a tag refers to.
+// For manifest lists and indexes, the image for the configured platform is
+// used. The boolean result is false when the tag has no such image.
+func getTagTimestamp(registry *registry2.Registry, repository, tag string) (int64, bool, error) {
+	reference := tag
+	for depth := 0; depth < 2; depth++ {
+		manifest, mediaType, err := getManifest(registry, repository, reference)
+		if err != nil {
+			return 0, false, err
+		}
+		switch mediaType {
+		case MediaTypeSchema1Manifest, MediaTypeSchema1SignedManifest:
+			if len(manifest.History) == 0 {
+				return 0, false, nil
+			}
+			return parseCreated([]byte(manifest.History[0].V1Compatibility))
+		case MediaTypeSchema2Manifest, MediaTypeOCIManifest:
+			if manifest.Config == nil {
+				return 0, false, fmt.Errorf(`manifest has no config`)
+			}
+			return getConfigCreated(registry, repository, manifest.Config.Digest)
+		case MediaTypeSchema2ManifestList, MediaTypeOCIIndex:
+			reference = ""
+			for _, m := range manifest.Manifests {
+				if m.Platform != nil && platform.Matches(*m.Platform) {
+					reference = m.Digest.String()
+					break
+				}
+			}
+			if reference == "" {
+				return 0, false, nil
+			}
+		default:
+			return 0, false, fmt.Errorf(`unsupported manifest type %q`, mediaType)
+		}
+	}
+	return 0, false, fmt.Errorf(`nested manifest lists are not supported`)
+}
+
+// getConfigCreated reads "created" from an image config blob.
+func getConfigCreated(registry *registry2.Registry, repository string, configDigest digest.Digest) (int64, bool, error) {
+	reader, err := registry.DownloadBlob(repository, configDigest)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() { _ = reader.Close() }()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return 0, false, err
+	}
+	return parseCreated(data)
+}
+
+// parseCreated parses "created" from an image config or schema1 v1Compatibility
+// entry. The boolean result is false if it is not set.
+func parseCreated(data []byte) (int64, bool, error) {
+	var config struct {
+		Created string `json:"created"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return 0, false, fmt.Errorf(`could not decode image config: %v`, err)
+	}
+	if config.Created == "" {
+		return 0, false, nil
+	}
+	timestamp, err := ParseDockerTimestamp(config.Created)
+	if err != nil {
+		return 0, false, err
+	}
+	return timestamp, true, nil
+}
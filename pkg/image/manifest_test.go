@@ -0,0 +1,153 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	registry2 "github.com/heroku/docker-registry-client/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatform(t *testing.T) {
+	p, err := ParsePlatform("linux/arm/v7")
+	require.NoError(t, err)
+	assert.Equal(t, Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, p)
+	assert.Equal(t, "linux/arm/v7", p.String())
+	assert.True(t, Platform{OS: "linux", Architecture: "arm"}.Matches(p))
+	assert.False(t, p.Matches(Platform{OS: "linux", Architecture: "arm", Variant: "v6"}))
+	for _, invalid := range []string{"", "linux", "linux/", "/amd64", "linux/arm/v7/x"} {
+		_, err = ParsePlatform(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestGetTagsFromRegistry(t *testing.T) {
+	defer func() { _ = UsePlatform("") }()
+	type response struct {
+		contentType string
+		body        string
+	}
+	responses := map[string]response{
+		"/v2/test/tags/list": {"application/json",
+			`{"name":"test","tags":["schema1","schema2","oci","list","index","untyped-index","windows-only"]}`},
+		"/v2/test/manifests/schema1": {MediaTypeSchema1SignedManifest,
+			`{"schemaVersion":1,"history":[{"v1Compatibility":"{\"created\":\"2020-01-01T00:00:00Z\"}"}]}`},
+		"/v2/test/manifests/schema2": {MediaTypeSchema2Manifest,
+			`{"schemaVersion":2,"mediaType":"` + MediaTypeSchema2Manifest + `","config":{"digest":"sha256:c2"}}`},
+		"/v2/test/manifests/oci": {MediaTypeOCIManifest,
+			`{"schemaVersion":2,"config":{"digest":"sha256:c3"}}`},
+		"/v2/test/manifests/list": {MediaTypeSchema2ManifestList,
+			`{"schemaVersion":2,"manifests":[` +
+				`{"digest":"sha256:arm64","platform":{"os":"linux","architecture":"arm64"}},` +
+				`{"digest":"sha256:amd64","platform":{"os":"linux","architecture":"amd64"}}]}`},
+		"/v2/test/manifests/index": {MediaTypeOCIIndex,
+			`{"schemaVersion":2,"manifests":[{"digest":"sha256:amd64","platform":{"os":"linux","architecture":"amd64"}}]}`},
+		"/v2/test/manifests/untyped-index": {"application/json",
+			`{"schemaVersion":2,"manifests":[{"digest":"sha256:arm64","platform":{"os":"linux","architecture":"arm64"}}]}`},
+		"/v2/test/manifests/windows-only": {MediaTypeOCIIndex,
+			`{"schemaVersion":2,"manifests":[{"digest":"sha256:win","platform":{"os":"windows","architecture":"amd64"}}]}`},
+		"/v2/test/manifests/sha256:amd64": {MediaTypeOCIManifest,
+			`{"schemaVersion":2,"config":{"digest":"sha256:c4"}}`},
+		"/v2/test/manifests/sha256:arm64": {MediaTypeOCIManifest,
+			`{"schemaVersion":2,"config":{"digest":"sha256:c5"}}`},
+		"/v2/test/blobs/sha256:c2": {"application/octet-stream", `{"created":"2020-02-01T00:00:00Z"}`},
+		"/v2/test/blobs/sha256:c3": {"application/octet-stream", `{"created":"2020-03-01T00:00:00Z"}`},
+		"/v2/test/blobs/sha256:c4": {"application/octet-stream", `{"created":"2020-04-01T00:00:00Z"}`},
+		"/v2/test/blobs/sha256:c5": {"application/octet-stream", `{"created":"2020-05-01T00:00:00Z"}`},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		resp, found := responses[r.URL.Path]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", resp.contentType)
+		_, _ = w.Write([]byte(resp.body))
+	}))
+	defer server.Close()
+	registry, err := registry2.New(server.URL, "", "")
+	require.NoError(t, err)
+	registry.Logf = registry2.Quiet
+	scan := &tagScan{
+		slots:   make(chan struct{}, 3),
+		clients: map[string]RegistryClient{"registry.local": &v2Client{registry: registry}},
+		now:     time.Now(),
+	}
+
+	tags, err := scan.repo("registry.local/test")
+	require.NoError(t, err)
+	assert.Equal(t, []TimestampedTag{
+		{Tag: "schema1", Timestamp: 1577836800},
+		{Tag: "schema2", Timestamp: 1580515200},
+		{Tag: "oci", Timestamp: 1583020800},
+		{Tag: "list", Timestamp: 1585699200},
+		{Tag: "index", Timestamp: 1585699200},
+	}, tags)
+
+	require.NoError(t, UsePlatform("linux/arm64"))
+	tags, err = scan.repo("registry.local/test")
+	require.NoError(t, err)
+	assert.Equal(t, []TimestampedTag{
+		{Tag: "schema1", Timestamp: 1577836800},
+		{Tag: "schema2", Timestamp: 1580515200},
+		{Tag: "oci", Timestamp: 1583020800},
+		{Tag: "list", Timestamp: 1588291200},
+		{Tag: "untyped-index", Timestamp: 1588291200},
+	}, tags)
+}
+
+func TestV2ClientDigest(t *testing.T) {
+	manifest := `{"schemaVersion":2,"config":{"digest":"sha256:c1"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/app/manifests/with-header":
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		case "/v2/app/manifests/without-header":
+			w.Header().Set("Content-Type", MediaTypeOCIManifest)
+			_, _ = w.Write([]byte(manifest))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	registry, err := registry2.New(server.URL, "", "")
+	require.NoError(t, err)
+	client := &v2Client{registry: registry}
+	repo := &DockerImageRef{Registry: "registry.local", Image: "app"}
+
+	digest, err := client.Digest(repo, "with-header")
+	require.NoError(t, err)
+	assert.Equal(t, testDigest, digest)
+	digest, err = client.Digest(repo, "without-header")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:"+fmt.Sprintf("%x", sha256.Sum256([]byte(manifest))), digest)
+	_, err = client.Digest(repo, "missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+func init() {
+	RegisterRegistryClient("quay", newQuayClient, "quay.io")
+}
+
+// quayClient lists tags with the Quay API, which has tag timestamps, so
+// manifests need not be fetched. The password of the registry, if any, is
+// used as an OAuth access token.
+type quayClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func newQuayClient(host string) (RegistryClient, error) {
+	credentials, err := GetRegistryCredentials(host)
+	if err != nil {
+		return nil, fmt.Errorf(`could not get credentials for Quay registry %q: %v`, host, err)
+	}
+	quay := &quayClient{baseURL: "https://" + host, token: credentials.Password, client: http.DefaultClient}
+	return newListingClient(quay.listTags), nil
+}
+
+func (c *quayClient) listTags(repo *DockerImageRef) ([]TimestampedTag, error) {
+	tags := make([]TimestampedTag, 0)
+	for page := 1; ; page++ {
+		var result struct {
+			Tags []struct {
+				Name           string `json:"name"`
+				StartTS        int64  `json:"start_ts"`
+				ManifestDigest string `json:"manifest_digest"`
+			} `json:"tags"`
+			HasAdditional bool `json:"has_additional"`
+		}
+		if err := c.get(fmt.Sprintf("/api/v1/repository/%s/tag/?onlyActiveTags=true&limit=100&page=%d", repo.Image, page), &result); err != nil {
+			return nil, fmt.Errorf(`could not list tags for %s: %v`, repo.WithoutTag(), err)
+		}
+		for _, tag := range result.Tags {
+			tags = append(tags, TimestampedTag{Tag: tag.Name, Timestamp: tag.StartTS, Digest: tag.ManifestDigest})
+		}
+		if !result.HasAdditional {
+			return tags, nil
+		}
+	}
+}
+
+func (c *quayClient) get(path string, result interface{}) error {
+	u := c.baseURL + path
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf(`GET %s: %s`, u, resp.Status)
+	}
+	return json.Unmarshal(body, result)
+}
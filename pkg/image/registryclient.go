@@ -0,0 +1,248 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	registry2 "github.com/heroku/docker-registry-client/registry"
+)
+
+// RegistryClient lists the tags of images in a registry.
+type RegistryClient interface {
+	// Tags lists the tags of an image repo.
+	Tags(repo *DockerImageRef) ([]string, error)
+	// Timestamp returns when the image of a tag was created, or pushed if
+	// the registry does not tell. The boolean result is false if the tag
+	// has no image for the configured platform.
+	Timestamp(repo *DockerImageRef, tag string) (int64, bool, error)
+	// Digest returns the current digest of a tag's manifest. For multi-arch
+	// images, this is the digest of the manifest list or index.
+	Digest(repo *DockerImageRef, tag string) (string, error)
+}
+
+// NewRegistryClientFunc makes a client for a registry host.
+type NewRegistryClientFunc func(host string) (RegistryClient, error)
+
+// RegistryTypeV2 is the registry type used for hosts that match no other type.
+const RegistryTypeV2 = "v2"
+
+type hostPattern struct {
+	pattern      string
+	registryType string
+}
+
+var (
+	registryClients = make(map[string]NewRegistryClientFunc)
+	// defaultHostPatterns select registry types for hosts not configured with a type
+	defaultHostPatterns []hostPattern
+)
+
+// RegisterRegistryClient adds a registry type. The type is used by default
+// for hosts matching one of hostPatterns, while registries in the KubeCD
+// config may select any type for their hosts.
+func RegisterRegistryClient(registryType string, newClient NewRegistryClientFunc, hostPatterns ...string) {
+	if _, found := registryClients[registryType]; found {
+		panic(fmt.Sprintf(`registry type %q registered twice`, registryType))
+	}
+	registryClients[registryType] = newClient
+	for _, pattern := range hostPatterns {
+		defaultHostPatterns = append(defaultHostPatterns, hostPattern{pattern: pattern, registryType: registryType})
+	}
+}
+
+// RegistryTypes returns the names of all registered registry types.
+func RegistryTypes() []string {
+	types := make([]string, 0, len(registryClients))
+	for registryType := range registryClients {
+		types = append(types, registryType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// matchHost matches a host name against a registry host pattern, see path.Match.
+func matchHost(pattern, host string) bool {
+	matched, err := path.Match(pattern, host)
+	return err == nil && matched
+}
+
+// RegistryType returns the type of registry for a host: the first registry
+// with a type in the KubeCD config matching the host, the first default
+// pattern matching it, or RegistryTypeV2.
+func RegistryType(host string) string {
+	for _, registry := range registries {
+		if registry.Type != "" && matchHost(registry.Host, host) {
+			return registry.Type
+		}
+	}
+	for _, p := range defaultHostPatterns {
+		if matchHost(p.pattern, host) {
+			return p.registryType
+		}
+	}
+	return RegistryTypeV2
+}
+
+// NewRegistryClient makes a client of the right type for a registry host.
+func NewRegistryClient(host string) (RegistryClient, error) {
+	registryType := RegistryType(host)
+	newClient, found := registryClients[registryType]
+	if !found {
+		return nil, fmt.Errorf(`unknown registry type %q for %q`, registryType, host)
+	}
+	return newClient(host)
+}
+
+func init() {
+	RegisterRegistryClient(RegistryTypeV2, newV2Client)
+	RegisterRegistryClient("gcr", newGCRClient, GCRRegistrySuffix, "*."+GCRRegistrySuffix)
+}
+
+// v2Client uses the Docker registry HTTP API V2
+type v2Client struct {
+	registry *registry2.Registry
+}
+
+func newV2Client(host string) (RegistryClient, error) {
+	credentials, err := GetRegistryCredentials(host)
+	if err != nil {
+		return nil, fmt.Errorf(`could not get credentials for Docker registry %q: %v`, host, err)
+	}
+	return newV2ClientWithCredentials(host, credentials)
+}
+
+func newV2ClientWithCredentials(host string, credentials Credentials) (RegistryClient, error) {
+	registry, err := registry2.New(registryURL(host), credentials.Username, credentials.Password)
+	if err != nil {
+		return nil, fmt.Errorf(`could not access Docker registry %q: %v`, host, err)
+	}
+	registry.Logf = registry2.Quiet
+	return &v2Client{registry: registry}, nil
+}
+
+func (c *v2Client) Tags(repo *DockerImageRef) ([]string, error) {
+	tags, err := c.registry.Tags(repo.Image)
+	if err != nil {
+		return nil, fmt.Errorf(`could not list tags for %s: %v`, repo.WithoutTag(), err)
+	}
+	return tags, nil
+}
+
+func (c *v2Client) Timestamp(repo *DockerImageRef, tag string) (int64, bool, error) {
+	timestamp, found, err := getTagTimestamp(c.registry, repo.Image, tag)
+	if err != nil {
+		return 0, false, fmt.Errorf(`could not get timestamp for %s:%s: %v`, repo.WithoutTag(), tag, err)
+	}
+	return timestamp, found, nil
+}
+
+func (c *v2Client) Digest(repo *DockerImageRef, tag string) (string, error) {
+	digest, err := getManifestDigest(c.registry, repo.Image, tag)
+	if err != nil {
+		return "", fmt.Errorf(`could not get digest of %s:%s: %v`, repo.WithoutTag(), tag, err)
+	}
+	return digest, nil
+}
+
+// GetTagDigest returns the current digest of an image's tag.
+func GetTagDigest(repo *DockerImageRef) (string, error) {
+	client, err := NewRegistryClient(repo.Host())
+	if err != nil {
+		return "", err
+	}
+	return client.Digest(repo, repo.Tag)
+}
+
+// listingClient is a RegistryClient for registries that list tags with their
+// timestamps, so each repo is listed only once.
+type listingClient struct {
+	list     func(repo *DockerImageRef) ([]TimestampedTag, error)
+	mutex    sync.Mutex
+	listings map[string]*listing
+}
+
+type listing struct {
+	once sync.Once
+	tags []TimestampedTag
+	err  error
+}
+
+func newListingClient(list func(repo *DockerImageRef) ([]TimestampedTag, error)) *listingClient {
+	return &listingClient{list: list, listings: make(map[string]*listing)}
+}
+
+func (c *listingClient) listTags(repo *DockerImageRef) ([]TimestampedTag, error) {
+	c.mutex.Lock()
+	l, found := c.listings[repo.WithoutTag()]
+	if !found {
+		l = &listing{}
+		c.listings[repo.WithoutTag()] = l
+	}
+	c.mutex.Unlock()
+	l.once.Do(func() {
+		l.tags, l.err = c.list(repo)
+	})
+	return l.tags, l.err
+}
+
+func (c *listingClient) Tags(repo *DockerImageRef) ([]string, error) {
+	tsTags, err := c.listTags(repo)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(tsTags))
+	for _, tsTag := range tsTags {
+		tags = append(tags, tsTag.Tag)
+	}
+	return tags, nil
+}
+
+func (c *listingClient) Timestamp(repo *DockerImageRef, tag string) (int64, bool, error) {
+	tsTags, err := c.listTags(repo)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, tsTag := range tsTags {
+		if tsTag.Tag == tag {
+			return tsTag.Timestamp, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func (c *listingClient) Digest(repo *DockerImageRef, tag string) (string, error) {
+	tsTags, err := c.listTags(repo)
+	if err != nil {
+		return "", err
+	}
+	for _, tsTag := range tsTags {
+		if tsTag.Tag == tag && tsTag.Digest != "" {
+			return tsTag.Digest, nil
+		}
+	}
+	return "", fmt.Errorf(`no digest found for %s:%s`, repo.WithoutTag(), tag)
+}
+
+func newGCRClient(string) (RegistryClient, error) {
+	return newListingClient(GetTagsForGcrImage), nil
+}
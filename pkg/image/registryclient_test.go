@@ -0,0 +1,156 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/exec"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+func TestRegistryType(t *testing.T) {
+	defer func() { _ = UseRegistries(nil) }()
+	assert.Equal(t, "gcr", RegistryType("eu.gcr.io"))
+	assert.Equal(t, "gcr", RegistryType("gcr.io"))
+	assert.Equal(t, "artifactregistry", RegistryType("europe-west1-docker.pkg.dev"))
+	assert.Equal(t, "ghcr", RegistryType("ghcr.io"))
+	assert.Equal(t, "quay", RegistryType("quay.io"))
+	assert.Equal(t, "ecr", RegistryType("123456789012.dkr.ecr.eu-west-1.amazonaws.com"))
+	assert.Equal(t, "ecr", RegistryType("123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn"))
+	assert.Equal(t, "acr", RegistryType("myregistry.azurecr.io"))
+	assert.Equal(t, RegistryTypeV2, RegistryType("quay.example.com"))
+	assert.Equal(t, RegistryTypeV2, RegistryType("docker.io"))
+
+	require.NoError(t, UseRegistries([]model.Registry{
+		{Host: "quay.*.example.com", Type: "quay"},
+		{Host: "mirror.gcr.io", Type: "v2"},
+	}))
+	assert.Equal(t, "quay", RegistryType("quay.eu.example.com"))
+	assert.Equal(t, "quay", RegistryType("quay.io"))
+	assert.Equal(t, "v2", RegistryType("mirror.gcr.io"))
+	assert.Equal(t, "gcr", RegistryType("eu.gcr.io"))
+
+	assert.Error(t, UseRegistries([]model.Registry{{Host: "example.com", Type: "nope"}}))
+	assert.Error(t, UseRegistries([]model.Registry{{Host: "[", Type: "v2"}}))
+}
+
+func TestECRClient(t *testing.T) {
+	oldRunner := runner
+	defer func() { runner = oldRunner }()
+	_, err := newECRClient("registry.example.com")
+	assert.Error(t, err)
+	client, err := newECRClient("123456789012.dkr.ecr.eu-west-1.amazonaws.com")
+	require.NoError(t, err)
+	repo := &DockerImageRef{Registry: "123456789012.dkr.ecr.eu-west-1.amazonaws.com", Image: "team/app"}
+	runner = exec.TestRunner{
+		ExpectedCommand: []string{"aws", "ecr", "describe-images", "--registry-id", "123456789012",
+			"--region", "eu-west-1", "--repository-name", "team/app", "--filter", "tagStatus=TAGGED", "--output", "json"},
+		Output: []byte(`{"imageDetails": [
+			{"imageTags": ["1.0", "stable"], "imagePushedAt": 1577836800.123},
+			{"imageTags": ["1.1"], "imagePushedAt": "2020-02-01T00:00:00+00:00"}
+		]}`),
+	}
+	tags, err := client.Tags(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "stable", "1.1"}, tags)
+	timestamp, found, err := client.Timestamp(repo, "1.1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(1580515200), timestamp)
+	timestamp, _, _ = client.Timestamp(repo, "stable")
+	assert.Equal(t, int64(1577836800), timestamp)
+}
+
+func TestACRClient(t *testing.T) {
+	oldRunner := runner
+	defer func() { runner = oldRunner }()
+	client, err := newACRClient("myregistry.azurecr.io")
+	require.NoError(t, err)
+	repo := &DockerImageRef{Registry: "myregistry.azurecr.io", Image: "app"}
+	runner = exec.TestRunner{
+		ExpectedCommand: []string{"az", "acr", "repository", "show-tags",
+			"--name", "myregistry", "--repository", "app", "--detail", "--output", "json"},
+		Output: []byte(`[{"name": "1.0", "createdTime": "2020-01-01T00:00:00.0000000Z"}]`),
+	}
+	timestamp, found, err := client.Timestamp(repo, "1.0")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(1577836800), timestamp)
+	_, found, err = client.Timestamp(repo, "2.0")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestArtifactRegistryClient(t *testing.T) {
+	oldRunner := runner
+	defer func() { runner = oldRunner }()
+	client, err := newArtifactRegistryClient("europe-docker.pkg.dev")
+	require.NoError(t, err)
+	repo := &DockerImageRef{Registry: "europe-docker.pkg.dev", Image: "project/repo/app"}
+	runner = exec.TestRunner{
+		ExpectedCommand: []string{"gcloud", "artifacts", "docker", "images", "list",
+			"europe-docker.pkg.dev/project/repo/app", "--include-tags", "--format", "json"},
+		Output: []byte(`[
+			{"package": "europe-docker.pkg.dev/project/repo/app", "tags": "1.0,stable", "createTime": "2020-01-01T00:00:00.123Z"},
+			{"package": "europe-docker.pkg.dev/project/repo/app", "tags": ["1.1"], "createTime": "2020-02-01T00:00:00Z"},
+			{"package": "europe-docker.pkg.dev/project/repo/app", "tags": "", "createTime": "2020-03-01T00:00:00Z"},
+			{"package": "europe-docker.pkg.dev/project/repo/app/sub", "tags": "2.0", "createTime": "2020-04-01T00:00:00Z"}
+		]`),
+	}
+	tags, err := client.Tags(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "stable", "1.1"}, tags)
+	timestamp, _, err := client.Timestamp(repo, "1.1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1580515200), timestamp)
+}
+
+func TestQuayClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/repository/org/app/tag/" || r.Header.Get("Authorization") != "Bearer token" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("page") == "1" {
+			_, _ = w.Write([]byte(`{"tags": [{"name": "1.1", "start_ts": 1580515200}], "has_additional": true}`))
+		} else {
+			_, _ = w.Write([]byte(`{"tags": [{"name": "1.0", "start_ts": 1577836800}], "has_additional": false}`))
+		}
+	}))
+	defer server.Close()
+	quay := &quayClient{baseURL: server.URL, token: "token", client: server.Client()}
+	client := newListingClient(quay.listTags)
+	repo := &DockerImageRef{Registry: "quay.io", Image: "org/app"}
+	tags, err := client.Tags(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1", "1.0"}, tags)
+	timestamp, _, err := client.Timestamp(repo, "1.0")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1577836800), timestamp)
+
+	quay.token = ""
+	_, err = newListingClient(quay.listTags).Tags(repo)
+	assert.Error(t, err)
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScanWorkers is the maximum number of concurrent registry requests made by GetTagsForDockerImages.
+var ScanWorkers = 8
+
+// tagCache used by GetTagsForDockerImages, see UseTagCache
+var tagCache *TagCache
+
+// UseTagCache sets the cache used by GetTagsForDockerImages. Nil disables caching.
+func UseTagCache(cache *TagCache) {
+	tagCache = cache
+}
+
+// GetTagsForDockerImages gets the tags of several image repos, using up to
+// ScanWorkers concurrent requests across all repos and tags, and the tag
+// cache set with UseTagCache.
+func GetTagsForDockerImages(repos []string) (map[string][]TimestampedTag, error) {
+	workers := ScanWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	scan := &tagScan{
+		slots:     make(chan struct{}, workers),
+		cache:     tagCache,
+		clients:   make(map[string]RegistryClient),
+		newClient: NewRegistryClient,
+		now:       time.Now(),
+	}
+	results := make(map[string][]TimestampedTag)
+	errs := make(map[string]error)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			tags, err := scan.repo(repo)
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[repo], errs[repo] = tags, err
+		}(repo)
+	}
+	wg.Wait()
+	sorted := append([]string{}, repos...)
+	sort.Strings(sorted)
+	for _, repo := range sorted {
+		if errs[repo] != nil {
+			return nil, fmt.Errorf(`while scanning tags for %s: %v`, repo, errs[repo])
+		}
+	}
+	return results, nil
+}
+
+type tagScan struct {
+	// slots limits the number of concurrent requests
+	slots chan struct{}
+	cache *TagCache
+	// clients by registry host, made with newClient when first needed
+	clients   map[string]RegistryClient
+	newClient NewRegistryClientFunc
+	mutex     sync.Mutex
+	now       time.Time
+}
+
+func (s *tagScan) client(host string) (RegistryClient, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if client, found := s.clients[host]; found {
+		return client, nil
+	}
+	client, err := s.newClient(host)
+	if err != nil {
+		return nil, err
+	}
+	s.clients[host] = client
+	return client, nil
+}
+
+func (s *tagScan) repo(repo string) ([]TimestampedTag, error) {
+	var cached *cachedTags
+	var err error
+	if s.cache != nil {
+		if cached, err = s.cache.load(repo); err != nil {
+			return nil, err
+		}
+	}
+	if cached == nil {
+		if s.cache != nil && s.cache.Offline {
+			return nil, fmt.Errorf(`not found in the tag cache, which is needed when offline`)
+		}
+		cached = &cachedTags{Repo: repo}
+	}
+	if cached.Timestamps == nil {
+		cached.Timestamps = make(map[string]map[string]int64)
+	}
+	timestamps := cached.Timestamps[platform.String()]
+	if timestamps == nil {
+		timestamps = make(map[string]int64)
+		cached.Timestamps[platform.String()] = timestamps
+	}
+
+	imageRef := parseImageRepo(repo)
+	if cached.Listed == 0 || s.cache == nil || s.cache.expired(cached, s.now) {
+		s.slots <- struct{}{}
+		tags, err := func() ([]string, error) {
+			defer func() { <-s.slots }()
+			client, err := s.client(imageRef.Host())
+			if err != nil {
+				return nil, err
+			}
+			return client.Tags(imageRef)
+		}()
+		if err != nil {
+			return nil, err
+		}
+		cached.Tags, cached.Listed = tags, s.now.Unix()
+	}
+
+	var missing []string
+	for _, tag := range cached.Tags {
+		if _, found := timestamps[tag]; !found {
+			missing = append(missing, tag)
+		}
+	}
+	if len(missing) > 0 && (s.cache == nil || !s.cache.Offline) {
+		var client RegistryClient
+		if client, err = s.client(imageRef.Host()); err != nil {
+			return nil, err
+		}
+		err = s.fetchTimestamps(client, imageRef, missing, timestamps)
+	}
+	if s.cache != nil && !s.cache.Offline {
+		// timestamps fetched before an error are saved too
+		if saveErr := s.cache.save(cached); err == nil {
+			err = saveErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := make([]TimestampedTag, 0, len(cached.Tags))
+	for _, tag := range cached.Tags {
+		if timestamp, found := timestamps[tag]; found && timestamp != noTimestamp {
+			result = append(result, TimestampedTag{Tag: tag, Timestamp: timestamp})
+		}
+	}
+	return result, nil
+}
+
+// fetchTimestamps gets the timestamps of tags concurrently, adding them to timestamps
+func (s *tagScan) fetchTimestamps(client RegistryClient, repo *DockerImageRef, tags []string, timestamps map[string]int64) error {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	for _, tag := range tags {
+		wg.Add(1)
+		s.slots <- struct{}{}
+		go func(tag string) {
+			defer wg.Done()
+			defer func() { <-s.slots }()
+			timestamp, found, err := client.Timestamp(repo, tag)
+			if !found {
+				timestamp = noTimestamp
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			timestamps[tag] = timestamp
+		}(tag)
+	}
+	wg.Wait()
+	return firstErr
+}
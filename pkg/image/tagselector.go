@@ -0,0 +1,246 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mmsemver "github.com/Masterminds/semver/v3"
+
+	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/semver"
+)
+
+// TagSelector chooses new tags for an image trigger, among the tags matching
+// its tagPattern, compared according to its sortBy. Its Policy selects the
+// semantic versions that are upgrades, and which prereleases are allowed with
+// any sortBy.
+type TagSelector struct {
+	Policy semver.Policy
+	SortBy string
+	// MinAge is how old tags must be to be chosen, by their timestamp
+	MinAge time.Duration
+	// Now is the time tag ages are relative to, which is the current time if zero
+	Now time.Time
+
+	pattern      *regexp.Regexp
+	versionGroup int
+}
+
+// NewTagSelector returns a TagSelector for an image trigger.
+func NewTagSelector(trigger *model.ImageTrigger) (*TagSelector, error) {
+	selector := &TagSelector{Policy: triggerPolicy(trigger), SortBy: trigger.SortByString()}
+	pattern, err := regexp.Compile(`^(?:` + trigger.TagPattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid "tagPattern": %v`, err)
+	}
+	if selector.versionGroup, err = trigger.VersionGroup(pattern); err != nil {
+		return nil, err
+	}
+	if trigger.TagPattern != "" {
+		selector.pattern = pattern
+	}
+	return selector, nil
+}
+
+// Matches tells whether a tag matches the tagPattern.
+func (s *TagSelector) Matches(tag string) bool {
+	return s.pattern == nil || s.pattern.MatchString(tag)
+}
+
+// Version returns the part of a tag that is compared, which is the
+// versionFrom group of the tagPattern, or the whole tag.
+func (s *TagSelector) Version(tag string) string {
+	if s.pattern == nil {
+		return tag
+	}
+	match := s.pattern.FindStringSubmatch(tag)
+	if match == nil {
+		return ""
+	}
+	return match[s.versionGroup]
+}
+
+// IsExcluded tells whether a tag, or its version, is in the exclude list.
+func (s *TagSelector) IsExcluded(tag string) bool {
+	return semver.IsExcluded(tag, s.Policy.Exclude) || semver.IsExcluded(s.Version(tag), s.Policy.Exclude)
+}
+
+// Semantic returns the version of a tag as a semantic version, or nil.
+func (s *TagSelector) Semantic(tag TimestampedTag) *mmsemver.Version {
+	if s.pattern == nil {
+		return tag.Semantic()
+	}
+	version, _ := semver.Parse(s.Version(tag.Tag))
+	return version
+}
+
+// Sortable tells whether a tag can be compared with others by the sortBy order.
+func (s *TagSelector) Sortable(tag TimestampedTag) bool {
+	switch s.SortBy {
+	case model.SortBySemver:
+		return s.Semantic(tag) != nil
+	case model.SortByNumeric:
+		_, err := strconv.ParseUint(s.Version(tag.Tag), 10, 64)
+		return err == nil
+	case model.SortByCalver:
+		return parseCalver(s.Version(tag.Tag)) != nil
+	}
+	return true
+}
+
+// IsUpgrade tells whether a candidate tag may replace the current one.
+func (s *TagSelector) IsUpgrade(current, candidate TimestampedTag) bool {
+	if candidate.Tag == current.Tag || !s.Matches(candidate.Tag) || s.IsExcluded(candidate.Tag) ||
+		!s.AllowsPrerelease(current, candidate) || !s.OldEnough(candidate) {
+		return false
+	}
+	switch s.SortBy {
+	case model.SortByTimestamp:
+		return candidate.Tag != "latest" && candidate.Timestamp > current.Timestamp
+	case model.SortBySemver:
+		currentVersion, candidateVersion := s.Semantic(current), s.Semantic(candidate)
+		if currentVersion == nil || candidateVersion == nil {
+			return false
+		}
+		policy := s.Policy
+		if policy.Track == semver.TrackNewest {
+			policy.Track = semver.TrackMajorVersion
+		}
+		return policy.IsWantedUpgrade(currentVersion, candidateVersion)
+	}
+	cmp, ok := s.compare(candidate, current)
+	return ok && cmp > 0
+}
+
+// AllowsPrerelease tells whether the prerelease policy allows a candidate
+// tag. Tags that are not semantic versions are not prereleases.
+func (s *TagSelector) AllowsPrerelease(current, candidate TimestampedTag) bool {
+	candidateVersion := s.Semantic(candidate)
+	return candidateVersion == nil || s.Policy.AllowsPrerelease(s.Semantic(current), candidateVersion)
+}
+
+// OldEnough tells whether a tag is at least MinAge old. Tags without a
+// timestamp are never old enough with a MinAge.
+func (s *TagSelector) OldEnough(tag TimestampedTag) bool {
+	if s.MinAge <= 0 {
+		return true
+	}
+	now := s.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return tag.Timestamp > 0 && now.Sub(time.Unix(tag.Timestamp, 0)) >= s.MinAge
+}
+
+// Newest returns the newest of the candidate tags that may replace the
+// current one, or the current tag if there are none.
+func (s *TagSelector) Newest(current TimestampedTag, candidates []TimestampedTag) TimestampedTag {
+	found := current
+	for _, candidate := range candidates {
+		if !s.IsUpgrade(current, candidate) {
+			continue
+		}
+		if found.Tag == current.Tag {
+			found = candidate
+		} else if cmp, _ := s.compare(candidate, found); cmp > 0 {
+			found = candidate
+		}
+	}
+	return found
+}
+
+// compare compares two tags by the sortBy order, returning false if either
+// tag is not sortable.
+func (s *TagSelector) compare(a, b TimestampedTag) (int, bool) {
+	switch s.SortBy {
+	case model.SortByTimestamp:
+		return compareInt64(a.Timestamp, b.Timestamp), true
+	case model.SortBySemver:
+		aVersion, bVersion := s.Semantic(a), s.Semantic(b)
+		if aVersion == nil || bVersion == nil {
+			return 0, false
+		}
+		return aVersion.Compare(bVersion), true
+	case model.SortByNumeric:
+		aNumber, aErr := strconv.ParseUint(s.Version(a.Tag), 10, 64)
+		bNumber, bErr := strconv.ParseUint(s.Version(b.Tag), 10, 64)
+		if aErr != nil || bErr != nil {
+			return 0, false
+		}
+		return compareInt64(int64(aNumber), int64(bNumber)), true
+	case model.SortByCalver:
+		aFields, bFields := parseCalver(s.Version(a.Tag)), parseCalver(s.Version(b.Tag))
+		if aFields == nil || bFields == nil {
+			return 0, false
+		}
+		for i := 0; i < len(aFields) && i < len(bFields); i++ {
+			if cmp := compareInt64(aFields[i], bFields[i]); cmp != 0 {
+				return cmp, true
+			}
+		}
+		return compareInt64(int64(len(aFields)), int64(len(bFields))), true
+	}
+	return 0, false
+}
+
+// triggerPolicy returns the semver policy of an image trigger.
+func triggerPolicy(trigger *model.ImageTrigger) semver.Policy {
+	return semver.Policy{
+		Track:      trigger.Track,
+		Exclude:    trigger.Exclude,
+		Prerelease: trigger.Prerelease,
+		Channels:   trigger.Channels,
+	}
+}
+
+// parseCalver splits a calendar version like "2024.05.17" into its numbers,
+// returning nil if it is not one.
+func parseCalver(version string) []int64 {
+	if version == "" {
+		return nil
+	}
+	fields := strings.FieldsFunc(version, func(r rune) bool { return r == '.' || r == '-' || r == '_' })
+	if len(fields) == 0 {
+		return nil
+	}
+	result := make([]int64, len(fields))
+	for i, field := range fields {
+		number, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || number < 0 {
+			return nil
+		}
+		result[i] = number
+	}
+	return result
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
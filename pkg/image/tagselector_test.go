@@ -0,0 +1,126 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package image
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+func TestTagSelector(t *testing.T) {
+	for i, tc := range []struct {
+		trigger    model.ImageTrigger
+		current    string
+		candidates []string
+		expected   string
+	}{
+		{
+			model.ImageTrigger{Track: "Newest", TagPattern: `build-\d+`, SortBy: model.SortByNumeric},
+			"build-99", []string{"build-100", "build-98", "build-1000-rc", "20240517", "latest"}, "build-99",
+		},
+		{
+			model.ImageTrigger{Track: "Newest", TagPattern: `build-(\d+)`, VersionFrom: "1", SortBy: model.SortByNumeric},
+			"build-99", []string{"build-100", "build-98", "build-1000-rc", "20240517", "latest"}, "build-100",
+		},
+		{
+			model.ImageTrigger{Track: "Newest", TagPattern: `(\d{4}\.\d{2}\.\d{2})-[0-9a-f]+`, VersionFrom: "1", SortBy: model.SortByCalver},
+			"2024.05.17-abc123", []string{"2024.05.18-def456", "2024.06.01-0123ab", "2024.10.01", "2023.12.31-ffffff"}, "2024.06.01-0123ab",
+		},
+		{
+			model.ImageTrigger{Track: "MinorVersion", TagPattern: `v(?P<version>\d+\.\d+\.\d+)-alpine`, VersionFrom: "version"},
+			"v1.2.3-alpine", []string{"v1.2.4-alpine", "v1.3.0-alpine", "v1.4.0", "v2.0.0-alpine", "v1.5.0-slim"}, "v1.3.0-alpine",
+		},
+		{
+			model.ImageTrigger{Track: "MinorVersion", TagPattern: `v(\d+\.\d+\.\d+)-alpine`, VersionFrom: "1", Exclude: []string{"1.3.0"}},
+			"v1.2.3-alpine", []string{"v1.2.4-alpine", "v1.3.0-alpine"}, "v1.2.4-alpine",
+		},
+		{
+			model.ImageTrigger{Track: "Newest", TagPattern: `main-.*`},
+			"main-a", []string{"main-b", "main-c", "feature-d"}, "main-c",
+		},
+		{
+			model.ImageTrigger{Track: "MinorVersion"},
+			"1.2.3", []string{"1.2.4-alpine", "1.2.4"}, "1.2.4",
+		},
+		{
+			model.ImageTrigger{Track: "Newest"},
+			"1.2.3", []string{"1.2.4", "feature-x", "1.3.0-rc.1"}, "feature-x",
+		},
+		{
+			model.ImageTrigger{Track: "Newest", Prerelease: "any"},
+			"1.2.3", []string{"1.2.4", "feature-x", "1.3.0-rc.1"}, "1.3.0-rc.1",
+		},
+		{
+			model.ImageTrigger{Track: "MinorVersion", Channels: []string{"rc"}},
+			"1.2.3", []string{"1.2.4", "1.3.0-beta.1", "1.3.0-rc.1"}, "1.3.0-rc.1",
+		},
+		{
+			model.ImageTrigger{Track: "MinorVersion", Prerelease: "same-series", TagPattern: `v(.*)-alpine`, VersionFrom: "1"},
+			"v1.2.3-alpine", []string{"v1.2.4-rc.1-alpine", "v1.3.0-rc.1-alpine"}, "v1.2.4-rc.1-alpine",
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			selector, err := NewTagSelector(&tc.trigger)
+			require.NoError(t, err)
+			current := TimestampedTag{Tag: tc.current}
+			candidates := make([]TimestampedTag, len(tc.candidates))
+			for j, tag := range tc.candidates {
+				candidates[j] = TimestampedTag{Tag: tag, Timestamp: int64(j + 1)}
+			}
+			assert.Equal(t, tc.expected, selector.Newest(current, candidates).Tag)
+		})
+	}
+}
+
+func TestTagSelectorMinAge(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	selector, err := NewTagSelector(&model.ImageTrigger{Track: "Newest"})
+	require.NoError(t, err)
+	selector.MinAge, selector.Now = 24*time.Hour, now
+	current := TimestampedTag{Tag: "a", Timestamp: now.Add(-72 * time.Hour).Unix()}
+	candidates := []TimestampedTag{
+		{Tag: "b", Timestamp: now.Add(-48 * time.Hour).Unix()},
+		{Tag: "c", Timestamp: now.Add(-24 * time.Hour).Unix()},
+		{Tag: "d", Timestamp: now.Add(-time.Hour).Unix()},
+		{Tag: "e", Timestamp: noTimestamp},
+	}
+	assert.Equal(t, "c", selector.Newest(current, candidates).Tag)
+	selector.MinAge = 0
+	assert.Equal(t, "d", selector.Newest(current, candidates).Tag)
+}
+
+func TestNewTagSelectorErrors(t *testing.T) {
+	_, err := NewTagSelector(&model.ImageTrigger{TagPattern: `(`})
+	assert.Error(t, err)
+	_, err = NewTagSelector(&model.ImageTrigger{TagPattern: `build-(\d+)`, VersionFrom: "2"})
+	assert.EqualError(t, err, `"versionFrom" group 2 not in "tagPattern" "build-(\\d+)"`)
+}
+
+func TestParseCalver(t *testing.T) {
+	assert.Equal(t, []int64{2024, 5, 17}, parseCalver("2024.05.17"))
+	assert.Equal(t, []int64{24, 1}, parseCalver("24_01"))
+	assert.Nil(t, parseCalver("2024.05.17-abc123"))
+	assert.Nil(t, parseCalver(""))
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package lint checks a KubeCD configuration for common mistakes
+package lint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a single issue reported by a lint rule.
+type Finding struct {
+	RuleID   string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	File     string   `json:"file"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s [%s]", f.File, f.Severity, f.Message, f.RuleID)
+}
+
+// CheckFunc inspects a config and returns its findings. Rule ID and severity
+// are filled in by Run.
+type CheckFunc func(config *model.KubeCDConfig) []Finding
+
+type Rule struct {
+	ID          string
+	Description string
+	Severity    Severity
+	// Enabled is the default setting, which may be overridden by the "lint" section of the config file.
+	Enabled bool
+	Check   CheckFunc
+}
+
+var rules []*Rule
+
+// Register adds a rule to the set of rules used by Run.
+func Register(rule *Rule) {
+	if GetRule(rule.ID) != nil {
+		panic(fmt.Sprintf("lint rule %q registered twice", rule.ID))
+	}
+	rules = append(rules, rule)
+}
+
+// Rules returns all registered rules, in registration order.
+func Rules() []*Rule {
+	return rules
+}
+
+func GetRule(id string) *Rule {
+	for _, rule := range rules {
+		if rule.ID == id {
+			return rule
+		}
+	}
+	return nil
+}
+
+// RuleEnabled returns whether a rule will be run for config.
+func RuleEnabled(rule *Rule, config *model.KubeCDConfig) bool {
+	return config.Lint.RuleEnabled(rule.ID, rule.Enabled)
+}
+
+// Run runs every enabled rule against config.
+func Run(config *model.KubeCDConfig) []Finding {
+	findings := make([]Finding, 0)
+	for _, rule := range rules {
+		if !RuleEnabled(rule, config) {
+			continue
+		}
+		for _, finding := range rule.Check(config) {
+			finding.RuleID = rule.ID
+			finding.Severity = rule.Severity
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// CheckConfig returns an error for every rule ID in the "lint" section of
+// config that does not match a registered rule.
+func CheckConfig(config *model.KubeCDConfig) []error {
+	var issues []error
+	if config.Lint == nil {
+		return issues
+	}
+	ids := make([]string, 0, len(config.Lint.Rules))
+	for id := range config.Lint.Rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if GetRule(id) == nil {
+			issues = append(issues, fmt.Errorf(`lint: unknown rule %q`, id))
+		}
+	}
+	return issues
+}
+
+func CountBySeverity(findings []Finding, severity Severity) int {
+	count := 0
+	for _, finding := range findings {
+		if finding.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package lint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/image"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+const testEnvironments = `
+clusters:
+  - name: used
+    provider:
+      minikube: {}
+  - name: unused
+    provider:
+      minikube: {}
+environments:
+  - name: test
+    clusterName: used
+    kubeNamespace: default
+    releasesFiles:
+      - releases.yaml
+`
+
+const testReleases = `
+releases:
+  - name: good
+    chart:
+      dir: chart
+    values:
+      - key: image.repository
+        value: test-image
+      - key: image.tag
+        value: "1.0"
+    triggers:
+      - image:
+          track: PatchLevel
+  - name: bad
+    chart:
+      dir: no-such-chart
+    valuesFile: no-such-values.yaml
+    values:
+      - key: image.repository
+        value: test-image
+    triggers:
+      - image:
+          track: Sometimes
+`
+
+func loadTestConfig(t *testing.T, dir, extra string) *model.KubeCDConfig {
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "chart"), 0755))
+	envFile := filepath.Join(dir, "environments.yaml")
+	require.NoError(t, ioutil.WriteFile(envFile, []byte(testEnvironments+extra), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "releases.yaml"), []byte(testReleases), 0644))
+	config, err := model.NewConfigFromFile(envFile)
+	require.NoError(t, err)
+	return config
+}
+
+func withTempDir(t *testing.T, f func(dir string)) {
+	dir, err := ioutil.TempDir("", "kcd-lint")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	f(dir)
+}
+
+func findingsByRule(findings []Finding) map[string]int {
+	result := make(map[string]int)
+	for _, finding := range findings {
+		result[finding.RuleID]++
+	}
+	return result
+}
+
+func TestRun(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		findings := Run(loadTestConfig(t, dir, ""))
+		assert.Equal(t, map[string]int{
+			RuleChartDirMissing:   1,
+			RuleValuesFileMissing: 1,
+			RuleTriggerUnresolved: 1,
+			RuleUnknownTrack:      1,
+			RuleUnusedCluster:     1,
+		}, findingsByRule(findings))
+		assert.Equal(t, 4, CountBySeverity(findings, SeverityError))
+		assert.Equal(t, 1, CountBySeverity(findings, SeverityWarning))
+		for _, finding := range findings {
+			if finding.RuleID != RuleUnusedCluster {
+				assert.Contains(t, finding.Message, `release "bad"`)
+				assert.Equal(t, "releases.yaml", filepath.Base(finding.File))
+			}
+		}
+	})
+}
+
+func TestRunWithDisabledRules(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		config := loadTestConfig(t, dir, `
+lint:
+  rules:
+    unused-cluster: false
+    unknown-track: false
+`)
+		assert.Empty(t, CheckConfig(config))
+		byRule := findingsByRule(Run(config))
+		assert.NotContains(t, byRule, RuleUnusedCluster)
+		assert.NotContains(t, byRule, RuleUnknownTrack)
+		assert.Contains(t, byRule, RuleChartDirMissing)
+	})
+}
+
+func TestCheckConfig(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		config := loadTestConfig(t, dir, `
+lint:
+  rules:
+    no-such-rule: true
+`)
+		issues := CheckConfig(config)
+		require.Len(t, issues, 1)
+		assert.Equal(t, `lint: unknown rule "no-such-rule"`, issues[0].Error())
+	})
+}
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestDigestRules(t *testing.T) {
+	oldGetTagDigest := getTagDigest
+	defer func() { getTagDigest = oldGetTagDigest }()
+	getTagDigest = func(imageRef *image.DockerImageRef) (string, error) {
+		if imageRef.Tag == "1.0" {
+			return testDigest, nil
+		}
+		return "sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", nil
+	}
+	withTempDir(t, func(dir string) {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "chart"), 0755))
+		envFile := filepath.Join(dir, "environments.yaml")
+		require.NoError(t, ioutil.WriteFile(envFile, []byte(testEnvironments+`
+lint:
+  rules:
+    stale-digest: true
+`), 0644))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "releases.yaml"), []byte(`
+releases:
+  - name: pinned
+    chart: {dir: chart}
+    values:
+      - {key: image.repository, value: app}
+      - {key: image.tag, value: "1.0"}
+      - {key: image.digest, value: "`+testDigest+`"}
+    triggers:
+      - image: {track: PatchLevel, digestValue: image.digest}
+  - name: stale
+    chart: {dir: chart}
+    values:
+      - {key: image.repository, value: app}
+      - {key: image.tag, value: "1.1@`+testDigest+`"}
+    triggers:
+      - image: {track: PatchLevel}
+  - name: unpinned
+    chart: {dir: chart}
+    values:
+      - {key: image.repository, value: app}
+      - {key: image.tag, value: "1.0"}
+    triggers:
+      - image: {track: PatchLevel, pin: digest}
+`), 0644))
+		config, err := model.NewConfigFromFile(envFile)
+		require.NoError(t, err)
+		findings := Run(config)
+		byRule := findingsByRule(findings)
+		assert.Equal(t, 1, byRule[RuleStaleDigest])
+		assert.Equal(t, 1, byRule[RuleUnpinnedDigest])
+		for _, finding := range findings {
+			switch finding.RuleID {
+			case RuleStaleDigest:
+				assert.Contains(t, finding.Message, `release "stale"`)
+			case RuleUnpinnedDigest:
+				assert.Contains(t, finding.Message, `release "unpinned"`)
+			}
+		}
+	})
+}
+
+func TestTrackRules(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "chart"), 0755))
+		envFile := filepath.Join(dir, "environments.yaml")
+		require.NoError(t, ioutil.WriteFile(envFile, []byte(testEnvironments), 0644))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "releases.yaml"), []byte(`
+releases:
+  - name: constrained
+    chart: {dir: chart}
+    values:
+      - {key: image.repository, value: app}
+      - {key: image.tag, value: "2.3.0"}
+    triggers:
+      - image: {track: ">=2.3 <3", exclude: ["2.4.1", ">=2.5.0 <2.5.3", broken-build]}
+  - name: typo
+    chart: {dir: chart}
+    values:
+      - {key: image.repository, value: app}
+      - {key: image.tag, value: "2.3.0"}
+    triggers:
+      - image: {track: ">=2.3 <", exclude: [">=2.5.0 <"]}
+      - chart: {track: MinorVersion, prerelease: rc}
+`), 0644))
+		config, err := model.NewConfigFromFile(envFile)
+		require.NoError(t, err)
+		var messages []string
+		for _, finding := range Run(config) {
+			if finding.RuleID == RuleUnknownTrack {
+				messages = append(messages, finding.Message)
+			}
+		}
+		require.Len(t, messages, 3)
+		assert.Contains(t, messages[0], `release "typo": image trigger has unknown "track": ">=2.3 <"`)
+		assert.Contains(t, messages[1], `release "typo": image trigger has invalid "exclude" range ">=2.5.0 <"`)
+		assert.Contains(t, messages[2], `release "typo": chart trigger "prerelease" must be one of "none", "same-series" or "any", not "rc"`)
+	})
+}
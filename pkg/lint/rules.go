@@ -0,0 +1,267 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package lint
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/image"
+	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/semver"
+)
+
+const (
+	RuleChartDirMissing   = "chart-dir-missing"
+	RuleValuesFileMissing = "values-file-missing"
+	RuleTriggerUnresolved = "trigger-unresolved"
+	RuleUnknownTrack      = "unknown-track"
+	RuleUnusedCluster     = "unused-cluster"
+	RuleUnpinnedDigest    = "unpinned-digest"
+	RuleStaleDigest       = "stale-digest"
+)
+
+// getTagDigest is replaced in tests
+var getTagDigest = image.GetTagDigest
+
+func init() {
+	Register(&Rule{
+		ID:          RuleChartDirMissing,
+		Description: "chart.dir must point to an existing directory",
+		Severity:    SeverityError,
+		Enabled:     true,
+		Check:       checkChartDirs,
+	})
+	Register(&Rule{
+		ID:          RuleValuesFileMissing,
+		Description: "valuesFile and defaultValuesFile must point to existing files",
+		Severity:    SeverityError,
+		Enabled:     true,
+		Check:       checkValuesFiles,
+	})
+	Register(&Rule{
+		ID:          RuleTriggerUnresolved,
+		Description: "image trigger repoValue and tagValue must resolve to a value",
+		Severity:    SeverityError,
+		Enabled:     true,
+		Check:       checkTriggerValues,
+	})
+	Register(&Rule{
+		ID:          RuleUnknownTrack,
+		Description: `trigger "track" must be a supported value or version constraint, "exclude" valid versions or ranges, and "prerelease" a supported policy`,
+		Severity:    SeverityError,
+		Enabled:     true,
+		Check:       checkTracks,
+	})
+	Register(&Rule{
+		ID:          RuleUnusedCluster,
+		Description: "every cluster should be used by at least one environment",
+		Severity:    SeverityWarning,
+		Enabled:     true,
+		Check:       checkUnusedClusters,
+	})
+	Register(&Rule{
+		ID:          RuleUnpinnedDigest,
+		Description: `image triggers with "pin: digest" must have a digest`,
+		Severity:    SeverityError,
+		Enabled:     true,
+		Check:       checkUnpinnedDigests,
+	})
+	Register(&Rule{
+		ID:          RuleStaleDigest,
+		Description: "pinned image digests must match the digest of their tag in the registry",
+		Severity:    SeverityError,
+		// disabled by default, as it contacts registries
+		Enabled: false,
+		Check:   checkStaleDigests,
+	})
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func releaseFinding(release *model.Release, format string, args ...interface{}) Finding {
+	prefix := fmt.Sprintf("env %q release %q: ", release.Environment.Name, release.Name)
+	return Finding{File: release.FromFile, Message: prefix + fmt.Sprintf(format, args...)}
+}
+
+func checkChartDirs(config *model.KubeCDConfig) []Finding {
+	var findings []Finding
+	for _, release := range config.AllReleases() {
+		if release.Chart == nil || release.Chart.Dir == nil {
+			continue
+		}
+		chartDir := release.AbsPath(*release.Chart.Dir)
+		if info, err := os.Stat(chartDir); err != nil || !info.IsDir() {
+			findings = append(findings, releaseFinding(release, `chart.dir %q does not exist`, chartDir))
+		}
+	}
+	return findings
+}
+
+func checkValuesFiles(config *model.KubeCDConfig) []Finding {
+	var findings []Finding
+	for _, release := range config.AllReleases() {
+		env := release.Environment
+		if !release.SkipDefaultValues && env.DefaultValuesFile != "" {
+			// resolved the same way as in helm.GenerateHelmValuesArgv
+			valuesFile := release.AbsPath(env.DefaultValuesFile)
+			if !pathExists(valuesFile) {
+				findings = append(findings, releaseFinding(release, `defaultValuesFile %q does not exist`, valuesFile))
+			}
+		}
+		if release.ValuesFile != nil {
+			valuesFile := release.AbsPath(*release.ValuesFile)
+			if !pathExists(valuesFile) {
+				findings = append(findings, releaseFinding(release, `valuesFile %q does not exist`, valuesFile))
+			}
+		}
+	}
+	return findings
+}
+
+func checkTriggerValues(config *model.KubeCDConfig) []Finding {
+	var findings []Finding
+	for _, release := range config.AllReleases() {
+		var values map[string]interface{}
+		for _, trigger := range release.Triggers {
+			if trigger.Image == nil {
+				continue
+			}
+			if values == nil {
+				var err error
+				if values, err = helm.GetResolvedValues(release); err != nil {
+					findings = append(findings, releaseFinding(release, `could not resolve values: %v`, err))
+					break
+				}
+			}
+			for _, key := range []string{trigger.Image.RepoValueString(), trigger.Image.TagValueString()} {
+				if !helm.KeyIsInValues(key, values) {
+					findings = append(findings, releaseFinding(release, `image trigger value %q does not resolve to a string`, key))
+				}
+			}
+		}
+	}
+	return findings
+}
+
+func checkTracks(config *model.KubeCDConfig) []Finding {
+	var findings []Finding
+	for _, release := range config.AllReleases() {
+		for _, trigger := range release.Triggers {
+			var kind, track, prerelease string
+			var exclude []string
+			switch {
+			case trigger.Image != nil:
+				kind, track, exclude, prerelease = "image", trigger.Image.Track, trigger.Image.Exclude, trigger.Image.Prerelease
+			case trigger.Chart != nil:
+				kind, track, exclude, prerelease = "chart", trigger.Chart.Track, trigger.Chart.Exclude, trigger.Chart.Prerelease
+			default:
+				continue
+			}
+			if track == "" {
+				findings = append(findings, releaseFinding(release, `%s trigger has no "track"`, kind))
+			} else if err := semver.ValidateTrack(track); err != nil {
+				findings = append(findings, releaseFinding(release, `%s trigger has %v`, kind, err))
+			} else if trigger.Image != nil && track != semver.TrackNewest && trigger.Image.SortByString() != model.SortBySemver {
+				findings = append(findings, releaseFinding(release, `image trigger "track" %q only applies with "sortBy: %s", use %q`,
+					track, model.SortBySemver, semver.TrackNewest))
+			}
+			if prerelease != "" && !semver.IsKnownPrerelease(prerelease) {
+				findings = append(findings, releaseFinding(release, `%s trigger "prerelease" must be one of %q, %q or %q, not %q`,
+					kind, semver.PrereleaseNone, semver.PrereleaseSameSeries, semver.PrereleaseAny, prerelease))
+			}
+			for _, entry := range exclude {
+				if err := semver.ValidateExclude(entry); err != nil {
+					findings = append(findings, releaseFinding(release, `%s trigger has %v`, kind, err))
+				}
+			}
+		}
+	}
+	return findings
+}
+
+func checkUnusedClusters(config *model.KubeCDConfig) []Finding {
+	var findings []Finding
+	for _, cluster := range config.AllClusters() {
+		if len(config.GetEnvironmentsInCluster(cluster.Name)) == 0 {
+			findings = append(findings, Finding{
+				File:    config.FromFile(),
+				Message: fmt.Sprintf(`cluster %q is not used by any environment`, cluster.Name),
+			})
+		}
+	}
+	return findings
+}
+
+// forEachImageRef calls fn with the image of each image trigger. Releases
+// whose values can not be resolved are skipped, as checkTriggerValues reports them.
+func forEachImageRef(config *model.KubeCDConfig, fn func(release *model.Release, trigger *model.ImageTrigger, imageRef *image.DockerImageRef) []Finding) []Finding {
+	var findings []Finding
+	for _, release := range config.AllReleases() {
+		var values map[string]interface{}
+		for _, trigger := range release.Triggers {
+			if trigger.Image == nil {
+				continue
+			}
+			if values == nil {
+				var err error
+				if values, err = helm.GetResolvedValues(release); err != nil {
+					break
+				}
+			}
+			if imageRef := helm.GetImageRefFromImageTrigger(trigger.Image, values); imageRef != nil {
+				findings = append(findings, fn(release, trigger.Image, imageRef)...)
+			}
+		}
+	}
+	return findings
+}
+
+func checkUnpinnedDigests(config *model.KubeCDConfig) []Finding {
+	return forEachImageRef(config, func(release *model.Release, trigger *model.ImageTrigger, imageRef *image.DockerImageRef) []Finding {
+		if !trigger.PinsDigest() || imageRef.Digest != "" {
+			return nil
+		}
+		where := fmt.Sprintf("%q", trigger.TagValueString()+"@digest")
+		if trigger.DigestValue != "" {
+			where = fmt.Sprintf("%q", trigger.DigestValue)
+		}
+		return []Finding{releaseFinding(release, `image %s is not pinned to a digest in %s`, imageRef.WithTag(), where)}
+	})
+}
+
+func checkStaleDigests(config *model.KubeCDConfig) []Finding {
+	return forEachImageRef(config, func(release *model.Release, trigger *model.ImageTrigger, imageRef *image.DockerImageRef) []Finding {
+		if imageRef.Digest == "" || imageRef.Tag == "" {
+			return nil
+		}
+		digest, err := getTagDigest(imageRef)
+		if err != nil {
+			return []Finding{releaseFinding(release, `could not get digest of image %s: %v`, imageRef.WithTag(), err)}
+		}
+		if digest != imageRef.Digest {
+			return []Finding{releaseFinding(release, `image %s is pinned to %s, but the tag now has digest %s`, imageRef.WithTag(), imageRef.Digest, digest)}
+		}
+		return nil
+	})
+}
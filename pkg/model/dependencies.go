@@ -0,0 +1,102 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dependencies returns the releases a release depends on.
+func (k *KubeCDConfig) Dependencies(r *Release) ([]*Release, error) {
+	var dependencies []*Release
+	for _, ref := range r.DependsOn {
+		dependency := k.resolveDependency(r, ref)
+		if dependency == nil {
+			return nil, fmt.Errorf(`release %q: unknown "dependsOn" release %q`, r.ID(), ref)
+		}
+		dependencies = append(dependencies, dependency)
+	}
+	return dependencies, nil
+}
+
+// resolveDependency finds a release by name in the environment of r, or by
+// "env/release".
+func (k *KubeCDConfig) resolveDependency(r *Release, ref string) *Release {
+	env, name := r.Environment, ref
+	if slash := strings.Index(ref, "/"); slash >= 0 {
+		env, name = k.GetEnvironment(ref[:slash]), ref[slash+1:]
+	}
+	if env == nil || name == "" {
+		return nil
+	}
+	return env.GetRelease(name)
+}
+
+// checkDependencies reports unknown dependencies and dependency cycles.
+func (k *KubeCDConfig) checkDependencies() []error {
+	var issues []error
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*Release]int)
+	var path []*Release
+	var visit func(r *Release)
+	visit = func(r *Release) {
+		state[r] = visiting
+		path = append(path, r)
+		dependencies, err := k.Dependencies(r)
+		if err != nil {
+			issues = append(issues, err)
+		}
+		for _, dependency := range dependencies {
+			switch state[dependency] {
+			case unvisited:
+				visit(dependency)
+			case visiting:
+				var cycle []string
+				for i := len(path) - 1; i >= 0; i-- {
+					cycle = append([]string{path[i].ID()}, cycle...)
+					if path[i] == dependency {
+						break
+					}
+				}
+				issues = append(issues, fmt.Errorf(`dependency cycle: %s -> %s`, strings.Join(cycle, " -> "), dependency.ID()))
+			}
+		}
+		path = path[:len(path)-1]
+		state[r] = visited
+	}
+	for _, release := range k.AllReleases() {
+		if state[release] == unvisited {
+			visit(release)
+		}
+	}
+	return issues
+}
+
+// ID identifies a release as "env/release".
+func (r *Release) ID() string {
+	if r.Environment == nil {
+		return r.Name
+	}
+	return r.Environment.Name + "/" + r.Name
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDependencyConfig(dependsOn map[string][]string) *KubeCDConfig {
+	config := &KubeCDConfig{}
+	for _, envName := range []string{"test", "prod"} {
+		env := &Environment{Name: envName}
+		for _, name := range []string{"crds", "operator", "app"} {
+			env.Releases = append(env.Releases, &Release{Name: name, Environment: env, DependsOn: dependsOn[envName+"/"+name]})
+		}
+		config.Environments = append(config.Environments, env)
+	}
+	return config
+}
+
+func TestDependencies(t *testing.T) {
+	config := testDependencyConfig(map[string][]string{
+		"test/operator": {"crds"},
+		"test/app":      {"operator", "prod/crds"},
+	})
+	assert.Empty(t, config.checkDependencies())
+	dependencies, err := config.Dependencies(config.GetEnvironment("test").GetRelease("app"))
+	require.NoError(t, err)
+	require.Len(t, dependencies, 2)
+	assert.Equal(t, config.GetEnvironment("test").GetRelease("operator"), dependencies[0])
+	assert.Equal(t, config.GetEnvironment("prod").GetRelease("crds"), dependencies[1])
+
+	config = testDependencyConfig(map[string][]string{
+		"test/operator": {"crds", "web"},
+		"test/app":      {"staging/crds", "prod/"},
+	})
+	assert.Equal(t, []string{
+		`release "test/operator": unknown "dependsOn" release "web"`,
+		`release "test/app": unknown "dependsOn" release "staging/crds"`,
+	}, errorStrings(config.checkDependencies()))
+}
+
+func TestDependencyCycles(t *testing.T) {
+	config := testDependencyConfig(map[string][]string{
+		"test/crds":     {"app"},
+		"test/operator": {"crds"},
+		"test/app":      {"operator"},
+		"prod/app":      {"app"},
+	})
+	assert.Equal(t, []string{
+		`dependency cycle: test/crds -> test/app -> test/operator -> test/crds`,
+		`dependency cycle: prod/app -> prod/app`,
+	}, errorStrings(config.checkDependencies()))
+}
+
+func errorStrings(errs []error) []string {
+	var result []string
+	for _, err := range errs {
+		result = append(result, err.Error())
+	}
+	return result
+}
@@ -34,6 +34,10 @@ type Environment struct {
 	DefaultValues     []ChartValue `json:"defaultValues,omitempty"`
 	Releases          []*Release   `json:"releases,omitempty"`
 	Cluster           *Cluster     `json:"-"`
+	// MinAge and Cooldown are the defaults for triggers of releases in this
+	// environment, see ImageTrigger
+	MinAge   string `json:"minAge,omitempty"`
+	Cooldown string `json:"cooldown,omitempty"`
 
 	fromFile string
 }
@@ -79,6 +83,12 @@ func (e *Environment) sanityCheck() []error {
 		seenRelease[rel.Name] = true
 		issues = append(issues, rel.sanityCheck()...)
 	}
+	if _, err := ParseDuration(e.MinAge); err != nil {
+		issues = append(issues, fmt.Errorf(`environment %q: "minAge": %v`, e.Name, err))
+	}
+	if _, err := ParseDuration(e.Cooldown); err != nil {
+		issues = append(issues, fmt.Errorf(`environment %q: "cooldown": %v`, e.Name, err))
+	}
 	return issues
 }
 
@@ -98,3 +108,8 @@ func (e *Environment) GetRelease(name string) *Release {
 func (e *Environment) GetCluster() *Cluster {
 	return e.Cluster
 }
+
+// FromFile returns the file this environment was defined in.
+func (e *Environment) FromFile() string {
+	return e.fromFile
+}
@@ -0,0 +1,37 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package model
+
+// LintConfig controls which "kcd lint" rules are run. Rules not mentioned
+// keep their default setting.
+type LintConfig struct {
+	Rules map[string]bool `json:"rules,omitempty"`
+}
+
+// RuleEnabled returns whether a rule is enabled, falling back to defaultValue
+// if the rule is not configured.
+func (c *LintConfig) RuleEnabled(ruleID string, defaultValue bool) bool {
+	if c == nil || c.Rules == nil {
+		return defaultValue
+	}
+	if enabled, found := c.Rules[ruleID]; found {
+		return enabled
+	}
+	return defaultValue
+}
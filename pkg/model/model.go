@@ -36,6 +36,11 @@ type KubeCDConfig struct {
 	Environments []*Environment `json:"environments"`
 	HelmRepos    []HelmRepo     `json:"helmRepos,omitempty"`
 	KubeConfig   *string        `json:"kubeConfig,omitempty"`
+	Lint         *LintConfig    `json:"lint,omitempty"`
+	Registries   []Registry     `json:"registries,omitempty"`
+	// ImagePlatform is the platform, like "linux/arm64", whose image is used
+	// for multi-arch tags. The default is "linux/amd64".
+	ImagePlatform string `json:"imagePlatform,omitempty"`
 
 	fromFile string
 }
@@ -92,6 +97,7 @@ func (k *KubeCDConfig) sanityCheck() []error {
 		seenEnv[env.Name] = true
 		issues = append(issues, env.sanityCheck()...)
 	}
+	issues = append(issues, k.checkDependencies()...)
 	seenHelmRepo := make(map[string]bool)
 	for _, repo := range k.HelmRepos {
 		if _, seen := seenHelmRepo[repo.Name]; seen {
@@ -99,6 +105,15 @@ func (k *KubeCDConfig) sanityCheck() []error {
 		}
 		seenHelmRepo[repo.Name] = true
 	}
+	seenRegistry := make(map[string]bool)
+	for _, registry := range k.Registries {
+		if registry.Host == "" {
+			issues = append(issues, fmt.Errorf(`registry without host`))
+		} else if _, seen := seenRegistry[registry.Host]; seen {
+			issues = append(issues, fmt.Errorf(`duplicate registry host: %q`, registry.Host))
+		}
+		seenRegistry[registry.Host] = true
+	}
 	return issues
 }
 
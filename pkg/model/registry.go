@@ -0,0 +1,38 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package model
+
+// Registry configures access to a Docker registry. Username and password
+// may refer to environment variables, like "${HARBOR_PASSWORD}".
+type Registry struct {
+	// Host is a registry host name, or a pattern like "*.dkr.ecr.*.amazonaws.com"
+	Host string `json:"host"`
+	// Type selects how tags are listed, like "ecr" or "acr". The default depends on the host.
+	Type     string `json:"type,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func (r Registry) GetUsername() string {
+	return interpolateValue(r.Username)
+}
+
+func (r Registry) GetPassword() string {
+	return interpolateValue(r.Password)
+}
@@ -24,6 +24,8 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"regexp"
+	"time"
 
 	"github.com/ghodss/yaml"
 )
@@ -37,6 +39,14 @@ type Release struct {
 	Triggers          []ReleaseUpdateTrigger `json:"triggers,omitempty"`
 	SkipDefaultValues bool                   `json:"skipDefaultValues,omitempty"`
 	ResourceFiles     []string               `json:"resourceFiles,omitempty"`
+	// DependsOn lists releases that must be applied before this one, by name
+	// in the same environment, or as "env/release"
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Wait makes apply wait for the release's workloads to become ready,
+	// overriding the --wait flag
+	Wait *bool `json:"wait,omitempty"`
+	// Timeout is how long to wait for the workloads, overriding --timeout
+	Timeout string `json:"timeout,omitempty"`
 
 	FromFile    string       `json:"-"`
 	Environment *Environment `json:"-"`
@@ -103,9 +113,102 @@ func (r *Release) sanityCheck() []error {
 			issues = append(issues, fmt.Errorf(`release %q: must have a chart.version`, r.Name))
 		}
 	}
+	if _, err := ParseDuration(r.Timeout); err != nil {
+		issues = append(issues, fmt.Errorf(`release %q: "timeout": %v`, r.Name, err))
+	}
+	for _, trigger := range r.Triggers {
+		var minAge, cooldown string
+		switch {
+		case trigger.Image != nil:
+			minAge, cooldown = trigger.Image.MinAge, trigger.Image.Cooldown
+		case trigger.Chart != nil:
+			minAge, cooldown = trigger.Chart.MinAge, trigger.Chart.Cooldown
+		}
+		if _, err := ParseDuration(minAge); err != nil {
+			issues = append(issues, fmt.Errorf(`release %q: trigger "minAge": %v`, r.Name, err))
+		}
+		if _, err := ParseDuration(cooldown); err != nil {
+			issues = append(issues, fmt.Errorf(`release %q: trigger "cooldown": %v`, r.Name, err))
+		}
+		if trigger.Image == nil {
+			continue
+		}
+		switch trigger.Image.Pin {
+		case "", PinTag, PinDigest:
+		default:
+			issues = append(issues, fmt.Errorf(`release %q: image trigger "pin" must be %q or %q, not %q`, r.Name, PinTag, PinDigest, trigger.Image.Pin))
+		}
+		if trigger.Image.Pin == PinTag && trigger.Image.DigestValue != "" {
+			issues = append(issues, fmt.Errorf(`release %q: image trigger has a "digestValue", but "pin" is %q`, r.Name, PinTag))
+		}
+		switch trigger.Image.SortBy {
+		case "", SortBySemver, SortByNumeric, SortByCalver, SortByTimestamp:
+		default:
+			issues = append(issues, fmt.Errorf(`release %q: image trigger "sortBy" must be one of %q, %q, %q or %q, not %q`,
+				r.Name, SortBySemver, SortByNumeric, SortByCalver, SortByTimestamp, trigger.Image.SortBy))
+		}
+		pattern, err := regexp.Compile(trigger.Image.TagPattern)
+		if err != nil {
+			issues = append(issues, fmt.Errorf(`release %q: invalid image trigger "tagPattern": %v`, r.Name, err))
+		} else if _, err = trigger.Image.VersionGroup(pattern); err != nil {
+			issues = append(issues, fmt.Errorf(`release %q: image trigger %v`, r.Name, err))
+		}
+	}
 	return issues
 }
 
+// MinAge returns how old new image tags or chart versions must be for a
+// trigger, defaulting to the minAge of the release's environment.
+func (r *Release) MinAge(trigger ReleaseUpdateTrigger) time.Duration {
+	var minAge string
+	switch {
+	case trigger.Image != nil:
+		minAge = trigger.Image.MinAge
+	case trigger.Chart != nil:
+		minAge = trigger.Chart.MinAge
+	}
+	if minAge == "" && r.Environment != nil {
+		minAge = r.Environment.MinAge
+	}
+	duration, _ := ParseDuration(minAge)
+	return duration
+}
+
+// Cooldown returns how long to wait after a trigger's value was last changed
+// before updating it again, defaulting to the cooldown of the release's environment.
+func (r *Release) Cooldown(trigger ReleaseUpdateTrigger) time.Duration {
+	var cooldown string
+	switch {
+	case trigger.Image != nil:
+		cooldown = trigger.Image.Cooldown
+	case trigger.Chart != nil:
+		cooldown = trigger.Chart.Cooldown
+	}
+	if cooldown == "" && r.Environment != nil {
+		cooldown = r.Environment.Cooldown
+	}
+	duration, _ := ParseDuration(cooldown)
+	return duration
+}
+
+// ShouldWait tells whether apply should wait for the release's workloads to
+// become ready, defaulting to defaultWait.
+func (r *Release) ShouldWait(defaultWait bool) bool {
+	if r.Wait == nil {
+		return defaultWait
+	}
+	return *r.Wait
+}
+
+// WaitTimeout returns how long to wait for the release's workloads to become
+// ready, defaulting to defaultTimeout.
+func (r *Release) WaitTimeout(defaultTimeout time.Duration) time.Duration {
+	if timeout, _ := ParseDuration(r.Timeout); timeout > 0 {
+		return timeout
+	}
+	return defaultTimeout
+}
+
 func (r *Release) AbsPath(path string) string {
 	return ResolvePathFromFile(path, r.FromFile)
 }
@@ -23,6 +23,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestRelease_UnmarshalJSON(t *testing.T) {
@@ -37,3 +38,104 @@ trigger:
 	assert.Nil(t, release.Trigger)
 	assert.Len(t, release.Triggers, 1)
 }
+
+func TestReleaseSanityCheckPin(t *testing.T) {
+	ref, version := "stable/app", "1.0"
+	release := &Release{Name: "app", Chart: &Chart{Reference: &ref, Version: &version}}
+	for _, tc := range []struct {
+		trigger    ImageTrigger
+		issues     int
+		pinsDigest bool
+	}{
+		{ImageTrigger{}, 0, false},
+		{ImageTrigger{Pin: PinDigest}, 0, true},
+		{ImageTrigger{DigestValue: "image.digest"}, 0, true},
+		{ImageTrigger{Pin: PinTag, DigestValue: "image.digest"}, 1, false},
+		{ImageTrigger{Pin: "sometimes"}, 1, false},
+	} {
+		trigger := tc.trigger
+		release.Triggers = []ReleaseUpdateTrigger{{Image: &trigger}}
+		assert.Len(t, release.sanityCheck(), tc.issues, "%+v", trigger)
+		assert.Equal(t, tc.pinsDigest, trigger.PinsDigest(), "%+v", trigger)
+	}
+}
+
+func TestReleaseSanityCheckTagPattern(t *testing.T) {
+	ref, version := "stable/app", "1.0"
+	release := &Release{Name: "app", Chart: &Chart{Reference: &ref, Version: &version}}
+	for _, tc := range []struct {
+		trigger ImageTrigger
+		issues  int
+		sortBy  string
+	}{
+		{ImageTrigger{Track: "MinorVersion"}, 0, SortBySemver},
+		{ImageTrigger{Track: "Newest"}, 0, SortByTimestamp},
+		{ImageTrigger{TagPattern: `build-(\d+)`, VersionFrom: "1", SortBy: SortByNumeric}, 0, SortByNumeric},
+		{ImageTrigger{TagPattern: `v(?P<version>[0-9.]+)-alpine`, VersionFrom: "version"}, 0, SortBySemver},
+		{ImageTrigger{TagPattern: `build-(\d+)`, VersionFrom: "2"}, 1, SortBySemver},
+		{ImageTrigger{TagPattern: `build-(\d+)`, VersionFrom: "number"}, 1, SortBySemver},
+		{ImageTrigger{VersionFrom: "1"}, 1, SortBySemver},
+		{ImageTrigger{TagPattern: `build-(\d+`}, 1, SortBySemver},
+		{ImageTrigger{SortBy: "alphabetical"}, 1, "alphabetical"},
+	} {
+		trigger := tc.trigger
+		release.Triggers = []ReleaseUpdateTrigger{{Image: &trigger}}
+		assert.Len(t, release.sanityCheck(), tc.issues, "%+v", trigger)
+		assert.Equal(t, tc.sortBy, trigger.SortByString(), "%+v", trigger)
+	}
+}
+
+func TestReleaseMinAgeAndCooldown(t *testing.T) {
+	env := &Environment{Name: "prod", MinAge: "24h", Cooldown: "7d"}
+	ref, version := "stable/app", "1.0"
+	release := &Release{Name: "app", Chart: &Chart{Reference: &ref, Version: &version}, Environment: env}
+	imageTrigger := ReleaseUpdateTrigger{Image: &ImageTrigger{MinAge: "2h"}}
+	chartTrigger := ReleaseUpdateTrigger{Chart: &HelmTrigger{Cooldown: "30m"}}
+	assert.Equal(t, 2*time.Hour, release.MinAge(imageTrigger))
+	assert.Equal(t, 7*24*time.Hour, release.Cooldown(imageTrigger))
+	assert.Equal(t, 24*time.Hour, release.MinAge(chartTrigger))
+	assert.Equal(t, 30*time.Minute, release.Cooldown(chartTrigger))
+	env.MinAge, env.Cooldown = "", ""
+	assert.Zero(t, release.MinAge(chartTrigger))
+	assert.Zero(t, release.Cooldown(imageTrigger))
+
+	release.Triggers = []ReleaseUpdateTrigger{
+		{Image: &ImageTrigger{MinAge: "a day"}},
+		{Chart: &HelmTrigger{Cooldown: "-1h"}},
+	}
+	assert.Len(t, release.sanityCheck(), 2)
+	env.MinAge = "1w"
+	assert.Len(t, env.sanityCheck(), 1)
+}
+
+func TestReleaseWaitAndTimeout(t *testing.T) {
+	release := &Release{Name: "app", ResourceFiles: []string{"app.yaml"}}
+	assert.True(t, release.ShouldWait(true))
+	assert.False(t, release.ShouldWait(false))
+	assert.Equal(t, 5*time.Minute, release.WaitTimeout(5*time.Minute))
+	wait := false
+	release.Wait, release.Timeout = &wait, "10m"
+	assert.False(t, release.ShouldWait(true))
+	assert.Equal(t, 10*time.Minute, release.WaitTimeout(5*time.Minute))
+	assert.Empty(t, release.sanityCheck())
+	release.Timeout = "soon"
+	assert.Len(t, release.sanityCheck(), 1)
+}
+
+func TestParseDuration(t *testing.T) {
+	for str, expected := range map[string]time.Duration{
+		"":      0,
+		"24h":   24 * time.Hour,
+		"90m":   90 * time.Minute,
+		"7d":    7 * 24 * time.Hour,
+		"1h30m": 90 * time.Minute,
+	} {
+		duration, err := ParseDuration(str)
+		assert.NoError(t, err, str)
+		assert.Equal(t, expected, duration, str)
+	}
+	for _, str := range []string{"1w", "d", "-5m", "1.5d"} {
+		_, err := ParseDuration(str)
+		assert.Error(t, err, str)
+	}
+}
@@ -18,17 +18,102 @@
 
 package model
 
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
 const (
 	DefaultTagValue        = "image.tag"
 	DefaultRepoValue       = "image.repository"
 	DefaultRepoPrefixValue = "image.prefix"
 )
 
+// Image trigger pin modes.
+const (
+	// PinTag updates the tag only
+	PinTag = "tag"
+	// PinDigest updates the tag and pins it to the tag's digest, in
+	// digestValue, or in the tag value as "tag@digest" without one
+	PinDigest = "digest"
+)
+
+// Image trigger sort orders, selecting how tags are compared.
+const (
+	// SortBySemver compares tags as semantic versions, within the trigger's track
+	SortBySemver = "semver"
+	// SortByNumeric compares tags as integers, like build numbers
+	SortByNumeric = "numeric"
+	// SortByCalver compares tags as dot or dash separated numbers, like "2024.05.17"
+	SortByCalver = "calver"
+	// SortByTimestamp compares tags by the time they were created
+	SortByTimestamp = "timestamp"
+)
+
 type ImageTrigger struct {
-	TagValue        string `json:"tagValue"`
-	RepoValue       string `json:"repoValue"`
-	RepoPrefixValue string `json:"repoPrefixValue"`
-	Track           string `json:"track"` // one of "PatchLevel", "MinorVersion", "MajorVersion", "Newest"
+	TagValue        string   `json:"tagValue"`
+	RepoValue       string   `json:"repoValue"`
+	RepoPrefixValue string   `json:"repoPrefixValue"`
+	Track           string   `json:"track"` // "PatchLevel", "MinorVersion", "MajorVersion", "Newest" or a constraint like ">=2.3 <3"
+	DigestValue     string   `json:"digestValue,omitempty"`
+	Pin             string   `json:"pin,omitempty"`     // one of "tag" (default) or "digest"
+	Exclude         []string `json:"exclude,omitempty"` // versions, tags or version ranges never chosen
+	// TagPattern is a regular expression that candidate tags must match in full
+	TagPattern string `json:"tagPattern,omitempty"`
+	// VersionFrom is the name or number of the TagPattern group holding the
+	// version to compare, instead of the whole tag
+	VersionFrom string `json:"versionFrom,omitempty"`
+	SortBy      string `json:"sortBy,omitempty"` // one of "semver", "numeric", "calver" or "timestamp"
+	// Prerelease is one of "none", "same-series" or "any", selecting which
+	// prerelease versions are upgrades
+	Prerelease string `json:"prerelease,omitempty"`
+	// Channels limits prereleases to those with these identifiers, like "rc"
+	Channels []string `json:"channels,omitempty"`
+	// MinAge is how old tags must be to be chosen, like "24h"
+	MinAge string `json:"minAge,omitempty"`
+	// Cooldown is how long after the tag was last changed to wait before updating it again
+	Cooldown string `json:"cooldown,omitempty"`
+}
+
+// PinsDigest tells whether updates should pin the new tag to its digest.
+// Setting digestValue implies pin: digest.
+func (t *ImageTrigger) PinsDigest() bool {
+	return t.Pin == PinDigest || (t.Pin == "" && t.DigestValue != "")
+}
+
+// SortByString returns the sort order of the trigger, which by default is
+// "timestamp" for track Newest, and "semver" otherwise.
+func (t *ImageTrigger) SortByString() string {
+	if t.SortBy != "" {
+		return t.SortBy
+	}
+	if t.Track == "Newest" {
+		return SortByTimestamp
+	}
+	return SortBySemver
+}
+
+// VersionGroup returns the index of the TagPattern group given by VersionFrom,
+// or 0 for the whole tag.
+func (t *ImageTrigger) VersionGroup(pattern *regexp.Regexp) (int, error) {
+	if t.VersionFrom == "" {
+		return 0, nil
+	}
+	if index, err := strconv.Atoi(t.VersionFrom); err == nil {
+		if index < 0 || index > pattern.NumSubexp() {
+			return 0, fmt.Errorf(`"versionFrom" group %d not in "tagPattern" %q`, index, t.TagPattern)
+		}
+		return index, nil
+	}
+	for index, name := range pattern.SubexpNames() {
+		if name != "" && name == t.VersionFrom {
+			return index, nil
+		}
+	}
+	return 0, fmt.Errorf(`"versionFrom" group %q not in "tagPattern" %q`, t.VersionFrom, t.TagPattern)
 }
 
 func (t *ImageTrigger) TagValueString() string {
@@ -53,7 +138,32 @@ func (t *ImageTrigger) RepoPrefixValueString() string {
 }
 
 type HelmTrigger struct {
-	Track string `json:"track"` // one of "PatchLevel", "MinorVersion", "MajorVersion", "Newest"
+	Track      string   `json:"track"` // "PatchLevel", "MinorVersion", "MajorVersion", "Newest" or a constraint like "~1.4"
+	Exclude    []string `json:"exclude,omitempty"`
+	Prerelease string   `json:"prerelease,omitempty"` // one of "none", "same-series" or "any"
+	Channels   []string `json:"channels,omitempty"`
+	MinAge     string   `json:"minAge,omitempty"`   // how old chart versions must be to be chosen
+	Cooldown   string   `json:"cooldown,omitempty"` // how long to wait after the version was last changed
+}
+
+// ParseDuration parses durations for minAge and cooldown, which are like
+// time.ParseDuration, or a number of days like "7d". Empty means zero.
+func ParseDuration(str string) (time.Duration, error) {
+	if str == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(str, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(str, "d"), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf(`invalid duration %q`, str)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(str)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf(`invalid duration %q`, str)
+	}
+	return duration, nil
 }
 
 type ReleaseUpdateTrigger struct {
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package output renders command results as tables, JSON or YAML.
+//
+// The types in this package are the schemas of machine-readable output, and
+// fields must not be renamed or removed.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+)
+
+// Output formats. The empty format means a command's own plain text output.
+const (
+	FormatText  = ""
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+)
+
+// CheckFormat returns an error for unknown formats.
+func CheckFormat(format string) error {
+	switch format {
+	case FormatText, FormatTable, FormatJSON, FormatYAML:
+		return nil
+	}
+	return fmt.Errorf(`unknown output format %q, must be one of %q, %q or %q`, format, FormatTable, FormatJSON, FormatYAML)
+}
+
+// IsStructured tells whether format is meant to be read by tools, in which
+// case commands should keep any other output away from stdout.
+func IsStructured(format string) bool {
+	return format == FormatJSON || format == FormatYAML
+}
+
+// Table is a header and rows of cells.
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Tabular is implemented by results that can be rendered as a table.
+type Tabular interface {
+	Table() Table
+}
+
+// Write renders v in the given format. For the table format, v must implement Tabular.
+func Write(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case FormatYAML:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case FormatTable, FormatText:
+		tabular, ok := v.(Tabular)
+		if !ok {
+			return fmt.Errorf(`%T can not be rendered as a table`, v)
+		}
+		return WriteTable(w, tabular.Table())
+	}
+	return CheckFormat(format)
+}
+
+// WriteTable writes a table with aligned columns.
+func WriteTable(w io.Writer, table Table) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if len(table.Header) > 0 {
+		_, _ = fmt.Fprintln(tw, strings.Join(table.Header, "\t"))
+	}
+	for _, row := range table.Rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	updates := Updates{Updates: []Update{
+		{Kind: UpdateKindImage, Environment: "prod", Release: "app", File: "/releases.yaml", Image: "example.io/app", OldTag: "v1", NewTag: "v2", Patched: true},
+		{Kind: UpdateKindChart, Environment: "prod", Release: "ingress", File: "/releases.yaml", Chart: "stable/nginx-ingress", OldVersion: "1.0.0", NewVersion: "1.1.0"},
+	}}
+	for format, expected := range map[string]string{
+		FormatTable: `ENV   RELEASE  KIND   NAME                  OLD    NEW    FILE            PATCHED
+prod  app      image  example.io/app        v1     v2     /releases.yaml  true
+prod  ingress  chart  stable/nginx-ingress  1.0.0  1.1.0  /releases.yaml  false
+`,
+		FormatJSON: `{
+  "updates": [
+    {
+      "kind": "image",
+      "env": "prod",
+      "release": "app",
+      "file": "/releases.yaml",
+      "image": "example.io/app",
+      "oldTag": "v1",
+      "newTag": "v2",
+      "patched": true
+    },
+    {
+      "kind": "chart",
+      "env": "prod",
+      "release": "ingress",
+      "file": "/releases.yaml",
+      "chart": "stable/nginx-ingress",
+      "oldVersion": "1.0.0",
+      "newVersion": "1.1.0",
+      "patched": false
+    }
+  ]
+}
+`,
+		FormatYAML: `updates:
+- env: prod
+  file: /releases.yaml
+  image: example.io/app
+  kind: image
+  newTag: v2
+  oldTag: v1
+  patched: true
+  release: app
+- chart: stable/nginx-ingress
+  env: prod
+  file: /releases.yaml
+  kind: chart
+  newVersion: 1.1.0
+  oldVersion: 1.0.0
+  patched: false
+  release: ingress
+`,
+	} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, format, updates))
+			assert.Equal(t, expected, buf.String())
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Error(t, Write(&buf, FormatTable, struct{}{}))
+	assert.Error(t, Write(&buf, "xml", Updates{}))
+	assert.Error(t, CheckFormat("xml"))
+	assert.NoError(t, CheckFormat(FormatText))
+}
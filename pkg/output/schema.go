@@ -0,0 +1,177 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package output
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Kinds of Update.
+const (
+	UpdateKindImage = "image"
+	UpdateKindChart = "chart"
+)
+
+// Update is an image tag or chart version update found by poll or observe.
+type Update struct {
+	Kind        string `json:"kind"`
+	Environment string `json:"env"`
+	Release     string `json:"release"`
+	// File is the file defining the tag or version
+	File       string `json:"file"`
+	Image      string `json:"image,omitempty"`
+	Chart      string `json:"chart,omitempty"`
+	OldTag     string `json:"oldTag,omitempty"`
+	NewTag     string `json:"newTag,omitempty"`
+	OldDigest  string `json:"oldDigest,omitempty"`
+	NewDigest  string `json:"newDigest,omitempty"`
+	OldVersion string `json:"oldVersion,omitempty"`
+	NewVersion string `json:"newVersion,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	// Patched is true when File was patched with the update
+	Patched bool `json:"patched"`
+}
+
+// Updates is the result of poll and observe.
+type Updates struct {
+	Updates []Update `json:"updates"`
+}
+
+func (u Updates) Table() Table {
+	table := Table{Header: []string{"ENV", "RELEASE", "KIND", "NAME", "OLD", "NEW", "FILE", "PATCHED"}}
+	for _, update := range u.Updates {
+		name, oldValue, newValue := update.Image, update.OldTag, update.NewTag
+		if update.Kind == UpdateKindChart {
+			name, oldValue, newValue = update.Chart, update.OldVersion, update.NewVersion
+		}
+		table.Rows = append(table.Rows, []string{
+			update.Environment, update.Release, update.Kind, name, oldValue, newValue, update.File, strconv.FormatBool(update.Patched),
+		})
+	}
+	return table
+}
+
+// Command is a command run by kcd.
+type Command struct {
+	Argv []string `json:"argv"`
+	// ExitStatus is -1 when the command could not be started, or was not run
+	ExitStatus int `json:"exitStatus"`
+	// Duration is the number of seconds the command ran
+	Duration float64 `json:"duration"`
+	DryRun   bool    `json:"dryRun,omitempty"`
+}
+
+// Commands is the result of apply.
+type Commands struct {
+	Commands []Command `json:"commands"`
+}
+
+func (c Commands) Table() Table {
+	table := Table{Header: []string{"EXIT", "DURATION", "COMMAND"}}
+	for _, command := range c.Commands {
+		exitStatus := strconv.Itoa(command.ExitStatus)
+		if command.DryRun {
+			exitStatus = "-"
+		}
+		table.Rows = append(table.Rows, []string{
+			exitStatus, strconv.FormatFloat(command.Duration, 'f', 1, 64) + "s", strings.Join(command.Argv, " "),
+		})
+	}
+	return table
+}
+
+// Environment is an environment shown by list.
+type Environment struct {
+	Name          string `json:"name"`
+	Cluster       string `json:"cluster"`
+	KubeNamespace string `json:"kubeNamespace"`
+}
+
+// Release is a release shown by list.
+type Release struct {
+	Environment string `json:"env"`
+	Name        string `json:"name"`
+	File        string `json:"file"`
+}
+
+// Cluster is a cluster shown by list.
+type Cluster struct {
+	Name string `json:"name"`
+}
+
+// Environments is the result of "list envs".
+type Environments struct {
+	Environments []Environment `json:"environments"`
+}
+
+func (e Environments) Table() Table {
+	table := Table{Header: []string{"NAME", "CLUSTER", "NAMESPACE"}}
+	for _, env := range e.Environments {
+		table.Rows = append(table.Rows, []string{env.Name, env.Cluster, env.KubeNamespace})
+	}
+	return table
+}
+
+// Releases is the result of "list releases".
+type Releases struct {
+	Releases []Release `json:"releases"`
+}
+
+func (r Releases) Table() Table {
+	table := Table{Header: []string{"ENV", "NAME", "FILE"}}
+	for _, release := range r.Releases {
+		table.Rows = append(table.Rows, []string{release.Environment, release.Name, release.File})
+	}
+	return table
+}
+
+// Clusters is the result of "list clusters".
+type Clusters struct {
+	Clusters []Cluster `json:"clusters"`
+}
+
+func (c Clusters) Table() Table {
+	table := Table{Header: []string{"NAME"}}
+	for _, cluster := range c.Clusters {
+		table.Rows = append(table.Rows, []string{cluster.Name})
+	}
+	return table
+}
+
+// AffectedRelease is a release that differs between two git revisions.
+type AffectedRelease struct {
+	Environment string `json:"env"`
+	Name        string `json:"name"`
+	// Change is one of "added", "changed" or "removed"
+	Change string `json:"change"`
+}
+
+// AffectedReleases is the result of affected.
+type AffectedReleases struct {
+	Releases []AffectedRelease `json:"releases"`
+}
+
+func (a AffectedReleases) Table() Table {
+	table := Table{Header: []string{"ENV", "NAME", "CHANGE"}}
+	for _, release := range a.Releases {
+		table.Rows = append(table.Rows, []string{release.Environment, release.Name, release.Change})
+	}
+	return table
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rollout
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Client gets workloads and their pods from a cluster.
+type Client interface {
+	// Get returns a workload, as an *appsv1.Deployment, *appsv1.StatefulSet,
+	// *appsv1.DaemonSet or *batchv1.Job
+	Get(workload Workload) (runtime.Object, error)
+	// List returns the workloads of all the supported kinds in a namespace
+	List(namespace string) ([]runtime.Object, error)
+	// Pods returns the pods in a namespace matching a selector
+	Pods(namespace string, selector *metav1.LabelSelector) ([]corev1.Pod, error)
+	// ReplicaSets returns the ReplicaSets in a namespace matching a selector
+	ReplicaSets(namespace string, selector *metav1.LabelSelector) ([]appsv1.ReplicaSet, error)
+	// ControllerRevisions returns the ControllerRevisions in a namespace
+	// matching a selector
+	ControllerRevisions(namespace string, selector *metav1.LabelSelector) ([]appsv1.ControllerRevision, error)
+}
+
+type kubeClient struct {
+	clientset kubernetes.Interface
+}
+
+// NewClient returns a Client for a context of the kubeconfig, which is found
+// the same way as kubectl finds it.
+func NewClient(contextName string) (Client, error) {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: contextName},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf(`kubeconfig context %q: %v`, contextName, err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kubeClient{clientset: clientset}, nil
+}
+
+func (c *kubeClient) Get(workload Workload) (runtime.Object, error) {
+	ctx, options := context.Background(), metav1.GetOptions{}
+	switch workload.Kind {
+	case KindDeployment:
+		return c.clientset.AppsV1().Deployments(workload.Namespace).Get(ctx, workload.Name, options)
+	case KindStatefulSet:
+		return c.clientset.AppsV1().StatefulSets(workload.Namespace).Get(ctx, workload.Name, options)
+	case KindDaemonSet:
+		return c.clientset.AppsV1().DaemonSets(workload.Namespace).Get(ctx, workload.Name, options)
+	case KindJob:
+		return c.clientset.BatchV1().Jobs(workload.Namespace).Get(ctx, workload.Name, options)
+	}
+	return nil, fmt.Errorf(`unsupported workload kind %q`, workload.Kind)
+}
+
+func (c *kubeClient) List(namespace string) ([]runtime.Object, error) {
+	ctx, options := context.Background(), metav1.ListOptions{}
+	var objects []runtime.Object
+	deployments, err := c.clientset.AppsV1().Deployments(namespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		objects = append(objects, &deployments.Items[i])
+	}
+	statefulSets, err := c.clientset.AppsV1().StatefulSets(namespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		objects = append(objects, &statefulSets.Items[i])
+	}
+	daemonSets, err := c.clientset.AppsV1().DaemonSets(namespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		objects = append(objects, &daemonSets.Items[i])
+	}
+	jobs, err := c.clientset.BatchV1().Jobs(namespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs.Items {
+		objects = append(objects, &jobs.Items[i])
+	}
+	return objects, nil
+}
+
+func (c *kubeClient) Pods(namespace string, selector *metav1.LabelSelector) ([]corev1.Pod, error) {
+	options, err := selectorListOptions(selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(context.Background(), options)
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+func (c *kubeClient) ReplicaSets(namespace string, selector *metav1.LabelSelector) ([]appsv1.ReplicaSet, error) {
+	options, err := selectorListOptions(selector)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := c.clientset.AppsV1().ReplicaSets(namespace).List(context.Background(), options)
+	if err != nil {
+		return nil, err
+	}
+	return replicaSets.Items, nil
+}
+
+func (c *kubeClient) ControllerRevisions(namespace string, selector *metav1.LabelSelector) ([]appsv1.ControllerRevision, error) {
+	options, err := selectorListOptions(selector)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := c.clientset.AppsV1().ControllerRevisions(namespace).List(context.Background(), options)
+	if err != nil {
+		return nil, err
+	}
+	return revisions.Items, nil
+}
+
+func selectorListOptions(selector *metav1.LabelSelector) (metav1.ListOptions, error) {
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return metav1.ListOptions{}, err
+	}
+	return metav1.ListOptions{LabelSelector: labelSelector.String()}, nil
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rollout
+
+import (
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// status tells whether a workload is ready, describing its progress, and
+// returns the selector of its pods. It returns an error if the rollout
+// failed.
+func status(object runtime.Object) (ready bool, progress string, selector *metav1.LabelSelector, err error) {
+	switch w := object.(type) {
+	case *appsv1.Deployment:
+		ready, progress, err = deploymentStatus(w)
+		selector = w.Spec.Selector
+	case *appsv1.StatefulSet:
+		ready, progress = statefulSetStatus(w)
+		selector = w.Spec.Selector
+	case *appsv1.DaemonSet:
+		ready, progress = daemonSetStatus(w)
+		selector = w.Spec.Selector
+	case *batchv1.Job:
+		ready, progress, err = jobStatus(w)
+		selector = w.Spec.Selector
+	default:
+		err = fmt.Errorf(`unsupported workload %T`, object)
+	}
+	return ready, progress, selector, err
+}
+
+const waitingForController = "waiting for the controller to see the update"
+
+func replicas(specReplicas *int32) int32 {
+	if specReplicas == nil {
+		return 1
+	}
+	return *specReplicas
+}
+
+func deploymentStatus(d *appsv1.Deployment) (bool, string, error) {
+	if d.Status.ObservedGeneration < d.Generation {
+		return false, waitingForController, nil
+	}
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, "", fmt.Errorf(`progress deadline exceeded`)
+		}
+	}
+	want := replicas(d.Spec.Replicas)
+	switch {
+	case d.Status.UpdatedReplicas < want:
+		return false, fmt.Sprintf("%d of %d replicas updated", d.Status.UpdatedReplicas, want), nil
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d old replicas pending termination", d.Status.Replicas-d.Status.UpdatedReplicas), nil
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d of %d updated replicas available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas), nil
+	}
+	return true, fmt.Sprintf("%d of %d replicas available", d.Status.AvailableReplicas, want), nil
+}
+
+func statefulSetStatus(s *appsv1.StatefulSet) (bool, string) {
+	if s.Status.ObservedGeneration < s.Generation {
+		return false, waitingForController
+	}
+	want := replicas(s.Spec.Replicas)
+	if s.Status.ReadyReplicas < want {
+		return false, fmt.Sprintf("%d of %d replicas ready", s.Status.ReadyReplicas, want)
+	}
+	if s.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType {
+		if rollingUpdate := s.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil {
+			if wantUpdated := want - *rollingUpdate.Partition; s.Status.UpdatedReplicas < wantUpdated {
+				return false, fmt.Sprintf("%d of %d replicas updated", s.Status.UpdatedReplicas, wantUpdated)
+			}
+		} else if s.Status.UpdateRevision != s.Status.CurrentRevision {
+			return false, fmt.Sprintf("%d of %d replicas updated", s.Status.UpdatedReplicas, want)
+		}
+	}
+	return true, fmt.Sprintf("%d of %d replicas ready", s.Status.ReadyReplicas, want)
+}
+
+func daemonSetStatus(d *appsv1.DaemonSet) (bool, string) {
+	if d.Status.ObservedGeneration < d.Generation {
+		return false, waitingForController
+	}
+	want := d.Status.DesiredNumberScheduled
+	if d.Status.UpdatedNumberScheduled < want {
+		return false, fmt.Sprintf("%d of %d pods updated", d.Status.UpdatedNumberScheduled, want)
+	}
+	if d.Status.NumberAvailable < want {
+		return false, fmt.Sprintf("%d of %d updated pods available", d.Status.NumberAvailable, want)
+	}
+	return true, fmt.Sprintf("%d of %d pods available", d.Status.NumberAvailable, want)
+}
+
+func jobStatus(j *batchv1.Job) (bool, string, error) {
+	for _, condition := range j.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobFailed:
+			return false, "", fmt.Errorf(`job failed: %s`, condition.Message)
+		case batchv1.JobComplete:
+			return true, "complete", nil
+		}
+	}
+	if j.Spec.Completions == nil {
+		return false, fmt.Sprintf("%d pods active", j.Status.Active), nil
+	}
+	return false, fmt.Sprintf("%d of %d completions", j.Status.Succeeded, *j.Spec.Completions), nil
+}
+
+// crashLooping returns a description of the first container of the pods
+// that is in CrashLoopBackOff, or "" if none are.
+func crashLooping(pods []corev1.Pod) string {
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, container := range statuses {
+			if waiting := container.State.Waiting; waiting != nil && waiting.Reason == "CrashLoopBackOff" {
+				return fmt.Sprintf("pod %q container %q is in CrashLoopBackOff: %s", pod.Name, container.Name, waiting.Message)
+			}
+		}
+	}
+	return ""
+}
+
+const (
+	revisionAnnotation      = "deployment.kubernetes.io/revision"
+	podTemplateHashLabel    = appsv1.DefaultDeploymentUniqueLabelKey
+	controllerRevisionLabel = appsv1.ControllerRevisionHashLabelKey
+)
+
+// currentPods returns the pods of the current revision of a workload: those of
+// the newest ReplicaSet of a Deployment, and those of the update revision of a
+// StatefulSet or DaemonSet. Pods of a Job are all current. Until the controller
+// has seen the update of a workload, none of its pods are.
+func currentPods(client Client, namespace string, object runtime.Object, pods []corev1.Pod) ([]corev1.Pod, error) {
+	var label, revision string
+	switch w := object.(type) {
+	case *appsv1.Deployment:
+		if w.Status.ObservedGeneration < w.Generation {
+			return nil, nil
+		}
+		replicaSets, err := client.ReplicaSets(namespace, w.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if rs := newestReplicaSet(w, replicaSets); rs != nil {
+			label, revision = podTemplateHashLabel, rs.Labels[podTemplateHashLabel]
+		}
+	case *appsv1.StatefulSet:
+		if w.Status.ObservedGeneration < w.Generation {
+			return nil, nil
+		}
+		label, revision = controllerRevisionLabel, w.Status.UpdateRevision
+	case *appsv1.DaemonSet:
+		if w.Status.ObservedGeneration < w.Generation {
+			return nil, nil
+		}
+		revisions, err := client.ControllerRevisions(namespace, w.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if cr := newestControllerRevision(w, revisions); cr != nil {
+			label, revision = controllerRevisionLabel, cr.Labels[controllerRevisionLabel]
+		}
+	default:
+		return pods, nil
+	}
+	if revision == "" {
+		return nil, nil
+	}
+	var current []corev1.Pod
+	for _, pod := range pods {
+		if pod.Labels[label] == revision {
+			current = append(current, pod)
+		}
+	}
+	return current, nil
+}
+
+func newestReplicaSet(d *appsv1.Deployment, replicaSets []appsv1.ReplicaSet) *appsv1.ReplicaSet {
+	var newest *appsv1.ReplicaSet
+	newestRevision := int64(-1)
+	for i := range replicaSets {
+		rs := &replicaSets[i]
+		if !metav1.IsControlledBy(rs, d) {
+			continue
+		}
+		revision, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+		if err == nil && revision > newestRevision {
+			newest, newestRevision = rs, revision
+		}
+	}
+	return newest
+}
+
+func newestControllerRevision(d *appsv1.DaemonSet, revisions []appsv1.ControllerRevision) *appsv1.ControllerRevision {
+	var newest *appsv1.ControllerRevision
+	for i := range revisions {
+		cr := &revisions[i]
+		if metav1.IsControlledBy(cr, d) && (newest == nil || cr.Revision > newest.Revision) {
+			newest = cr
+		}
+	}
+	return newest
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rollout
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestDeploymentStatus(t *testing.T) {
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1},
+	}
+	ready, progress, err := deploymentStatus(d)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+	assert.Equal(t, waitingForController, progress)
+	d.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 2}
+	_, progress, _ = deploymentStatus(d)
+	assert.Equal(t, "2 of 3 replicas updated", progress)
+	d.Status.UpdatedReplicas = 3
+	_, progress, _ = deploymentStatus(d)
+	assert.Equal(t, "1 old replicas pending termination", progress)
+	d.Status.Replicas, d.Status.AvailableReplicas = 3, 2
+	_, progress, _ = deploymentStatus(d)
+	assert.Equal(t, "2 of 3 updated replicas available", progress)
+	d.Status.AvailableReplicas = 3
+	ready, progress, err = deploymentStatus(d)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+	assert.Equal(t, "3 of 3 replicas available", progress)
+	d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded"}}
+	_, _, err = deploymentStatus(d)
+	assert.Error(t, err)
+}
+
+func TestStatefulSetStatus(t *testing.T) {
+	s := &appsv1.StatefulSet{
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:       int32Ptr(2),
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType},
+		},
+		Status: appsv1.StatefulSetStatus{ReadyReplicas: 1, CurrentRevision: "a", UpdateRevision: "b"},
+	}
+	ready, progress := statefulSetStatus(s)
+	assert.False(t, ready)
+	assert.Equal(t, "1 of 2 replicas ready", progress)
+	s.Status.ReadyReplicas, s.Status.UpdatedReplicas = 2, 1
+	ready, progress = statefulSetStatus(s)
+	assert.False(t, ready)
+	assert.Equal(t, "1 of 2 replicas updated", progress)
+	s.Spec.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{Partition: int32Ptr(1)}
+	ready, _ = statefulSetStatus(s)
+	assert.True(t, ready, "only replicas above the partition are updated")
+}
+
+func TestDaemonSetStatus(t *testing.T) {
+	d := &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 2}}
+	ready, progress := daemonSetStatus(d)
+	assert.False(t, ready)
+	assert.Equal(t, "2 of 3 updated pods available", progress)
+	d.Status.NumberAvailable = 3
+	ready, _ = daemonSetStatus(d)
+	assert.True(t, ready)
+}
+
+func TestJobStatus(t *testing.T) {
+	j := &batchv1.Job{
+		Spec:   batchv1.JobSpec{Completions: int32Ptr(2)},
+		Status: batchv1.JobStatus{Succeeded: 1},
+	}
+	ready, progress, err := jobStatus(j)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+	assert.Equal(t, "1 of 2 completions", progress)
+	j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	ready, _, err = jobStatus(j)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+	j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+	_, _, err = jobStatus(j)
+	assert.EqualError(t, err, "job failed: BackoffLimitExceeded")
+}
+
+func TestCrashLooping(t *testing.T) {
+	pods := []corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1"},
+		Status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "migrate",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 10s"}},
+		}}},
+	}}
+	assert.Equal(t, `pod "app-1" container "migrate" is in CrashLoopBackOff: back-off 10s`, crashLooping(pods))
+	pods[0].Status.InitContainerStatuses[0].State.Waiting.Reason = "PodInitializing"
+	assert.Empty(t, crashLooping(pods))
+}
+
+func TestCurrentPods(t *testing.T) {
+	revisionLabels := func(revision string) map[string]string {
+		return map[string]string{controllerRevisionLabel: revision}
+	}
+	podNames := func(pods []corev1.Pod) []string {
+		var names []string
+		for _, pod := range pods {
+			names = append(names, pod.Name)
+		}
+		return names
+	}
+	pods := []corev1.Pod{pod("old", true, revisionLabels("web-1")), pod("new", false, revisionLabels("web-2"))}
+	client := &testClient{}
+
+	s := &appsv1.StatefulSet{Status: appsv1.StatefulSetStatus{UpdateRevision: "web-2"}}
+	current, err := currentPods(client, "default", s, pods)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"new"}, podNames(current))
+
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "web", UID: "web-uid"}}
+	owner := []metav1.OwnerReference{*metav1.NewControllerRef(ds, appsv1.SchemeGroupVersion.WithKind("DaemonSet"))}
+	client.revisions = []appsv1.ControllerRevision{
+		{ObjectMeta: metav1.ObjectMeta{Labels: revisionLabels("web-2"), OwnerReferences: owner}, Revision: 2},
+		{ObjectMeta: metav1.ObjectMeta{Labels: revisionLabels("web-1"), OwnerReferences: owner}, Revision: 1},
+		{ObjectMeta: metav1.ObjectMeta{Labels: revisionLabels("other-3")}, Revision: 3},
+	}
+	current, err = currentPods(client, "default", ds, pods)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"new"}, podNames(current))
+
+	d := deployment(1)
+	d.Generation = 2
+	d.Status.ObservedGeneration = 1
+	client.replicaSets = []appsv1.ReplicaSet{replicaSet(d, "1", "old")}
+	current, err = currentPods(client, "default", d, []corev1.Pod{pod("old", true, map[string]string{podTemplateHashLabel: "old"})})
+	assert.NoError(t, err)
+	assert.Empty(t, current, "the update was not seen yet")
+
+	current, err = currentPods(client, "default", &batchv1.Job{}, pods)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old", "new"}, podNames(current))
+}
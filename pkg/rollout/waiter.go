@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rollout
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// DefaultInterval is how often a Waiter polls workloads by default
+const DefaultInterval = 2 * time.Second
+
+// Waiter waits for workloads to become ready, like "kubectl rollout status".
+type Waiter struct {
+	Client Client
+	// Interval is how often workloads are polled, DefaultInterval if zero
+	Interval time.Duration
+	// Out receives the progress of the workloads, one line when it changes
+	Out io.Writer
+}
+
+// Wait polls the workloads until all are ready, and fails if a rollout
+// failed, a pod of their current revision is in CrashLoopBackOff, or the
+// timeout expires first.
+func (w *Waiter) Wait(workloads []Workload, timeout time.Duration) error {
+	interval := w.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	deadline := time.Now().Add(timeout)
+	pending := append([]Workload{}, workloads...)
+	progress := make(map[Workload]string)
+	for {
+		var stillPending []Workload
+		for _, workload := range pending {
+			ready, err := w.poll(workload, progress)
+			if err != nil {
+				return fmt.Errorf(`%s: %v`, workload, err)
+			}
+			if !ready {
+				stillPending = append(stillPending, workload)
+			}
+		}
+		pending = stillPending
+		if len(pending) == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			names := make([]string, len(pending))
+			for i, workload := range pending {
+				names[i] = workload.String()
+			}
+			return fmt.Errorf(`timed out after %s waiting for %s`, timeout, strings.Join(names, ", "))
+		}
+		time.Sleep(interval)
+	}
+}
+
+// poll gets the status of a workload, printing its progress if it changed.
+func (w *Waiter) poll(workload Workload, progress map[Workload]string) (bool, error) {
+	object, err := w.Client.Get(workload)
+	if err != nil {
+		return false, err
+	}
+	ready, current, selector, err := status(object)
+	if err != nil {
+		return false, err
+	}
+	if ready {
+		current = "ready, " + current
+	}
+	if current != progress[workload] {
+		progress[workload] = current
+		if w.Out != nil {
+			_, _ = fmt.Fprintf(w.Out, "%s: %s\n", workload, current)
+		}
+	}
+	if ready || selector == nil {
+		return ready, nil
+	}
+	pods, err := w.Client.Pods(workload.Namespace, selector)
+	if err != nil {
+		return false, err
+	}
+	// pods of the previous revision say nothing about this one
+	if pods, err = currentPods(w.Client, workload.Namespace, object, pods); err != nil {
+		return false, err
+	}
+	if crash := crashLooping(pods); crash != "" {
+		return false, errors.New(crash)
+	}
+	return false, nil
+}
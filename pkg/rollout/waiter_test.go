@@ -0,0 +1,161 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rollout
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// testClient returns the next of a sequence of objects for each workload,
+// repeating the last one
+type testClient struct {
+	objects     map[Workload][]runtime.Object
+	pods        []corev1.Pod
+	replicaSets []appsv1.ReplicaSet
+	revisions   []appsv1.ControllerRevision
+}
+
+func (c *testClient) Get(workload Workload) (runtime.Object, error) {
+	objects, ok := c.objects[workload]
+	if !ok {
+		return nil, fmt.Errorf(`%s not found`, workload)
+	}
+	if len(objects) > 1 {
+		c.objects[workload] = objects[1:]
+	}
+	return objects[0], nil
+}
+
+func (c *testClient) List(namespace string) ([]runtime.Object, error) {
+	var objects []runtime.Object
+	for workload, o := range c.objects {
+		if workload.Namespace == namespace {
+			objects = append(objects, o[0])
+		}
+	}
+	return objects, nil
+}
+
+func (c *testClient) Pods(namespace string, selector *metav1.LabelSelector) ([]corev1.Pod, error) {
+	return c.pods, nil
+}
+
+func (c *testClient) ReplicaSets(namespace string, selector *metav1.LabelSelector) ([]appsv1.ReplicaSet, error) {
+	return c.replicaSets, nil
+}
+
+func (c *testClient) ControllerRevisions(namespace string, selector *metav1.LabelSelector) ([]appsv1.ControllerRevision, error) {
+	return c.revisions, nil
+}
+
+func deployment(available int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "app-uid"},
+		Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(2), Selector: &metav1.LabelSelector{}},
+		Status:     appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: available},
+	}
+}
+
+func TestWaiterWait(t *testing.T) {
+	app := Workload{Kind: KindDeployment, Namespace: "default", Name: "app"}
+	migrate := Workload{Kind: KindJob, Namespace: "default", Name: "migrate"}
+	client := &testClient{objects: map[Workload][]runtime.Object{
+		app: {deployment(0), deployment(1), deployment(1), deployment(2)},
+		migrate: {&batchv1.Job{Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+		}}}},
+	}}
+	out := &bytes.Buffer{}
+	waiter := &Waiter{Client: client, Interval: time.Millisecond, Out: out}
+	assert.NoError(t, waiter.Wait([]Workload{app, migrate}, time.Minute))
+	assert.Equal(t, `deployment/app: 0 of 2 updated replicas available
+job/migrate: ready, complete
+deployment/app: 1 of 2 updated replicas available
+deployment/app: ready, 2 of 2 replicas available
+`, out.String())
+}
+
+func TestWaiterWaitTimeout(t *testing.T) {
+	app := Workload{Kind: KindDeployment, Namespace: "default", Name: "app"}
+	client := &testClient{objects: map[Workload][]runtime.Object{app: {deployment(1)}}}
+	waiter := &Waiter{Client: client, Interval: time.Millisecond}
+	err := waiter.Wait([]Workload{app}, 10*time.Millisecond)
+	assert.EqualError(t, err, "timed out after 10ms waiting for deployment/app")
+}
+
+// replicaSet returns a ReplicaSet of a Deployment with a revision and pod-template-hash
+func replicaSet(d *appsv1.Deployment, revision, hash string) appsv1.ReplicaSet {
+	return appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:            d.Name + "-" + hash,
+		Labels:          map[string]string{podTemplateHashLabel: hash},
+		Annotations:     map[string]string{revisionAnnotation: revision},
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+	}}
+}
+
+// pod returns a pod with labels, crash looping if crashing is set
+func pod(name string, crashing bool, labels map[string]string) corev1.Pod {
+	state := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	if crashing {
+		state = corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 20s"}}
+	}
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", State: state}}},
+	}
+}
+
+func TestWaiterWaitCrashLoopBackOff(t *testing.T) {
+	app := Workload{Kind: KindDeployment, Namespace: "default", Name: "app"}
+	d := deployment(1)
+	client := &testClient{
+		objects:     map[Workload][]runtime.Object{app: {d}},
+		pods:        []corev1.Pod{pod("app-2", true, map[string]string{podTemplateHashLabel: "new"})},
+		replicaSets: []appsv1.ReplicaSet{replicaSet(d, "2", "new")},
+	}
+	waiter := &Waiter{Client: client, Interval: time.Millisecond}
+	err := waiter.Wait([]Workload{app}, time.Minute)
+	assert.EqualError(t, err, `deployment/app: pod "app-2" container "app" is in CrashLoopBackOff: back-off 20s`)
+}
+
+func TestWaiterWaitIgnoresOldRevision(t *testing.T) {
+	app := Workload{Kind: KindDeployment, Namespace: "default", Name: "app"}
+	d := deployment(1)
+	client := &testClient{
+		objects: map[Workload][]runtime.Object{app: {d}},
+		pods: []corev1.Pod{
+			pod("app-old", true, map[string]string{podTemplateHashLabel: "old"}),
+			pod("app-new", false, map[string]string{podTemplateHashLabel: "new"}),
+		},
+		replicaSets: []appsv1.ReplicaSet{replicaSet(d, "2", "new"), replicaSet(d, "1", "old")},
+	}
+	waiter := &Waiter{Client: client, Interval: time.Millisecond}
+	err := waiter.Wait([]Workload{app}, 10*time.Millisecond)
+	assert.EqualError(t, err, "timed out after 10ms waiting for deployment/app", "the crashing pod is of the previous revision")
+}
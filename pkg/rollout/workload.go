@@ -0,0 +1,160 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rollout
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Workload kinds that are waited for
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+	KindJob         = "Job"
+)
+
+// Workload identifies a Deployment, StatefulSet, DaemonSet or Job.
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (w Workload) String() string {
+	return strings.ToLower(w.Kind) + "/" + w.Name
+}
+
+func supportedKind(kind string) bool {
+	switch kind {
+	case KindDeployment, KindStatefulSet, KindDaemonSet, KindJob:
+		return true
+	}
+	return false
+}
+
+// ReleaseWorkloads returns the workloads belonging to a Helm release in a
+// namespace, found by the annotations and labels Helm and charts put on them.
+// Jobs owned by CronJobs are left out, as they are not part of the rollout.
+func ReleaseWorkloads(client Client, namespace, release string) ([]Workload, error) {
+	objects, err := client.List(namespace)
+	if err != nil {
+		return nil, err
+	}
+	var workloads []Workload
+	for _, object := range objects {
+		var kind string
+		var meta metav1.ObjectMeta
+		switch o := object.(type) {
+		case *appsv1.Deployment:
+			kind, meta = KindDeployment, o.ObjectMeta
+		case *appsv1.StatefulSet:
+			kind, meta = KindStatefulSet, o.ObjectMeta
+		case *appsv1.DaemonSet:
+			kind, meta = KindDaemonSet, o.ObjectMeta
+		case *batchv1.Job:
+			if len(o.OwnerReferences) > 0 {
+				continue
+			}
+			kind, meta = KindJob, o.ObjectMeta
+		default:
+			continue
+		}
+		if belongsToRelease(meta, release) {
+			workloads = append(workloads, Workload{Kind: kind, Namespace: namespace, Name: meta.Name})
+		}
+	}
+	return workloads, nil
+}
+
+func belongsToRelease(meta metav1.ObjectMeta, release string) bool {
+	if name, ok := meta.Annotations["meta.helm.sh/release-name"]; ok {
+		return name == release
+	}
+	return meta.Labels["app.kubernetes.io/instance"] == release || meta.Labels["release"] == release
+}
+
+// ResourceFileWorkloads returns the workloads defined in resource files,
+// with namespace as the default namespace.
+func ResourceFileWorkloads(files []string, namespace string) ([]Workload, error) {
+	var workloads []Workload
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		found, err := parseWorkloads(data, namespace)
+		if err != nil {
+			return nil, fmt.Errorf(`%s: %v`, file, err)
+		}
+		workloads = append(workloads, found...)
+	}
+	return workloads, nil
+}
+
+type resource struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+	Items []resource `yaml:"items"`
+}
+
+func parseWorkloads(data []byte, namespace string) ([]Workload, error) {
+	var workloads []Workload
+	var add func(r resource)
+	add = func(r resource) {
+		if strings.HasSuffix(r.Kind, "List") {
+			for _, item := range r.Items {
+				add(item)
+			}
+			return
+		}
+		if !supportedKind(r.Kind) || r.Metadata.Name == "" {
+			return
+		}
+		workload := Workload{Kind: r.Kind, Namespace: r.Metadata.Namespace, Name: r.Metadata.Name}
+		if workload.Namespace == "" {
+			workload.Namespace = namespace
+		}
+		workloads = append(workloads, workload)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var r resource
+		err := decoder.Decode(&r)
+		if err == io.EOF {
+			return workloads, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		add(r)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rollout
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestReleaseWorkloads(t *testing.T) {
+	objectMeta := func(name string, annotations, labels map[string]string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations, Labels: labels}
+	}
+	client := &testClient{objects: map[Workload][]runtime.Object{
+		{Namespace: "default", Name: "web"}: {&appsv1.Deployment{
+			ObjectMeta: objectMeta("web", map[string]string{"meta.helm.sh/release-name": "app"}, nil),
+		}},
+		{Namespace: "default", Name: "db"}: {&appsv1.StatefulSet{
+			ObjectMeta: objectMeta("db", nil, map[string]string{"app.kubernetes.io/instance": "app"}),
+		}},
+		{Namespace: "default", Name: "other"}: {&appsv1.DaemonSet{
+			ObjectMeta: objectMeta("other", map[string]string{"meta.helm.sh/release-name": "other"}, map[string]string{"release": "app"}),
+		}},
+		{Namespace: "default", Name: "cron"}: {&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "cron", Labels: map[string]string{"release": "app"}, OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob"}}},
+		}},
+		{Namespace: "kube-system", Name: "web"}: {&appsv1.Deployment{
+			ObjectMeta: objectMeta("web", map[string]string{"meta.helm.sh/release-name": "app"}, nil),
+		}},
+	}}
+	workloads, err := ReleaseWorkloads(client, "default", "app")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Workload{
+		{Kind: KindDeployment, Namespace: "default", Name: "web"},
+		{Kind: KindStatefulSet, Namespace: "default", Name: "db"},
+	}, workloads)
+}
+
+func TestParseWorkloads(t *testing.T) {
+	workloads, err := parseWorkloads([]byte(`apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: batch/v1
+  kind: Job
+  metadata:
+    name: migrate
+    namespace: jobs
+`), "default")
+	require.NoError(t, err)
+	assert.Equal(t, []Workload{
+		{Kind: KindDeployment, Namespace: "default", Name: "web"},
+		{Kind: KindJob, Namespace: "jobs", Name: "migrate"},
+	}, workloads)
+	_, err = parseWorkloads([]byte("kind: [Deployment"), "default")
+	assert.Error(t, err)
+}
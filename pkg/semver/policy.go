@@ -0,0 +1,152 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package semver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	mmsemver "github.com/Masterminds/semver/v3"
+)
+
+// Policy selects which versions are upgrades of the current one.
+type Policy struct {
+	Track   string
+	Exclude []string
+	// Prerelease is one of the prerelease policies. The default is "any" with
+	// Channels, "same-series" when the current version is a prerelease, and
+	// "none" otherwise.
+	Prerelease string
+	// Channels limits prereleases to the listed channels, see Channel.
+	Channels []string
+}
+
+// IsKnownPrerelease returns whether prerelease is one of the prerelease policies.
+func IsKnownPrerelease(prerelease string) bool {
+	switch prerelease {
+	case PrereleaseNone, PrereleaseSameSeries, PrereleaseAny:
+		return true
+	}
+	return false
+}
+
+// Channel returns the channel of a prerelease version, which is its first
+// prerelease identifier without trailing digits, like "rc" for "1.3.0-rc.1"
+// and "beta" for "2.0.0-beta2". It is empty for stable versions.
+func Channel(version *mmsemver.Version) string {
+	identifier := strings.SplitN(version.Prerelease(), ".", 2)[0]
+	return strings.ToLower(strings.TrimRight(identifier, "0123456789"))
+}
+
+// BestUpgrade returns the highest candidate above current that the policy
+// allows.
+func (p Policy) BestUpgrade(current *mmsemver.Version, candidates []*mmsemver.Version) (*mmsemver.Version, error) {
+	spec, err := p.constraint(current)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]*mmsemver.Version, 0)
+	for _, c := range candidates {
+		if c.GreaterThan(current) && spec.Check(stable(c)) && p.AllowsPrerelease(current, c) && !IsExcluded(c.Original(), p.Exclude) {
+			filtered = append(filtered, c)
+		}
+	}
+	sort.Sort(mmsemver.Collection(filtered))
+	if len(filtered) > 0 {
+		return filtered[len(filtered)-1], nil
+	}
+	return nil, fmt.Errorf(`found no versions >%s`, current.String())
+}
+
+func (p Policy) IsWantedUpgrade(current, candidate *mmsemver.Version) bool {
+	_, err := p.BestUpgrade(current, []*mmsemver.Version{candidate})
+	return err == nil
+}
+
+// AllowsPrerelease tells whether the prerelease policy allows a candidate
+// version. Stable versions are always allowed. The current version may be
+// nil, allowing no prereleases with "same-series".
+func (p Policy) AllowsPrerelease(current, candidate *mmsemver.Version) bool {
+	if candidate.Prerelease() == "" {
+		return true
+	}
+	if len(p.Channels) > 0 && !channelInSlice(Channel(candidate), p.Channels) {
+		return false
+	}
+	switch p.prerelease(current) {
+	case PrereleaseAny:
+		return true
+	case PrereleaseSameSeries:
+		return current != nil && current.Major() == candidate.Major() && current.Minor() == candidate.Minor()
+	}
+	return false
+}
+
+func (p Policy) prerelease(current *mmsemver.Version) string {
+	switch {
+	case p.Prerelease != "":
+		return p.Prerelease
+	case len(p.Channels) > 0:
+		return PrereleaseAny
+	case current != nil && current.Prerelease() != "":
+		return PrereleaseSameSeries
+	}
+	return PrereleaseNone
+}
+
+// constraint returns the versions within the track. Prerelease versions are
+// checked against it without their prerelease, see stable.
+func (p Policy) constraint(current *mmsemver.Version) (*mmsemver.Constraints, error) {
+	switch p.Track {
+	case TrackPatchLevel:
+		nextMinor := (*current).IncMinor()
+		return mmsemver.NewConstraint(">" + current.String() + ", <" + nextMinor.String())
+	case TrackMinorVersion:
+		nextMajor := (*current).IncMajor()
+		return mmsemver.NewConstraint(">" + current.String() + ", <" + nextMajor.String())
+	case TrackMajorVersion:
+		return mmsemver.NewConstraint(">" + current.String())
+	}
+	if IsKnownTrack(p.Track) || ValidateTrack(p.Track) != nil {
+		return nil, fmt.Errorf(`unknown "track": %q`, p.Track)
+	}
+	return mmsemver.NewConstraint(p.Track)
+}
+
+// stable returns a version without its prerelease and metadata, so
+// "1.3.1-rc.1" is within the same tracks as "1.3.1", leaving it to the
+// prerelease policy whether prereleases are upgrades.
+func stable(version *mmsemver.Version) *mmsemver.Version {
+	if version.Prerelease() == "" && version.Metadata() == "" {
+		return version
+	}
+	result, _ := version.SetPrerelease("")
+	result, _ = result.SetMetadata("")
+	return &result
+}
+
+func channelInSlice(channel string, channels []string) bool {
+	for _, c := range channels {
+		if strings.ToLower(c) == channel {
+			return true
+		}
+	}
+	return false
+}
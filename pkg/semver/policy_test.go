@@ -0,0 +1,79 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package semver
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyPrerelease(t *testing.T) {
+	candidates := []string{"1.2.4", "1.2.5-rc.1", "1.3.0-beta.2", "1.3.0-rc.1", "2.0.0-alpha1"}
+	for i, tc := range []struct {
+		current string
+		policy  Policy
+		best    string
+	}{
+		{"1.2.3", Policy{Track: TrackMajorVersion}, "1.2.4"},
+		{"1.2.3", Policy{Track: TrackMajorVersion, Prerelease: PrereleaseNone}, "1.2.4"},
+		{"1.2.3", Policy{Track: TrackMajorVersion, Prerelease: PrereleaseSameSeries}, "1.2.5-rc.1"},
+		{"1.2.3", Policy{Track: TrackMajorVersion, Prerelease: PrereleaseAny}, "2.0.0-alpha1"},
+		{"1.2.3", Policy{Track: TrackMinorVersion, Prerelease: PrereleaseAny}, "1.3.0-rc.1"},
+		{"1.2.3", Policy{Track: TrackPatchLevel, Prerelease: PrereleaseAny}, "1.2.5-rc.1"},
+		{"1.2.3", Policy{Track: TrackMajorVersion, Channels: []string{"beta"}}, "1.3.0-beta.2"},
+		{"1.2.3", Policy{Track: TrackMajorVersion, Channels: []string{"rc", "Alpha"}}, "2.0.0-alpha1"},
+		{"1.2.3", Policy{Track: ">=1.2 <2", Prerelease: PrereleaseAny}, "1.3.0-rc.1"},
+		{"1.2.5-rc.0", Policy{Track: TrackPatchLevel}, "1.2.5-rc.1"},
+		{"1.2.5-rc.0", Policy{Track: TrackMajorVersion, Prerelease: PrereleaseNone}, ""},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			current, err := Parse(tc.current)
+			require.NoError(t, err)
+			versions := make([]*semver.Version, len(candidates))
+			for j, c := range candidates {
+				versions[j], _ = Parse(c)
+			}
+			best, err := tc.policy.BestUpgrade(current, versions)
+			if tc.best == "" {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.best, best.Original())
+		})
+	}
+}
+
+func TestChannel(t *testing.T) {
+	for version, channel := range map[string]string{
+		"1.3.0-rc.1":   "rc",
+		"2.0.0-beta2":  "beta",
+		"2.0.0-Alpha":  "alpha",
+		"1.0.0":        "",
+		"1.0.0+build1": "",
+	} {
+		v, err := Parse(version)
+		require.NoError(t, err)
+		assert.Equal(t, channel, Channel(v), version)
+	}
+}
@@ -20,8 +20,9 @@ package semver
 
 import (
 	"fmt"
-	mmsemver "github.com/Masterminds/semver"
-	"sort"
+	"strings"
+
+	mmsemver "github.com/Masterminds/semver/v3"
 )
 
 const (
@@ -31,6 +32,87 @@ const (
 	TrackNewest       = "Newest"
 )
 
+// Prerelease policies, selecting which prerelease versions are upgrades.
+const (
+	// PrereleaseNone allows stable versions only
+	PrereleaseNone = "none"
+	// PrereleaseSameSeries allows prereleases with the same major and minor
+	// version as the current version, like "1.3.1-rc.1" for "1.3.0"
+	PrereleaseSameSeries = "same-series"
+	// PrereleaseAny allows all prereleases
+	PrereleaseAny = "any"
+)
+
+// IsKnownTrack returns whether track is one of the named "track" values.
+func IsKnownTrack(track string) bool {
+	switch track {
+	case TrackPatchLevel, TrackMinorVersion, TrackMajorVersion, TrackNewest:
+		return true
+	}
+	return false
+}
+
+// IsConstraintTrack returns whether track is a version constraint, like
+// ">=2.3 <3" or "~1.4", rather than one of the named tracks.
+func IsConstraintTrack(track string) bool {
+	return track != "" && !IsKnownTrack(track)
+}
+
+// ValidateTrack checks that track is either a named track or a valid
+// version constraint.
+func ValidateTrack(track string) error {
+	if IsKnownTrack(track) {
+		return nil
+	}
+	if _, err := mmsemver.NewConstraint(track); err != nil {
+		return fmt.Errorf(`unknown "track": %q`, track)
+	}
+	return nil
+}
+
+// ValidateExclude checks an entry of an "exclude" list, which is either a
+// version (or tag) or a range of versions like ">=1.4.0 <1.4.3".
+func ValidateExclude(entry string) error {
+	if strings.TrimSpace(entry) == "" {
+		return fmt.Errorf(`empty "exclude" entry`)
+	}
+	if !strings.ContainsAny(entry, "<>=!~^*|, ") {
+		return nil
+	}
+	if _, err := mmsemver.NewConstraint(entry); err != nil {
+		return fmt.Errorf(`invalid "exclude" range %q: %v`, entry, err)
+	}
+	return nil
+}
+
+// IsExcluded returns whether a version or tag matches any entry of an
+// "exclude" list. Entries that are versions match equal versions, so "1.2"
+// excludes both "1.2.0" and "v1.2". Invalid ranges never match.
+func IsExcluded(version string, exclude []string) bool {
+	if len(exclude) == 0 {
+		return false
+	}
+	parsed, _ := Parse(version)
+	for _, entry := range exclude {
+		if entry == version {
+			return true
+		}
+		if parsed == nil {
+			continue
+		}
+		if excluded, err := Parse(entry); err == nil {
+			if parsed.Equal(excluded) {
+				return true
+			}
+			continue
+		}
+		if ranges, err := mmsemver.NewConstraint(entry); err == nil && ranges.Check(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 func IsSemver(version string) bool {
 	_, err := mmsemver.NewVersion(Normalize(version))
 	return err == nil
@@ -47,38 +129,13 @@ func Parse(version string) (*mmsemver.Version, error) {
 	return mmsemver.NewVersion(Normalize(version))
 }
 
-func BestUpgrade(current *mmsemver.Version, candidates []*mmsemver.Version, track string) (*mmsemver.Version, error) {
-	var spec *mmsemver.Constraints
-	var err error
-	switch track {
-	case TrackPatchLevel:
-		nextMinor := (*current).IncMinor()
-		spec, err = mmsemver.NewConstraint(">" + current.String() + ", <" + nextMinor.String())
-	case TrackMinorVersion:
-		nextMajor := (*current).IncMajor()
-		spec, err = mmsemver.NewConstraint(">" + current.String() + ", <" + nextMajor.String())
-	case TrackMajorVersion:
-		spec, err = mmsemver.NewConstraint(">" + current.String())
-	default:
-		return nil, fmt.Errorf(`unknown "track": %q`, track)
-	}
-	if err != nil {
-		return nil, err
-	}
-	filtered := make([]*mmsemver.Version, 0)
-	for _, c := range candidates {
-		if spec.Check(c) {
-			filtered = append(filtered, c)
-		}
-	}
-	sort.Sort(mmsemver.Collection(filtered))
-	if len(filtered) > 0 {
-		return filtered[len(filtered)-1], nil
-	}
-	return nil, fmt.Errorf(`found no versions >%s`, current.String())
+// BestUpgrade returns the highest candidate above current that is within
+// track, skipping versions matching the exclude list, with the default
+// prerelease policy.
+func BestUpgrade(current *mmsemver.Version, candidates []*mmsemver.Version, track string, exclude []string) (*mmsemver.Version, error) {
+	return Policy{Track: track, Exclude: exclude}.BestUpgrade(current, candidates)
 }
 
-func IsWantedUpgrade(current, candidate *mmsemver.Version, track string) bool {
-	_, err := BestUpgrade(current, []*mmsemver.Version{candidate}, track)
-	return err == nil
+func IsWantedUpgrade(current, candidate *mmsemver.Version, track string, exclude []string) bool {
+	return Policy{Track: track, Exclude: exclude}.IsWantedUpgrade(current, candidate)
 }
@@ -20,7 +20,7 @@ package semver
 
 import (
 	"fmt"
-	"github.com/Masterminds/semver"
+	"github.com/Masterminds/semver/v3"
 	"github.com/stretchr/testify/assert"
 	"strconv"
 	"testing"
@@ -46,6 +46,10 @@ func TestBestUpgrade(t *testing.T) {
 		{"1.0.0", TrackMajorVersion, []string{"0.9.0", "2.0.0", "1.0.1", "1.2.0"}, "2.0.0", nil},
 		{"1.0.0", TrackPatchLevel, []string{"0.9.0", "2.0.0", "1.0.1", "1.2.0"}, "1.0.1", nil},
 		{"1.0.3", TrackPatchLevel, []string{"0.9.0", "2.0.0", "1.0.1", "1.2.0"}, "", fmt.Errorf(`found no versions >1.0.3`)},
+		{"2.2.0", ">=2.3 <3", []string{"2.2.1", "2.3.0", "2.9.1", "3.0.0", "3.1.0"}, "2.9.1", nil},
+		{"1.4.0", "~1.4", []string{"1.3.9", "1.4.2", "1.5.0"}, "1.4.2", nil},
+		{"5.0.0", "^5", []string{"4.9.0", "5.3.1", "6.0.0"}, "5.3.1", nil},
+		{"3.1.0", ">=2.3 <3", []string{"2.9.1", "3.0.0"}, "", fmt.Errorf(`found no versions >3.1.0`)},
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
 			current, _ := Parse(tc.current)
@@ -54,7 +58,7 @@ func TestBestUpgrade(t *testing.T) {
 			for i, c := range tc.candidates {
 				candidates[i], _ = Parse(c)
 			}
-			best, err := BestUpgrade(current, candidates, tc.track)
+			best, err := BestUpgrade(current, candidates, tc.track, nil)
 			if tc.error != nil {
 				assert.Error(t, err)
 				assert.Equal(t, tc.error, err)
@@ -65,7 +69,7 @@ func TestBestUpgrade(t *testing.T) {
 		})
 	}
 	v1, _ := Parse("v1.0")
-	_, err := BestUpgrade(v1, []*semver.Version{v1}, "UnknownTrack")
+	_, err := BestUpgrade(v1, []*semver.Version{v1}, "UnknownTrack", nil)
 	assert.Error(t, err)
 	assert.Equal(t, `unknown "track": "UnknownTrack"`, err.Error())
 }
@@ -82,12 +86,56 @@ func TestIsWantedUpgrade(t *testing.T) {
 		{"1.0", "1.1", TrackMinorVersion, true},
 		{"1.0", "1.0", TrackMinorVersion, false},
 		{"1.0", "1.0", "UnknownTrack", false},
+		{"1.0", "1.4.1", "~1.4", true},
+		{"1.0", "1.5.0", "~1.4", false},
 	} {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
 			current, _ := Parse(tc.current)
 			candidate, _ := Parse(tc.candidate)
-			wanted := IsWantedUpgrade(current, candidate, tc.track)
+			wanted := IsWantedUpgrade(current, candidate, tc.track, nil)
 			assert.Equal(t, tc.isWanted, wanted)
 		})
 	}
 }
+
+func TestBestUpgradeExclude(t *testing.T) {
+	current, _ := Parse("1.4.0")
+	candidates := make([]*semver.Version, 0)
+	for _, c := range []string{"1.4.1", "1.4.2", "1.4.3", "1.5.0"} {
+		v, _ := Parse(c)
+		candidates = append(candidates, v)
+	}
+	best, err := BestUpgrade(current, candidates, TrackPatchLevel, []string{"1.4.3"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.4.2", best.Original())
+	best, err = BestUpgrade(current, candidates, TrackMinorVersion, []string{">=1.4.2 <2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.4.1", best.Original())
+	_, err = BestUpgrade(current, candidates, "~1.4", []string{"1.4.x"})
+	assert.Error(t, err)
+}
+
+func TestValidateTrack(t *testing.T) {
+	for _, track := range []string{TrackPatchLevel, TrackNewest, ">=2.3 <3", "~1.4", "^5", "1.2.x || >=2.1"} {
+		assert.NoError(t, ValidateTrack(track), track)
+	}
+	for _, track := range []string{"Sometimes", ">=2.3 <"} {
+		assert.Error(t, ValidateTrack(track), track)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	for _, entry := range []string{"1.2.3", "v1.2", "broken-build", ">=1.4 <1.4.3", "1.4.x"} {
+		assert.NoError(t, ValidateExclude(entry), entry)
+	}
+	for _, entry := range []string{"", ">=1.4 <", "1.2 || >"} {
+		assert.Error(t, ValidateExclude(entry), entry)
+	}
+	exclude := []string{"1.2", "broken-build", ">=1.4 <1.4.3"}
+	assert.True(t, IsExcluded("v1.2.0", exclude))
+	assert.True(t, IsExcluded("broken-build", exclude))
+	assert.True(t, IsExcluded("1.4.2", exclude))
+	assert.False(t, IsExcluded("1.4.3", exclude))
+	assert.False(t, IsExcluded("1.2.1", exclude))
+	assert.False(t, IsExcluded("1.2.1", nil))
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package state
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kubecd/kubecd/pkg/dag"
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// ApplyOptions tell ApplyChanged which releases to apply, and how.
+type ApplyOptions struct {
+	// Releases limits the releases to apply in each environment, by
+	// environment name, to all of them if none are given
+	Releases map[string][]string
+	// Force applies releases even if their fingerprints did not change
+	Force bool
+	// Parallelism is how many releases may be applied at a time
+	Parallelism int
+	// Apply applies a release
+	Apply func(release *model.Release) error
+	// Skipped, if set, is called with the releases not applied as they did not change
+	Skipped func(release *model.Release)
+}
+
+// ApplyResult lists the releases ApplyChanged tried to apply, and how it went.
+type ApplyResult struct {
+	// Releases are the changed releases, in the order of their environments
+	Releases []*model.Release
+	// Errors are the errors of releases that failed, by release ID
+	Errors map[string]error
+}
+
+// ApplyChanged applies the releases of envs whose fingerprints changed since
+// they were stored in store, after the releases they depend on. Releases whose
+// fingerprints can not be computed fail like releases failing to apply, and
+// the releases depending on a failed release are not applied. The fingerprints
+// of the applied releases are then saved, leaving out releases no longer in
+// their environment.
+func ApplyChanged(store Store, config *model.KubeCDConfig, envs []*model.Environment, options ApplyOptions) (*ApplyResult, error) {
+	stored := make(map[string]Fingerprints)
+	current := make(map[*model.Release]string)
+	fingerprintErrs := make(map[*model.Release]error)
+	result := &ApplyResult{}
+	for _, env := range envs {
+		limit := options.Releases[env.Name]
+		for _, releaseName := range limit {
+			if env.GetRelease(releaseName) == nil {
+				return nil, fmt.Errorf(`env %q: release not found: %q`, env.Name, releaseName)
+			}
+		}
+		var err error
+		if stored[env.Name], err = store.Load(env); err != nil {
+			return nil, err
+		}
+		if stored[env.Name] == nil {
+			stored[env.Name] = Fingerprints{}
+		}
+		for _, release := range env.AllReleases() {
+			if len(limit) > 0 && !stringInSlice(release.Name, limit) {
+				continue
+			}
+			fingerprint, err := helm.Fingerprint(release)
+			if err != nil {
+				fingerprintErrs[release] = err
+			} else if !options.Force && stored[env.Name][release.Name] == fingerprint {
+				if options.Skipped != nil {
+					options.Skipped(release)
+				}
+				continue
+			}
+			current[release] = fingerprint
+			result.Releases = append(result.Releases, release)
+		}
+	}
+	var mu sync.Mutex
+	changed := make(map[string]bool)
+	nodes, err := dag.ReleaseNodes(config, result.Releases, func(release *model.Release) error {
+		if err := fingerprintErrs[release]; err != nil {
+			return err
+		}
+		if err := options.Apply(release); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		stored[release.Environment.Name][release.Name] = current[release]
+		changed[release.Environment.Name] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Errors = dag.Run(nodes, options.Parallelism)
+	for _, env := range envs {
+		if !changed[env.Name] {
+			continue
+		}
+		fingerprints := Fingerprints{}
+		for _, release := range env.AllReleases() {
+			if fingerprint, found := stored[env.Name][release.Name]; found {
+				fingerprints[release.Name] = fingerprint
+			}
+		}
+		if err = store.Save(env, fingerprints); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func stringInSlice(str string, slice []string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package state
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/dag"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+func TestApplyChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-state")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	writeFile := func(name, content string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	writeFile("environments.yaml", `
+clusters:
+  - name: test
+    provider:
+      minikube: {}
+environments:
+  - name: test
+    clusterName: test
+    kubeNamespace: default
+    releasesFiles: [releases.yaml]
+`)
+	writeFile("releases.yaml", `
+releases:
+  - name: crds
+    resourceFiles: [crds.yaml]
+  - name: operator
+    resourceFiles: [operator.yaml]
+    dependsOn: [crds]
+  - name: app
+    resourceFiles: [app.yaml]
+    dependsOn: [operator]
+  - name: web
+    resourceFiles: [web.yaml]
+`)
+	for _, name := range []string{"crds", "operator", "app", "web"} {
+		writeFile(name+".yaml", "kind: Deployment")
+	}
+	store := &FileStore{Path: filepath.Join(dir, "state.json")}
+	failed := errors.New("failed")
+	failing := ""
+	apply := func(options ApplyOptions) (*ApplyResult, []string, []string) {
+		config, err := model.NewConfigFromFile(filepath.Join(dir, "environments.yaml"))
+		require.NoError(t, err)
+		var mu sync.Mutex
+		var applied, skipped []string
+		options.Parallelism = 2
+		options.Apply = func(release *model.Release) error {
+			if release.Name == failing {
+				return failed
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			applied = append(applied, release.Name)
+			return nil
+		}
+		options.Skipped = func(release *model.Release) {
+			skipped = append(skipped, release.Name)
+		}
+		result, err := ApplyChanged(store, config, config.Environments, options)
+		require.NoError(t, err)
+		return result, applied, skipped
+	}
+	releaseNames := func(result *ApplyResult) []string {
+		var names []string
+		for _, release := range result.Releases {
+			names = append(names, release.Name)
+		}
+		return names
+	}
+
+	failing = "operator"
+	result, applied, _ := apply(ApplyOptions{})
+	assert.Equal(t, []string{"crds", "operator", "app", "web"}, releaseNames(result))
+	assert.ElementsMatch(t, []string{"crds", "web"}, applied)
+	assert.Equal(t, map[string]error{
+		"test/operator": failed,
+		"test/app":      dag.DependencyFailedError{Dependency: "test/operator"},
+	}, result.Errors)
+	stored, err := store.Load(&model.Environment{Name: "test"})
+	require.NoError(t, err)
+	assert.Len(t, stored, 2)
+	assert.Contains(t, stored, "crds")
+	assert.Contains(t, stored, "web")
+
+	failing = ""
+	result, applied, skipped := apply(ApplyOptions{})
+	assert.Equal(t, []string{"operator", "app"}, applied, "failed releases are applied again")
+	assert.Equal(t, []string{"crds", "web"}, skipped)
+	assert.Empty(t, result.Errors)
+
+	result, _, _ = apply(ApplyOptions{})
+	assert.Empty(t, result.Releases)
+	result, _, _ = apply(ApplyOptions{Force: true})
+	assert.Equal(t, []string{"crds", "operator", "app", "web"}, releaseNames(result), "forced")
+
+	writeFile("web.yaml", "kind: StatefulSet")
+	result, _, _ = apply(ApplyOptions{Releases: map[string][]string{"test": {"app"}}})
+	assert.Empty(t, result.Releases, "only app")
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "web.yaml")))
+	result, applied, _ = apply(ApplyOptions{})
+	assert.Equal(t, []string{"web"}, releaseNames(result))
+	assert.Empty(t, applied, "the fingerprint of web can not be computed")
+	assert.Error(t, result.Errors["test/web"])
+
+	config, err := model.NewConfigFromFile(filepath.Join(dir, "environments.yaml"))
+	require.NoError(t, err)
+	_, err = ApplyChanged(store, config, config.Environments, ApplyOptions{Releases: map[string][]string{"test": {"db"}}})
+	assert.EqualError(t, err, `env "test": release not found: "db"`)
+}
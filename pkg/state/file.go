@@ -0,0 +1,75 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// FileStore stores fingerprints in a local JSON file, by environment name.
+type FileStore struct {
+	Path string
+}
+
+func (s *FileStore) Load(env *model.Environment) (Fingerprints, error) {
+	all, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	if fingerprints, found := all[env.Name]; found {
+		return fingerprints, nil
+	}
+	return Fingerprints{}, nil
+}
+
+func (s *FileStore) Save(env *model.Environment, fingerprints Fingerprints) error {
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	all[env.Name] = fingerprints
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(s.Path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf(`could not write state file: %v`, err)
+	}
+	return nil
+}
+
+func (s *FileStore) read() (map[string]Fingerprints, error) {
+	all := make(map[string]Fingerprints)
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf(`could not read state file: %v`, err)
+	}
+	if err = json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf(`invalid state file %s: %v`, s.Path, err)
+	}
+	return all, nil
+}
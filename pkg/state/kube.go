@@ -0,0 +1,129 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package state
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kubecd/kubecd/pkg/exec"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// DefaultObjectName is the prefix of the name of the ConfigMap or Secret
+// holding the fingerprints of an environment
+const DefaultObjectName = "kcd-fingerprints"
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// runner is replaced in tests
+var runner exec.Runner = exec.RealRunner{}
+
+// KubeStore stores fingerprints in a ConfigMap or Secret per environment in
+// the environment's namespace, with a key per release, using kubectl. The
+// object is named after the environment, so that environments sharing a
+// namespace keep their own fingerprints.
+type KubeStore struct {
+	// Kind is "configmap" or "secret"
+	Kind string
+	// Name is the prefix of the object names, followed by the environment name
+	Name string
+}
+
+// objectName returns the name of the object holding an environment's fingerprints
+func (s *KubeStore) objectName(env *model.Environment) string {
+	return s.Name + "-" + strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(env.Name), "-"), "-")
+}
+
+type kubeObject struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   kubeMetadata      `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string]string `json:"data"`
+}
+
+type kubeMetadata struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+func (s *KubeStore) Load(env *model.Environment) (Fingerprints, error) {
+	name := s.objectName(env)
+	output, err := runner.Run("kubectl", "--context", model.KubeContextName(env.Name), "--namespace", env.KubeNamespace,
+		"get", s.Kind, name, "--ignore-not-found", "--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf(`env %q: could not get %s %q: %v`, env.Name, s.Kind, name, err)
+	}
+	fingerprints := Fingerprints{}
+	if len(output) == 0 {
+		return fingerprints, nil
+	}
+	var object kubeObject
+	if err = json.Unmarshal(output, &object); err != nil {
+		return nil, fmt.Errorf(`env %q: invalid %s %q: %v`, env.Name, s.Kind, name, err)
+	}
+	for release, value := range object.Data {
+		if s.Kind == KindSecret {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf(`env %q: invalid %s %q: %v`, env.Name, s.Kind, name, err)
+			}
+			value = string(decoded)
+		}
+		fingerprints[release] = value
+	}
+	return fingerprints, nil
+}
+
+func (s *KubeStore) Save(env *model.Environment, fingerprints Fingerprints) error {
+	name := s.objectName(env)
+	object := kubeObject{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata: kubeMetadata{
+			Name:      name,
+			Namespace: env.KubeNamespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "kcd"},
+		},
+		Data: make(map[string]string, len(fingerprints)),
+	}
+	for release, fingerprint := range fingerprints {
+		object.Data[release] = fingerprint
+	}
+	if s.Kind == KindSecret {
+		object.Kind = "Secret"
+		object.Type = "Opaque"
+		for release, fingerprint := range fingerprints {
+			object.Data[release] = base64.StdEncoding.EncodeToString([]byte(fingerprint))
+		}
+	}
+	manifest, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	if _, err = runner.RunWithInput(manifest, "kubectl", "--context", model.KubeContextName(env.Name), "apply", "--filename", "-"); err != nil {
+		return fmt.Errorf(`env %q: could not save %s %q: %v`, env.Name, s.Kind, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package state stores the fingerprints of applied releases, so that
+// releases whose inputs did not change since they were applied can be
+// skipped.
+package state
+
+import (
+	"fmt"
+
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// Kinds of Store.
+const (
+	KindConfigMap = "configmap"
+	KindSecret    = "secret"
+	KindFile      = "file"
+	KindNone      = "none"
+)
+
+// Fingerprints are the fingerprints of applied releases, by release name.
+type Fingerprints map[string]string
+
+// Store loads and saves the fingerprints of the releases applied in an
+// environment.
+type Store interface {
+	Load(env *model.Environment) (Fingerprints, error)
+	// Save replaces the stored fingerprints of the environment
+	Save(env *model.Environment, fingerprints Fingerprints) error
+}
+
+// NewStore returns a store of the given kind, with file being the state file
+// of the "file" kind. The "none" kind stores nothing, so that all releases
+// are applied.
+func NewStore(kind, file string) (Store, error) {
+	switch kind {
+	case KindConfigMap, KindSecret:
+		return &KubeStore{Kind: kind, Name: DefaultObjectName}, nil
+	case KindFile:
+		if file == "" {
+			return nil, fmt.Errorf(`a state file is required`)
+		}
+		return &FileStore{Path: file}, nil
+	case KindNone:
+		return noneStore{}, nil
+	}
+	return nil, fmt.Errorf(`unknown state store %q, must be one of %q, %q, %q or %q`, kind, KindConfigMap, KindSecret, KindFile, KindNone)
+}
+
+type noneStore struct{}
+
+func (noneStore) Load(env *model.Environment) (Fingerprints, error) {
+	return Fingerprints{}, nil
+}
+
+func (noneStore) Save(env *model.Environment, fingerprints Fingerprints) error {
+	return nil
+}
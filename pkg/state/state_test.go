@@ -0,0 +1,135 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/exec"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// TestHelperProcess is required boilerplate (one per package) for using exec.TestRunner
+func TestHelperProcess(t *testing.T) {
+	exec.InsideHelperProcess()
+}
+
+var testEnv = &model.Environment{Name: "test", KubeNamespace: "apps"}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-state")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	store, err := NewStore(KindFile, filepath.Join(dir, "state.json"))
+	require.NoError(t, err)
+	fingerprints, err := store.Load(testEnv)
+	require.NoError(t, err)
+	assert.Empty(t, fingerprints)
+
+	require.NoError(t, store.Save(testEnv, Fingerprints{"app": "1234"}))
+	require.NoError(t, store.Save(&model.Environment{Name: "prod"}, Fingerprints{"app": "5678"}))
+	fingerprints, err = store.Load(testEnv)
+	require.NoError(t, err)
+	assert.Equal(t, Fingerprints{"app": "1234"}, fingerprints)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "state.json"), []byte("{"), 0644))
+	_, err = store.Load(testEnv)
+	assert.Error(t, err)
+}
+
+func TestKubeStore(t *testing.T) {
+	oldRunner := runner
+	defer func() { runner = oldRunner }()
+	for _, tc := range []struct {
+		kind     string
+		object   string
+		manifest string
+	}{
+		{
+			KindConfigMap,
+			`{"apiVersion": "v1", "kind": "ConfigMap", "data": {"app": "1234"}}`,
+			`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"kcd-fingerprints-test","namespace":"apps","labels":{"app.kubernetes.io/managed-by":"kcd"}},"data":{"app":"1234","db":"5678"}}`,
+		},
+		{
+			KindSecret,
+			`{"apiVersion": "v1", "kind": "Secret", "data": {"app": "MTIzNA=="}}`,
+			`{"apiVersion":"v1","kind":"Secret","metadata":{"name":"kcd-fingerprints-test","namespace":"apps","labels":{"app.kubernetes.io/managed-by":"kcd"}},"type":"Opaque","data":{"app":"MTIzNA==","db":"NTY3OA=="}}`,
+		},
+	} {
+		t.Run(tc.kind, func(t *testing.T) {
+			store, err := NewStore(tc.kind, "")
+			require.NoError(t, err)
+			runner = exec.TestRunner{
+				ExpectedCommand: []string{"kubectl", "--context", "env:test", "--namespace", "apps", "get", tc.kind, "kcd-fingerprints-test", "--ignore-not-found", "--output", "json"},
+				Output:          []byte(tc.object),
+			}
+			fingerprints, err := store.Load(testEnv)
+			require.NoError(t, err)
+			assert.Equal(t, Fingerprints{"app": "1234"}, fingerprints)
+
+			runner = exec.TestRunner{}
+			fingerprints, err = store.Load(testEnv)
+			require.NoError(t, err)
+			assert.Empty(t, fingerprints, "not found")
+
+			runner = exec.TestRunner{
+				ExpectedCommand: []string{"kubectl", "--context", "env:test", "apply", "--filename", "-"},
+				ExpectedInput:   []byte(tc.manifest),
+			}
+			assert.NoError(t, store.Save(testEnv, Fingerprints{"app": "1234", "db": "5678"}))
+
+			runner = exec.TestRunner{ExitCode: 1}
+			assert.Error(t, store.Save(testEnv, Fingerprints{}))
+		})
+	}
+	_, err := NewStore("database", "")
+	assert.EqualError(t, err, `unknown state store "database", must be one of "configmap", "secret", "file" or "none"`)
+}
+
+func TestKubeStoreSharedNamespace(t *testing.T) {
+	oldRunner := runner
+	defer func() { runner = oldRunner }()
+	store, err := NewStore(KindConfigMap, "")
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		env  *model.Environment
+		name string
+	}{
+		{testEnv, "kcd-fingerprints-test"},
+		{&model.Environment{Name: "Test_Canary", KubeNamespace: "apps"}, "kcd-fingerprints-test-canary"},
+	} {
+		env, name := tc.env, tc.name
+		runner = exec.TestRunner{
+			ExpectedCommand: []string{"kubectl", "--context", model.KubeContextName(env.Name), "apply", "--filename", "-"},
+			ExpectedInput:   []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"` + name + `","namespace":"apps","labels":{"app.kubernetes.io/managed-by":"kcd"}},"data":{"app":"1234"}}`),
+		}
+		assert.NoError(t, store.Save(env, Fingerprints{"app": "1234"}), env.Name)
+		runner = exec.TestRunner{
+			ExpectedCommand: []string{"kubectl", "--context", model.KubeContextName(env.Name), "--namespace", "apps", "get", KindConfigMap, name, "--ignore-not-found", "--output", "json"},
+		}
+		_, err = store.Load(env)
+		assert.NoError(t, err, env.Name)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package updates
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// ChartVersionIndex maps chart references ("repo/chart") to their available versions
+type ChartVersionIndex map[string][]string
+
+// ChartCreatedIndex maps chart references to the creation time of their versions
+type ChartCreatedIndex map[string]map[string]int64
+
+// BuildChartVersionIndexFromHelmRepos reads the index of every Helm repo used
+// by the releases in chartIndex (see ChartReleaseIndex).
+func BuildChartVersionIndexFromHelmRepos(kcdConfig *model.KubeCDConfig, chartIndex map[string][]*model.Release) (ChartVersionIndex, ChartCreatedIndex, error) {
+	versionIndex := ChartVersionIndex(make(map[string][]string))
+	createdIndex := ChartCreatedIndex(make(map[string]map[string]int64))
+	chartsInRepo := make(map[string][]string)
+	for chartRef := range chartIndex {
+		slashIndex := strings.IndexByte(chartRef, '/')
+		if slashIndex == -1 {
+			continue
+		}
+		repoName := chartRef[:slashIndex]
+		chartsInRepo[repoName] = append(chartsInRepo[repoName], chartRef[slashIndex+1:])
+	}
+	baseDir := filepath.Dir(kcdConfig.FromFile())
+	for _, repo := range kcdConfig.HelmRepos {
+		charts, found := chartsInRepo[repo.Name]
+		if !found {
+			continue
+		}
+		delete(chartsInRepo, repo.Name)
+		repoIndex, err := helm.LoadRepoIndex(repo, baseDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, chart := range charts {
+			versionIndex[repo.Name+"/"+chart] = repoIndex.Versions(chart)
+			createdIndex[repo.Name+"/"+chart] = repoIndex.Created(chart)
+		}
+	}
+	missingRepos := make([]string, 0, len(chartsInRepo))
+	for repoName := range chartsInRepo {
+		missingRepos = append(missingRepos, repoName)
+	}
+	sort.Strings(missingRepos)
+	for _, repoName := range missingRepos {
+		_, _ = fmt.Fprintf(os.Stderr, "WARNING: helm repo %q is not defined in helmRepos\n", repoName)
+	}
+	return versionIndex, createdIndex, nil
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package updates
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/kubecd/kubecd/pkg/exec"
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/image"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// runner is replaced in tests
+var runner exec.Runner = exec.RealRunner{}
+
+// ValueReader reads a value from the content of a file, returning "" if it
+// is not there.
+type ValueReader func(data []byte) string
+
+// LastChanged returns when the value read from a file last became value,
+// according to its git history, or the zero time if it is not committed.
+// Only the value read from each revision is compared, so other occurrences
+// of the same string in the file do not count as changes.
+func LastChanged(file, value string, read ValueReader) (time.Time, error) {
+	dir, base := filepath.Split(file)
+	if dir == "" {
+		dir = "."
+	}
+	output, err := runner.Run("git", "-C", dir, "log", "--format=%H %ct", "--", base)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(`could not read git history of %q: %v`, file, err)
+	}
+	var changed time.Time
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return time.Time{}, fmt.Errorf(`unexpected output from git log for %q: %q`, file, line)
+		}
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf(`unexpected output from git log for %q: %q`, file, line)
+		}
+		// fails if the file was deleted in this revision
+		data, err := runner.Run("git", "-C", dir, "show", fields[0]+":./"+base)
+		if err != nil || read(data) != value {
+			break
+		}
+		changed = time.Unix(seconds, 0)
+	}
+	return changed, nil
+}
+
+// tagReader reads an image tag from the source of an update, without any
+// digest pinned in the same value.
+func (u ImageUpdate) tagReader() ValueReader {
+	var read ValueReader
+	switch u.TagSource.Kind {
+	case helm.ValueSourceValues:
+		read = releaseValueReader(u.Release.Name, u.TagValue)
+	case helm.ValueSourceDefaultValues:
+		var envName string
+		if u.Release.Environment != nil {
+			envName = u.Release.Environment.Name
+		}
+		read = defaultValueReader(envName, u.TagValue)
+	default:
+		read = valuesFileReader(u.TagValue)
+	}
+	return func(data []byte) string {
+		tag, _ := image.SplitTagDigest(read(data))
+		return tag
+	}
+}
+
+// releaseValueReader reads an inline value of a release from a releases file
+func releaseValueReader(releaseName, key string) ValueReader {
+	return func(data []byte) string {
+		if release := readRelease(data, releaseName); release != nil {
+			return chartValue(release.Values, key)
+		}
+		return ""
+	}
+}
+
+// defaultValueReader reads a default value of an environment from an environments file
+func defaultValueReader(envName, key string) ValueReader {
+	return func(data []byte) string {
+		var config model.KubeCDConfig
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return ""
+		}
+		for _, env := range config.Environments {
+			if env.Name == envName {
+				return chartValue(env.DefaultValues, key)
+			}
+		}
+		return ""
+	}
+}
+
+// valuesFileReader reads a value from a Helm values file. Scalars are read as
+// they are written, so that unquoted tags like 1.10 or 20240101 are read too.
+func valuesFileReader(key string) ValueReader {
+	path := strings.Split(key, ".")
+	return func(data []byte) string {
+		var doc yamlv3.Node
+		if err := yamlv3.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+			return ""
+		}
+		node := doc.Content[0]
+		for _, name := range path {
+			if node = yamlMapValue(node, name); node == nil {
+				return ""
+			}
+		}
+		if node.Kind != yamlv3.ScalarNode || node.Tag == "!!null" {
+			return ""
+		}
+		return node.Value
+	}
+}
+
+// yamlMapValue returns the value of a key in a mapping node, following aliases
+func yamlMapValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			if value.Kind == yamlv3.AliasNode {
+				value = value.Alias
+			}
+			return value
+		}
+	}
+	return nil
+}
+
+// chartVersionReader reads the chart version of a release from a releases file
+func chartVersionReader(releaseName string) ValueReader {
+	return func(data []byte) string {
+		if release := readRelease(data, releaseName); release != nil && release.Chart != nil && release.Chart.Version != nil {
+			return *release.Chart.Version
+		}
+		return ""
+	}
+}
+
+func readRelease(data []byte, releaseName string) *model.Release {
+	var releaseList model.ReleaseList
+	if err := yaml.Unmarshal(data, &releaseList); err != nil {
+		return nil
+	}
+	for _, release := range releaseList.Releases {
+		if release.Name == releaseName {
+			return release
+		}
+	}
+	return nil
+}
+
+func chartValue(values []model.ChartValue, key string) string {
+	for _, value := range values {
+		if value.Key == key {
+			return string(value.InputValue)
+		}
+	}
+	return ""
+}
+
+// CooldownRemaining returns how much is left of an image update's cooldown,
+// which starts when the current tag was last changed in git.
+func (u ImageUpdate) CooldownRemaining(now time.Time) (time.Duration, error) {
+	file := u.TagSource.File
+	if file == "" {
+		file = u.Release.FromFile
+	}
+	return cooldownRemaining(u.Cooldown, file, u.OldTag, u.tagReader(), now)
+}
+
+// CooldownRemaining returns how much is left of a chart update's cooldown,
+// which starts when the current chart version was last changed in git.
+func (u ChartUpdate) CooldownRemaining(now time.Time) (time.Duration, error) {
+	return cooldownRemaining(u.Cooldown, u.Release.FromFile, u.OldVersion, chartVersionReader(u.Release.Name), now)
+}
+
+func cooldownRemaining(cooldown time.Duration, file, value string, read ValueReader, now time.Time) (time.Duration, error) {
+	if cooldown <= 0 {
+		return 0, nil
+	}
+	changed, err := LastChanged(file, value, read)
+	if err != nil || changed.IsZero() {
+		return 0, err
+	}
+	if remaining := changed.Add(cooldown).Sub(now); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
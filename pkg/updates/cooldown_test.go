@@ -0,0 +1,158 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package updates
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubecd/kubecd/pkg/exec"
+	"github.com/kubecd/kubecd/pkg/helm"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// TestHelperProcess is required boilerplate (one per package) for using exec.TestRunner
+func TestHelperProcess(t *testing.T) {
+	exec.InsideHelperProcess()
+}
+
+// commitAt commits files to a git repository with the given commit time
+func commitAt(t *testing.T, dir string, when time.Time, files map[string]string) {
+	git := func(args ...string) {
+		cmd := osexec.Command("git", append([]string{"-C", dir, "-c", "user.name=kcd", "-c", "user.email=kcd@example.com"}, args...)...)
+		date := strconv.FormatInt(when.Unix(), 10) + " +0000"
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		git("init", "--quiet")
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	git("add", "--all")
+	git("commit", "--quiet", "--message", "update")
+}
+
+const cooldownReleases = `releases:
+  - name: app
+    chart: {reference: stable/app, version: "1.2"}
+    values:
+      - {key: image.tag, value: "1.0"}
+  - name: other
+    chart: {reference: stable/other, version: %q}
+    values:
+      - {key: image.tag, value: %q}
+`
+
+func TestCooldownRemaining(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-cooldown")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	releasesFile := filepath.Join(dir, "releases.yaml")
+	valuesFile := filepath.Join(dir, "values-app.yaml")
+	changed := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	commitAt(t, dir, changed, map[string]string{
+		"releases.yaml":   fmt.Sprintf(cooldownReleases, "1.0", "0.9"),
+		"values-app.yaml": "image:\n  tag: \"1.2\"\n  sidecarTag: 1.2.3\n",
+	})
+	// another release moving to the same tag and chart version, and a value
+	// containing the tag, do not restart the cooldown
+	commitAt(t, dir, changed.Add(12*time.Hour), map[string]string{
+		"releases.yaml":   fmt.Sprintf(cooldownReleases, "1.2", "1.0"),
+		"values-app.yaml": "image:\n  tag: \"1.2\"\n  sidecarTag: 1.2.4\n",
+	})
+	release := &model.Release{Name: "app", FromFile: releasesFile, Environment: &model.Environment{Name: "test"}}
+	update := ImageUpdate{
+		OldTag:    "1.0",
+		NewTag:    "1.1",
+		TagValue:  "image.tag",
+		Release:   release,
+		TagSource: helm.ValueSource{Kind: helm.ValueSourceValues, File: releasesFile},
+		Cooldown:  24 * time.Hour,
+	}
+	remaining, err := update.CooldownRemaining(changed.Add(6 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 18*time.Hour, remaining)
+	remaining, err = update.CooldownRemaining(changed.Add(48 * time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+
+	update.OldTag, update.TagSource = "1.2", helm.ValueSource{Kind: helm.ValueSourceValuesFile, File: valuesFile}
+	remaining, err = update.CooldownRemaining(changed.Add(6 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 18*time.Hour, remaining)
+
+	chartUpdate := ChartUpdate{Release: release, OldVersion: "1.2", NewVersion: "1.3", Cooldown: 24 * time.Hour}
+	remaining, err = chartUpdate.CooldownRemaining(changed.Add(6 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 18*time.Hour, remaining)
+
+	require.NoError(t, ioutil.WriteFile(valuesFile, []byte("image:\n  tag: \"1.3\"\n"), 0644))
+	update.OldTag = "1.3"
+	remaining, err = update.CooldownRemaining(changed)
+	require.NoError(t, err)
+	assert.Zero(t, remaining, "never committed")
+
+	oldRunner := runner
+	defer func() { runner = oldRunner }()
+	runner = exec.TestRunner{ExitCode: 128}
+	_, err = update.CooldownRemaining(changed)
+	assert.Error(t, err)
+	update.Cooldown = 0
+	remaining, err = update.CooldownRemaining(changed)
+	assert.NoError(t, err, "git is not run without a cooldown")
+	assert.Zero(t, remaining)
+}
+
+func TestCooldownRemainingNumericTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcd-cooldown")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	valuesFile := filepath.Join(dir, "values-app.yaml")
+	changed := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	commitAt(t, dir, changed.Add(-24*time.Hour), map[string]string{"values-app.yaml": "image:\n  tag: 1.9\n"})
+	commitAt(t, dir, changed, map[string]string{"values-app.yaml": "image:\n  tag: 20240101\n"})
+	commitAt(t, dir, changed.Add(12*time.Hour), map[string]string{"values-app.yaml": "image:\n  tag: 20240101\nreplicas: 2\n"})
+	update := ImageUpdate{
+		OldTag:    "20240101",
+		NewTag:    "20240102",
+		TagValue:  "image.tag",
+		Release:   &model.Release{Name: "app", FromFile: filepath.Join(dir, "releases.yaml")},
+		TagSource: helm.ValueSource{Kind: helm.ValueSourceValuesFile, File: valuesFile},
+		Cooldown:  24 * time.Hour,
+	}
+	remaining, err := update.CooldownRemaining(changed.Add(6 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 18*time.Hour, remaining)
+
+	changed, err = LastChanged(valuesFile, "1.9", valuesFileReader("image.tag"))
+	require.NoError(t, err)
+	assert.Zero(t, changed, "1.9 is not the current value")
+}
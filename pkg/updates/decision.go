@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package updates
+
+import (
+	"fmt"
+
+	"github.com/kubecd/kubecd/pkg/image"
+	"github.com/kubecd/kubecd/pkg/model"
+)
+
+// Decision records how a new tag was chosen, or why none was, for one image
+// trigger of a release.
+type Decision struct {
+	Environment string         `json:"environment"`
+	Release     string         `json:"release"`
+	Image       string         `json:"image,omitempty"`
+	TagValue    string         `json:"tagValue"`
+	Track       string         `json:"track,omitempty"`
+	Exclude     []string       `json:"exclude,omitempty"`
+	CurrentTag  string         `json:"currentTag,omitempty"`
+	ChosenTag   string         `json:"chosenTag,omitempty"`
+	Reason      string         `json:"reason"`
+	Candidates  []CandidateTag `json:"candidates,omitempty"`
+}
+
+// CandidateTag is a tag considered for an update. Rejected tells why it was
+// not chosen, and is empty for the chosen tag.
+type CandidateTag struct {
+	Tag       string `json:"tag"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Rejected  string `json:"rejected,omitempty"`
+}
+
+// Candidate rejection reasons.
+const (
+	RejectedCurrent      = "current tag"
+	RejectedLatest       = `"latest" is never chosen`
+	RejectedNotNewer     = "not newer than the current tag"
+	RejectedNotSemver    = "not a semantic version"
+	RejectedOutsideTrack = "outside track"
+	RejectedExcluded     = "excluded"
+	RejectedNoMatch      = "does not match tagPattern"
+	RejectedPrerelease   = "prerelease not allowed"
+	RejectedTooNew       = "younger than minAge"
+	RejectedUnsortable   = "not sortable by"
+	RejectedNotBest      = "not the best match"
+)
+
+// explainCandidates tells why each candidate tag was or was not chosen by
+// image.TagSelector.Newest.
+func explainCandidates(currentTag, chosenTag image.TimestampedTag, candidateTags []image.TimestampedTag, selector *image.TagSelector) []CandidateTag {
+	result := make([]CandidateTag, len(candidateTags))
+	for i, tag := range candidateTags {
+		result[i] = CandidateTag{Tag: tag.Tag, Timestamp: tag.Timestamp}
+		switch {
+		case tag.Tag == currentTag.Tag:
+			result[i].Rejected = RejectedCurrent
+		case tag.Tag == chosenTag.Tag:
+		case !selector.Matches(tag.Tag):
+			result[i].Rejected = RejectedNoMatch
+		case selector.IsExcluded(tag.Tag):
+			result[i].Rejected = RejectedExcluded
+		case !selector.AllowsPrerelease(currentTag, tag):
+			result[i].Rejected = RejectedPrerelease
+		case !selector.OldEnough(tag):
+			result[i].Rejected = RejectedTooNew
+		case selector.SortBy == model.SortByTimestamp && tag.Tag == "latest":
+			result[i].Rejected = RejectedLatest
+		case selector.SortBy == model.SortByTimestamp && tag.Timestamp <= currentTag.Timestamp:
+			result[i].Rejected = RejectedNotNewer
+		case selector.SortBy == model.SortBySemver && selector.Semantic(tag) == nil:
+			result[i].Rejected = RejectedNotSemver
+		case selector.SortBy == model.SortBySemver && !selector.IsUpgrade(currentTag, tag):
+			result[i].Rejected = RejectedOutsideTrack + " " + selector.Policy.Track
+		case !selector.Sortable(tag):
+			result[i].Rejected = RejectedUnsortable + " " + selector.SortBy
+		case !selector.IsUpgrade(currentTag, tag):
+			result[i].Rejected = RejectedNotNewer
+		default:
+			result[i].Rejected = RejectedNotBest
+		}
+	}
+	return result
+}
+
+// chosenTagReason describes why a tag was chosen over the current one.
+func chosenTagReason(currentTag, chosenTag image.TimestampedTag, selector *image.TagSelector) string {
+	switch selector.SortBy {
+	case model.SortByTimestamp:
+		return fmt.Sprintf(`%s is the most recently created tag, newer than %s`, chosenTag.Tag, currentTag.Tag)
+	case model.SortBySemver:
+		return fmt.Sprintf(`%s is the highest version above %s within track %s`, chosenTag.Tag, currentTag.Tag, selector.Policy.Track)
+	}
+	return fmt.Sprintf(`%s is the highest %s version above %s`, chosenTag.Tag, selector.SortBy, currentTag.Tag)
+}
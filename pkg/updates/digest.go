@@ -0,0 +1,47 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package updates
+
+import (
+	"fmt"
+
+	"github.com/kubecd/kubecd/pkg/image"
+)
+
+// getTagDigest is replaced in tests
+var getTagDigest = image.GetTagDigest
+
+// ResolveDigests sets NewDigest for updates that pin digests and do not
+// have one yet, by asking the registry for the digest of the new tag.
+func ResolveDigests(imageUpdates []ImageUpdate) error {
+	for i := range imageUpdates {
+		update := &imageUpdates[i]
+		if !update.PinDigest || update.NewDigest != "" {
+			continue
+		}
+		imageRef := image.NewDockerImageRef(update.ImageRepo)
+		imageRef.Tag = update.NewTag
+		digest, err := getTagDigest(imageRef)
+		if err != nil {
+			return fmt.Errorf(`could not pin release %q image %s to a digest: %v`, update.Release.Name, imageRef.WithTag(), err)
+		}
+		update.NewDigest = digest
+	}
+	return nil
+}
@@ -20,80 +20,197 @@ package updates
 
 import (
 	"fmt"
+	mmsemver "github.com/Masterminds/semver/v3"
 	"github.com/kubecd/kubecd/pkg/helm"
 	"github.com/kubecd/kubecd/pkg/image"
 	"github.com/kubecd/kubecd/pkg/model"
+	"github.com/kubecd/kubecd/pkg/semver"
 	"github.com/pkg/errors"
+	"time"
 )
 
 type ImageUpdate struct {
-	OldTag    string
-	NewTag    string
+	OldTag string
+	NewTag string
+	// OldDigest is set when the current tag is pinned to a digest, and
+	// NewDigest when the digest of the new tag is known, see ResolveDigests.
+	OldDigest string
+	NewDigest string
 	Release   *model.Release
 	TagValue  string
 	ImageRepo string
 	Reason    string
+	// TagSource is where the current tag is defined, see helm.GetResolvedValueSources
+	TagSource helm.ValueSource
+	// PinDigest is set for triggers with "pin: digest"
+	PinDigest bool
+	// DigestValue is the trigger's digestValue, with DigestSource being where
+	// it is defined. Without one, digests are pinned in the tag value.
+	DigestValue  string
+	DigestSource helm.ValueSource
+	// Cooldown is how long after the current tag was changed to wait before
+	// updating it, see CooldownRemaining
+	Cooldown time.Duration
+}
+
+// NewTagValue is the value the tag value is updated to. Tags pinned to a
+// digest in the tag value stay pinned if the new digest is known.
+func (u ImageUpdate) NewTagValue() string {
+	if u.NewDigest != "" && u.DigestValue == "" && (u.PinDigest || u.OldDigest != "") {
+		return u.NewTag + "@" + u.NewDigest
+	}
+	return u.NewTag
 }
 
 type ChartUpdate struct {
 	Release    *model.Release
+	Chart      string
 	OldVersion string
 	NewVersion string
 	Reason     string
+	Cooldown   time.Duration
 }
 
 func FindImageUpdatesForRelease(release *model.Release, tagIndex TagIndex) ([]ImageUpdate, error) {
+	updates, _, err := ExplainImageUpdatesForRelease(release, tagIndex)
+	return updates, err
+}
+
+// ExplainImageUpdatesForRelease is like FindImageUpdatesForRelease, but also
+// returns a Decision for each image trigger of the release.
+func ExplainImageUpdatesForRelease(release *model.Release, tagIndex TagIndex) ([]ImageUpdate, []Decision, error) {
 	updates := make([]ImageUpdate, 0)
-	if release.Triggers == nil {
-		return updates, nil
-	}
+	decisions := make([]Decision, 0)
 	for _, trigger := range release.Triggers {
-		if trigger.Image == nil || trigger.Image.Track == "" {
-			fmt.Println("no trigger")
+		if trigger.Image == nil {
 			continue
 		}
-		values, err := helm.GetResolvedValues(release)
-		if err != nil {
-			return nil, fmt.Errorf(`while looking for updates for release %q: %v`, release.Name, err)
+		decision := Decision{
+			Release:  release.Name,
+			TagValue: trigger.Image.TagValueString(),
+			Track:    trigger.Image.Track,
+			Exclude:  trigger.Image.Exclude,
 		}
-		imageRef := helm.GetImageRefFromImageTrigger(trigger.Image, values)
-		if imageRef == nil {
-			continue
+		if release.Environment != nil {
+			decision.Environment = release.Environment.Name
 		}
-		imageTags := tagIndex.GetTags(imageRef)
-		if imageTags == nil {
-			//fmt.Printf("env:%s release:%s imageTags == nil, tagIndex=%#v, imageRef=%#v\n", release.Environment.Name, release.Name, tagIndex, *imageRef)
-			continue
+		update, err := findImageUpdateForTrigger(release, trigger.Image, tagIndex, &decision)
+		if err != nil {
+			return nil, nil, err
 		}
-		var currentTag image.TimestampedTag
-		foundTag := false
-		for _, tag := range imageTags {
-			if imageRef.Tag == tag.Tag {
-				currentTag = tag
-				foundTag = true
-			}
+		if update != nil {
+			updates = append(updates, *update)
 		}
-		if !foundTag {
-			fmt.Printf("did not find %s in %#v\n", imageRef.Tag, imageTags)
-			continue
+		decisions = append(decisions, decision)
+	}
+	return updates, decisions, nil
+}
+
+func findImageUpdateForTrigger(release *model.Release, trigger *model.ImageTrigger, tagIndex TagIndex, decision *Decision) (*ImageUpdate, error) {
+	if trigger.Track == "" {
+		decision.Reason = "trigger has no track"
+		return nil, nil
+	}
+	values, err := helm.GetResolvedValues(release)
+	if err != nil {
+		return nil, fmt.Errorf(`while looking for updates for release %q: %v`, release.Name, err)
+	}
+	imageRef := helm.GetImageRefFromImageTrigger(trigger, values)
+	if imageRef == nil {
+		decision.Reason = fmt.Sprintf(`no image found in values %q and %q`, trigger.RepoValueString(), trigger.TagValueString())
+		return nil, nil
+	}
+	decision.Image = imageRef.WithoutTag()
+	decision.CurrentTag = imageRef.Tag
+	if imageRef.Tag == "" {
+		decision.Reason = fmt.Sprintf(`image has no tag in %q`, trigger.TagValueString())
+		return nil, nil
+	}
+	selector, err := image.NewTagSelector(trigger)
+	if err != nil {
+		return nil, fmt.Errorf(`while looking for updates for release %q: %v`, release.Name, err)
+	}
+	selector.MinAge = release.MinAge(model.ReleaseUpdateTrigger{Image: trigger})
+	if !selector.Matches(imageRef.Tag) {
+		decision.Reason = fmt.Sprintf(`current tag %q does not match tagPattern %q`, imageRef.Tag, trigger.TagPattern)
+		return nil, nil
+	}
+	imageTags := tagIndex.GetTags(imageRef)
+	if imageTags == nil {
+		decision.Reason = "no tags found for image"
+		return nil, nil
+	}
+	var currentTag image.TimestampedTag
+	foundTag := false
+	for _, tag := range imageTags {
+		if imageRef.Tag == tag.Tag {
+			currentTag = tag
+			foundTag = true
 		}
-		newestTag := image.GetNewestMatchingTag(currentTag, imageTags, trigger.Image.Track)
-		if newestTag.Tag != currentTag.Tag {
-			updates = append(updates, ImageUpdate{
-				OldTag:    currentTag.Tag,
-				NewTag:    newestTag.Tag,
-				Release:   release,
-				TagValue:  trigger.Image.TagValueString(),
-				ImageRepo: imageRef.WithoutTag(),
-				Reason:    "FIXME",
-			})
+	}
+	if !foundTag {
+		decision.Reason = fmt.Sprintf(`current tag %q not found among %d tags`, imageRef.Tag, len(imageTags))
+		return nil, nil
+	}
+	newestTag := selector.Newest(currentTag, imageTags)
+	decision.Candidates = explainCandidates(currentTag, newestTag, imageTags, selector)
+	if newestTag.Tag == currentTag.Tag {
+		decision.Reason = fmt.Sprintf(`no tag is better than %s within track %s`, currentTag.Tag, trigger.Track)
+		return nil, nil
+	}
+	tagSource, err := findValueSource(release, trigger.TagValueString())
+	if err != nil {
+		return nil, fmt.Errorf(`while looking for updates for release %q: %v`, release.Name, err)
+	}
+	var digestSource helm.ValueSource
+	if trigger.DigestValue != "" {
+		if digestSource, err = findValueSource(release, trigger.DigestValue); err != nil {
+			return nil, fmt.Errorf(`while looking for updates for release %q: %v`, release.Name, err)
 		}
 	}
-	return updates, nil
+	decision.ChosenTag = newestTag.Tag
+	decision.Reason = chosenTagReason(currentTag, newestTag, selector)
+	return &ImageUpdate{
+		OldTag:       currentTag.Tag,
+		NewTag:       newestTag.Tag,
+		OldDigest:    imageRef.Digest,
+		Release:      release,
+		TagValue:     trigger.TagValueString(),
+		ImageRepo:    imageRef.WithoutTag(),
+		Reason:       decision.Reason,
+		TagSource:    tagSource,
+		PinDigest:    trigger.PinsDigest(),
+		DigestValue:  trigger.DigestValue,
+		DigestSource: digestSource,
+		Cooldown:     release.Cooldown(model.ReleaseUpdateTrigger{Image: trigger}),
+	}, nil
+}
+
+// findValueSource returns the source of a release's tag or digest value. Values
+// defined only in the chart are overridden with an inline value in the releases file.
+func findValueSource(release *model.Release, key string) (helm.ValueSource, error) {
+	sources, err := helm.GetResolvedValueSources(release)
+	if err != nil {
+		return helm.ValueSource{}, err
+	}
+	source, found := sources[key]
+	if !found || source.Kind == helm.ValueSourceChart {
+		return helm.ValueSource{Kind: helm.ValueSourceValues, File: release.FromFile}, nil
+	}
+	return source, nil
 }
 
 type ReleaseFilterFunc func(*model.Release) bool
 
+func hasImageTrigger(release *model.Release) bool {
+	for _, t := range release.Triggers {
+		if t.Image != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func ImageReleaseIndex(kcdConfig *model.KubeCDConfig, filters ...ReleaseFilterFunc) (map[string][]*model.Release, error) {
 	result := make(map[string][]*model.Release)
 releaseLoop:
@@ -103,6 +220,9 @@ releaseLoop:
 				continue releaseLoop
 			}
 		}
+		if !hasImageTrigger(release) {
+			continue
+		}
 		//fmt.Printf("evaluating release %q\n", release.Name)
 		values, err := helm.GetResolvedValues(release)
 		if err != nil {
@@ -124,3 +244,89 @@ releaseLoop:
 	}
 	return result, nil
 }
+
+// chartTrackToSemverTrack maps a chart trigger track to a semver track. Chart
+// versions are always semver, so "Newest" means the highest version.
+func chartTrackToSemverTrack(track string) string {
+	if track == semver.TrackNewest {
+		return semver.TrackMajorVersion
+	}
+	return track
+}
+
+// FindChartUpdatesForRelease picks the best of the candidate chart versions for
+// a release, according to its chart triggers. Created has the creation time of
+// versions, which with a minAge must be old enough to be chosen.
+func FindChartUpdatesForRelease(release *model.Release, candidateVersions []string, created map[string]int64) ([]ChartUpdate, error) {
+	updates := make([]ChartUpdate, 0)
+	if release.Chart == nil || release.Chart.Reference == nil || release.Chart.Version == nil {
+		return updates, nil
+	}
+	currentVersion, err := semver.Parse(*release.Chart.Version)
+	if err != nil {
+		return nil, fmt.Errorf(`release %q: chart.version %q is not a semantic version: %v`, release.Name, *release.Chart.Version, err)
+	}
+	originalVersions := make(map[string]string)
+	for _, trigger := range release.Triggers {
+		if trigger.Chart == nil || trigger.Chart.Track == "" {
+			continue
+		}
+		minAge := release.MinAge(trigger)
+		candidates := make([]*mmsemver.Version, 0)
+		for _, version := range candidateVersions {
+			if minAge > 0 && (created[version] <= 0 || time.Since(time.Unix(created[version], 0)) < minAge) {
+				continue
+			}
+			if sv, err := semver.Parse(version); err == nil {
+				candidates = append(candidates, sv)
+				originalVersions[sv.String()] = version
+			}
+		}
+		policy := semver.Policy{
+			Track:      chartTrackToSemverTrack(trigger.Chart.Track),
+			Exclude:    trigger.Chart.Exclude,
+			Prerelease: trigger.Chart.Prerelease,
+			Channels:   trigger.Chart.Channels,
+		}
+		best, err := policy.BestUpgrade(currentVersion, candidates)
+		if err != nil {
+			continue
+		}
+		updates = append(updates, ChartUpdate{
+			Release:    release,
+			Chart:      *release.Chart.Reference,
+			OldVersion: *release.Chart.Version,
+			NewVersion: originalVersions[best.String()],
+			Reason:     fmt.Sprintf(`%s is the best upgrade from %s with track %s`, best.Original(), *release.Chart.Version, trigger.Chart.Track),
+			Cooldown:   release.Cooldown(trigger),
+		})
+		break
+	}
+	return updates, nil
+}
+
+// ChartReleaseIndex maps chart references to the releases using them, for
+// releases with a chart trigger.
+func ChartReleaseIndex(kcdConfig *model.KubeCDConfig, filters ...ReleaseFilterFunc) map[string][]*model.Release {
+	result := make(map[string][]*model.Release)
+releaseLoop:
+	for _, release := range kcdConfig.AllReleases() {
+		if release.Chart == nil || release.Chart.Reference == nil {
+			continue
+		}
+		for _, filter := range filters {
+			if !filter(release) {
+				continue releaseLoop
+			}
+		}
+		for _, t := range release.Triggers {
+			if t.Chart == nil {
+				continue
+			}
+			ref := *release.Chart.Reference
+			result[ref] = append(result[ref], release)
+			break
+		}
+	}
+	return result
+}
@@ -19,11 +19,15 @@
 package updates
 
 import (
+	"fmt"
 	"github.com/kubecd/kubecd/pkg/image"
 	"github.com/kubecd/kubecd/pkg/model"
 	"github.com/kubecd/kubecd/pkg/semver"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestImageReleaseIndex(t *testing.T) {
@@ -62,9 +66,200 @@ func TestImageReleaseIndex(t *testing.T) {
 	index, err := ImageReleaseIndex(kcdConfig)
 	assert.NoError(t, err)
 	assert.Len(t, index, 2)
-	assert.Len(t, index[image.DefaultDockerRegistry+"/test-image"], 2)
-	assert.Len(t, index[image.DefaultDockerRegistry+"/test-image2"], 1)
-	assert.Equal(t, "release1", index[image.DefaultDockerRegistry+"/test-image"][0].Name)
-	assert.Equal(t, "release2", index[image.DefaultDockerRegistry+"/test-image"][1].Name)
-	assert.Equal(t, "release3", index[image.DefaultDockerRegistry+"/test-image2"][0].Name)
+	assert.Len(t, index[image.DefaultDockerRegistry+"/library/test-image"], 2)
+	assert.Len(t, index[image.DefaultDockerRegistry+"/library/test-image2"], 1)
+	assert.Equal(t, "release1", index[image.DefaultDockerRegistry+"/library/test-image"][0].Name)
+	assert.Equal(t, "release2", index[image.DefaultDockerRegistry+"/library/test-image"][1].Name)
+	assert.Equal(t, "release3", index[image.DefaultDockerRegistry+"/library/test-image2"][0].Name)
+}
+
+func TestFindChartUpdatesForRelease(t *testing.T) {
+	chartRef := "stable/nginx-ingress"
+	type testCase struct {
+		current    string
+		track      string
+		exclude    []string
+		candidates []string
+		expected   string
+	}
+	for i, tc := range []testCase{
+		{"1.2.0", semver.TrackPatchLevel, nil, []string{"1.2.1", "1.3.0", "2.0.0"}, "1.2.1"},
+		{"1.2.0", semver.TrackMinorVersion, nil, []string{"1.2.1", "1.3.0", "2.0.0"}, "1.3.0"},
+		{"1.2.0", semver.TrackNewest, nil, []string{"1.2.1", "1.3.0", "2.0.0"}, "2.0.0"},
+		{"1.2.0", semver.TrackMinorVersion, nil, []string{"1.10"}, "1.10"},
+		{"1.2.0", semver.TrackMinorVersion, nil, []string{"1.1.0", "2.0.0"}, ""},
+		{"1.2.0", "", nil, []string{"1.3.0"}, ""},
+		{"1.2.0", "~1.2", nil, []string{"1.2.1", "1.2.4", "1.3.0"}, "1.2.4"},
+		{"1.2.0", ">=1.3 <2", []string{"1.3.2"}, []string{"1.2.1", "1.3.1", "1.3.2", "2.0.0"}, "1.3.1"},
+		{"1.2.0", semver.TrackNewest, []string{">=2"}, []string{"1.2.1", "1.3.0", "2.0.0"}, "1.3.0"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			version := tc.current
+			release := &model.Release{
+				Name:     "ingress",
+				Chart:    &model.Chart{Reference: &chartRef, Version: &version},
+				Triggers: []model.ReleaseUpdateTrigger{{Chart: &model.HelmTrigger{Track: tc.track, Exclude: tc.exclude}}},
+			}
+			chartUpdates, err := FindChartUpdatesForRelease(release, tc.candidates, nil)
+			require.NoError(t, err)
+			if tc.expected == "" {
+				assert.Empty(t, chartUpdates)
+				return
+			}
+			require.Len(t, chartUpdates, 1)
+			assert.Equal(t, tc.current, chartUpdates[0].OldVersion)
+			assert.Equal(t, tc.expected, chartUpdates[0].NewVersion)
+			assert.Equal(t, chartRef, chartUpdates[0].Chart)
+		})
+	}
+}
+
+func TestFindChartUpdatesForReleaseMinAge(t *testing.T) {
+	chartRef, version := "stable/nginx-ingress", "1.2.0"
+	release := &model.Release{
+		Name:        "ingress",
+		Chart:       &model.Chart{Reference: &chartRef, Version: &version},
+		Triggers:    []model.ReleaseUpdateTrigger{{Chart: &model.HelmTrigger{Track: semver.TrackMinorVersion}}},
+		Environment: &model.Environment{Name: "prod", MinAge: "24h"},
+	}
+	now := time.Now()
+	created := map[string]int64{
+		"1.2.1": now.Add(-48 * time.Hour).Unix(),
+		"1.3.0": now.Add(-time.Hour).Unix(),
+	}
+	chartUpdates, err := FindChartUpdatesForRelease(release, []string{"1.2.1", "1.3.0", "1.4.0"}, created)
+	require.NoError(t, err)
+	require.Len(t, chartUpdates, 1)
+	assert.Equal(t, "1.2.1", chartUpdates[0].NewVersion)
+}
+
+func TestChartReleaseIndex(t *testing.T) {
+	chartRef1, chartRef2, version := "stable/nginx-ingress", "stable/cert-manager", "1.0.0"
+	env := &model.Environment{Name: "test"}
+	trigger := []model.ReleaseUpdateTrigger{{Chart: &model.HelmTrigger{Track: semver.TrackMinorVersion}}}
+	env.Releases = []*model.Release{
+		{Name: "ingress", Chart: &model.Chart{Reference: &chartRef1, Version: &version}, Triggers: trigger, Environment: env},
+		{Name: "ingress2", Chart: &model.Chart{Reference: &chartRef1, Version: &version}, Triggers: trigger, Environment: env},
+		{Name: "no-trigger", Chart: &model.Chart{Reference: &chartRef1, Version: &version}, Environment: env},
+		{Name: "cert-manager", Chart: &model.Chart{Reference: &chartRef2, Version: &version}, Triggers: trigger, Environment: env},
+	}
+	kcdConfig := &model.KubeCDConfig{Environments: []*model.Environment{env}}
+	index := ChartReleaseIndex(kcdConfig)
+	assert.Len(t, index, 2)
+	assert.Len(t, index[chartRef1], 2)
+	assert.Len(t, index[chartRef2], 1)
+	index = ChartReleaseIndex(kcdConfig, ChartReleaseFilter(chartRef2))
+	assert.Len(t, index, 1)
+	assert.Equal(t, "cert-manager", index[chartRef2][0].Name)
+}
+
+func TestExplainImageUpdatesForRelease(t *testing.T) {
+	env := &model.Environment{Name: "test"}
+	release := &model.Release{
+		Name: "app",
+		Values: []model.ChartValue{
+			{Key: model.DefaultRepoValue, Value: "test-image"},
+			{Key: model.DefaultTagValue, Value: "1.0.0"},
+		},
+		Triggers: []model.ReleaseUpdateTrigger{
+			{Image: &model.ImageTrigger{Track: semver.TrackMinorVersion}},
+			{Image: &model.ImageTrigger{}},
+		},
+		FromFile:    "/tmp/releases.yaml",
+		Environment: env,
+	}
+	repo := image.DefaultDockerRegistry + "/library/test-image"
+	tagIndex := TagIndex{repo: {
+		{Tag: "1.0.0", Timestamp: 1},
+		{Tag: "1.0.1", Timestamp: 2},
+		{Tag: "1.1.0", Timestamp: 3},
+		{Tag: "2.0.0", Timestamp: 4},
+		{Tag: "latest", Timestamp: 5},
+	}}
+	imageUpdates, decisions, err := ExplainImageUpdatesForRelease(release, tagIndex)
+	require.NoError(t, err)
+	require.Len(t, imageUpdates, 1)
+	assert.Equal(t, "1.1.0", imageUpdates[0].NewTag)
+	assert.Equal(t, decisions[0].Reason, imageUpdates[0].Reason)
+	require.Len(t, decisions, 2)
+	assert.Equal(t, "test", decisions[0].Environment)
+	assert.Equal(t, repo, decisions[0].Image)
+	assert.Equal(t, "1.0.0", decisions[0].CurrentTag)
+	assert.Equal(t, "1.1.0", decisions[0].ChosenTag)
+	assert.Equal(t, []CandidateTag{
+		{Tag: "1.0.0", Timestamp: 1, Rejected: RejectedCurrent},
+		{Tag: "1.0.1", Timestamp: 2, Rejected: RejectedNotBest},
+		{Tag: "1.1.0", Timestamp: 3},
+		{Tag: "2.0.0", Timestamp: 4, Rejected: RejectedOutsideTrack + " " + semver.TrackMinorVersion},
+		{Tag: "latest", Timestamp: 5, Rejected: RejectedNotSemver},
+	}, decisions[0].Candidates)
+	assert.Equal(t, "trigger has no track", decisions[1].Reason)
+	assert.Empty(t, decisions[1].ChosenTag)
+
+	tagIndex[repo] = tagIndex[repo][:1]
+	imageUpdates, decisions, err = ExplainImageUpdatesForRelease(release, tagIndex)
+	require.NoError(t, err)
+	assert.Empty(t, imageUpdates)
+	assert.Equal(t, "no tag is better than 1.0.0 within track MinorVersion", decisions[0].Reason)
+
+	release.Triggers[0].Image = &model.ImageTrigger{Track: ">=1 <2", Exclude: []string{"1.1.0"}}
+	tagIndex[repo] = []image.TimestampedTag{{Tag: "1.0.0", Timestamp: 1}, {Tag: "1.0.1", Timestamp: 2}, {Tag: "1.1.0", Timestamp: 3}}
+	imageUpdates, decisions, err = ExplainImageUpdatesForRelease(release, tagIndex)
+	require.NoError(t, err)
+	require.Len(t, imageUpdates, 1)
+	assert.Equal(t, "1.0.1", imageUpdates[0].NewTag)
+	assert.Equal(t, RejectedExcluded, decisions[0].Candidates[2].Rejected)
+	assert.Equal(t, "1.0.1 is the highest version above 1.0.0 within track >=1 <2", decisions[0].Reason)
+
+	release.Triggers[0].Image = &model.ImageTrigger{Track: semver.TrackNewest, TagPattern: `1\.0\.(\d+)`, VersionFrom: "1", SortBy: model.SortByNumeric}
+	imageUpdates, decisions, err = ExplainImageUpdatesForRelease(release, tagIndex)
+	require.NoError(t, err)
+	require.Len(t, imageUpdates, 1)
+	assert.Equal(t, "1.0.1", imageUpdates[0].NewTag)
+	assert.Equal(t, RejectedNoMatch, decisions[0].Candidates[2].Rejected)
+	assert.Equal(t, "1.0.1 is the highest numeric version above 1.0.0", decisions[0].Reason)
+
+	release.Triggers[0].Image.TagPattern = `build-(\d+)`
+	imageUpdates, decisions, err = ExplainImageUpdatesForRelease(release, tagIndex)
+	require.NoError(t, err)
+	assert.Empty(t, imageUpdates)
+	assert.Equal(t, `current tag "1.0.0" does not match tagPattern "build-(\\d+)"`, decisions[0].Reason)
+}
+
+func TestImageUpdateNewTagValue(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	assert.Equal(t, "1.1", ImageUpdate{OldTag: "1.0", NewTag: "1.1"}.NewTagValue())
+	assert.Equal(t, "1.1", ImageUpdate{OldTag: "1.0", NewTag: "1.1", NewDigest: digest}.NewTagValue())
+	// the old digest is dropped when the new one is not known
+	assert.Equal(t, "1.1", ImageUpdate{OldTag: "1.0", OldDigest: digest, NewTag: "1.1"}.NewTagValue())
+	assert.Equal(t, "1.1@"+digest, ImageUpdate{OldTag: "1.0", OldDigest: digest, NewTag: "1.1", NewDigest: digest}.NewTagValue())
+}
+
+func TestResolveDigests(t *testing.T) {
+	oldGetTagDigest := getTagDigest
+	defer func() { getTagDigest = oldGetTagDigest }()
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	var resolved []string
+	getTagDigest = func(imageRef *image.DockerImageRef) (string, error) {
+		resolved = append(resolved, imageRef.WithTag())
+		return digest, nil
+	}
+	release := &model.Release{Name: "app"}
+	imageUpdates := []ImageUpdate{
+		{Release: release, ImageRepo: "registry.example.com/app", NewTag: "1.1", PinDigest: true},
+		{Release: release, ImageRepo: "registry.example.com/other", NewTag: "2.0"},
+		{Release: release, ImageRepo: "registry.example.com/given", NewTag: "3.0", PinDigest: true, NewDigest: "sha256:given"},
+	}
+	require.NoError(t, ResolveDigests(imageUpdates))
+	assert.Equal(t, []string{"registry.example.com/app:1.1"}, resolved)
+	assert.Equal(t, digest, imageUpdates[0].NewDigest)
+	assert.Equal(t, "1.1@"+digest, imageUpdates[0].NewTagValue())
+	assert.Equal(t, "", imageUpdates[1].NewDigest)
+	assert.Equal(t, "sha256:given", imageUpdates[2].NewDigest)
+
+	getTagDigest = func(*image.DockerImageRef) (string, error) {
+		return "", fmt.Errorf("not found")
+	}
+	imageUpdates[0].NewDigest = ""
+	assert.Error(t, ResolveDigests(imageUpdates))
 }
@@ -60,6 +60,12 @@ func ImageReleaseFilter(imageRepo string) ReleaseFilterFunc {
 	}
 }
 
+func ChartReleaseFilter(chartReference string) ReleaseFilterFunc {
+	return func(release *model.Release) bool {
+		return release.Chart != nil && release.Chart.Reference != nil && *release.Chart.Reference == chartReference
+	}
+}
+
 func ReleaseFilter(releaseNames []string) ReleaseFilterFunc {
 	return func(release *model.Release) bool {
 		for _, relName := range releaseNames {
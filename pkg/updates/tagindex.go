@@ -20,60 +20,61 @@ package updates
 
 import (
 	"fmt"
+	"time"
+
 	"github.com/kubecd/kubecd/pkg/helm"
 	"github.com/kubecd/kubecd/pkg/image"
 	"github.com/kubecd/kubecd/pkg/model"
-	"github.com/pkg/errors"
 )
 
 // TagIndex maps image repos (without tag) to a list of tags with timestamps
 type TagIndex map[string][]image.TimestampedTag
 
+// BuildTagIndexFromDockerRegistries scans the registries of all images in an
+// image index concurrently, see image.GetTagsForDockerImages.
 func BuildTagIndexFromDockerRegistries(imageIndex map[string][]*model.Release) (TagIndex, error) {
-	tagIndex := TagIndex(make(map[string][]image.TimestampedTag))
+	repos := make([]string, 0, len(imageIndex))
 	for repo := range imageIndex {
-		tags, err := image.GetTagsForDockerImage(repo)
-		if err != nil {
-			return nil, errors.Wrapf(err, `while scanning tags for %s`, repo)
-		}
-		tagIndex[repo] = tags
+		repos = append(repos, repo)
 	}
-	return tagIndex, nil
+	tags, err := image.GetTagsForDockerImages(repos)
+	if err != nil {
+		return nil, err
+	}
+	return TagIndex(tags), nil
 }
 
 // BuildTagIndexFromNewImageRef builds a tag index from an image index, with all
-// tags being used from the input image.
-func BuildTagIndexFromNewImageRef(newImageRef *image.DockerImageRef, imageIndex map[string][]*model.Release) TagIndex {
+// tags being used from the input image. Triggers of the releases for other
+// images are skipped.
+func BuildTagIndexFromNewImageRef(newImageRef *image.DockerImageRef, imageIndex map[string][]*model.Release) (TagIndex, error) {
 	imageRepo := newImageRef.WithoutTag()
 	tagIndex := TagIndex(make(map[string][]image.TimestampedTag))
 	if _, found := imageIndex[imageRepo]; !found {
-		return tagIndex
+		return tagIndex, nil
 	}
-	// Hardcoding Timestamp to 1 here (vs 0 for tags added below) will force
+	// Setting Timestamp to now here (vs 0 for tags added below) will force
 	// FindImageUpdatesForRelease to choose newImageRef's tag over any existing
 	// ones for track=Newest, which is the behaviour we want for "kcd observe".
-	tagIndex[imageRepo] = []image.TimestampedTag{{Tag: newImageRef.Tag, Timestamp: int64(1)}}
+	// It also makes the tag too new for triggers with a minAge.
+	tagIndex[imageRepo] = []image.TimestampedTag{{Tag: newImageRef.Tag, Timestamp: time.Now().Unix()}}
 	for _, release := range imageIndex[imageRepo] {
+		values, err := helm.GetResolvedValues(release)
+		if err != nil {
+			return nil, fmt.Errorf(`resolving values for release %q: %v`, release.Name, err)
+		}
 		for _, trigger := range release.Triggers {
 			if trigger.Image == nil || trigger.Image.Track == "" {
-				fmt.Println("no trigger")
 				continue
 			}
-			values, err := helm.GetResolvedValues(release)
-			if err != nil {
-				panic(fmt.Sprintf(`resolving values for release %q: %v`, release.Name, err))
-			}
 			imageRef := helm.GetImageRefFromImageTrigger(trigger.Image, values)
-			if imageRef == nil {
+			if imageRef == nil || imageRef.WithoutTag() != imageRepo {
 				continue
 			}
-			if imageRef.WithoutTag() != imageRepo {
-				panic(fmt.Sprintf(`expected %q == %q`, imageRef.WithoutTag(), imageRepo))
-			}
 			tagIndex[imageRepo] = append(tagIndex[imageRepo], image.TimestampedTag{Tag: imageRef.Tag, Timestamp: int64(0)})
 		}
 	}
-	return tagIndex
+	return tagIndex, nil
 }
 
 func (i TagIndex) GetTagTimestamp(imageRef *image.DockerImageRef) int64 {
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/kubecd/kubecd/pkg/image"
+)
+
+// Payloads are decoded only as far as needed to find the pushed images.
+
+type dockerHubPayload struct {
+	PushData struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+type harborPayload struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Resources []struct {
+			Digest      string `json:"digest"`
+			Tag         string `json:"tag"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+	} `json:"event_data"`
+}
+
+// distributionEnvelope is the notification format of the distribution
+// registry, also used by the GitLab container registry.
+type distributionEnvelope struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Digest     string `json:"digest"`
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+			URL        string `json:"url"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+// gitHubPayload is a "package" or "registry_package" event.
+type gitHubPayload struct {
+	Action  string `json:"action"`
+	Package struct {
+		Name           string `json:"name"`
+		Namespace      string `json:"namespace"`
+		PackageType    string `json:"package_type"`
+		PackageVersion struct {
+			PackageURL        string `json:"package_url"`
+			ContainerMetadata struct {
+				Tag struct {
+					Name   string `json:"name"`
+					Digest string `json:"digest"`
+				} `json:"tag"`
+			} `json:"container_metadata"`
+		} `json:"package_version"`
+	} `json:"package"`
+}
+
+func parseDockerHub(payload []byte) ([]Event, error) {
+	var hub dockerHubPayload
+	if err := json.Unmarshal(payload, &hub); err != nil {
+		return nil, err
+	}
+	return imageEvents(hub.Repository.RepoName, hub.PushData.Tag, ""), nil
+}
+
+func parseHarbor(payload []byte) ([]Event, error) {
+	var harbor harborPayload
+	if err := json.Unmarshal(payload, &harbor); err != nil {
+		return nil, err
+	}
+	if harbor.Type != "PUSH_ARTIFACT" && harbor.Type != "pushImage" {
+		return nil, nil
+	}
+	var events []Event
+	for _, resource := range harbor.EventData.Resources {
+		repo := image.NewDockerImageRef(resource.ResourceURL).WithoutTag()
+		events = append(events, imageEvents(repo, resource.Tag, resource.Digest)...)
+	}
+	return events, nil
+}
+
+func parseDistribution(payload []byte) ([]Event, error) {
+	var envelope distributionEnvelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, err
+	}
+	var events []Event
+	for _, event := range envelope.Events {
+		if event.Action != "push" {
+			continue
+		}
+		host := event.Request.Host
+		if targetURL, err := url.Parse(event.Target.URL); err == nil && targetURL.Host != "" {
+			host = targetURL.Host
+		}
+		repo := event.Target.Repository
+		if host != "" {
+			repo = host + "/" + repo
+		}
+		events = append(events, imageEvents(repo, event.Target.Tag, event.Target.Digest)...)
+	}
+	return events, nil
+}
+
+func parseGitHub(payload []byte) ([]Event, error) {
+	var github gitHubPayload
+	if err := json.Unmarshal(payload, &github); err != nil {
+		return nil, err
+	}
+	pkg := github.Package
+	if github.Action != "published" || !strings.EqualFold(pkg.PackageType, "container") {
+		return nil, nil
+	}
+	repo := "ghcr.io/" + strings.ToLower(pkg.Namespace) + "/" + pkg.Name
+	if pkg.PackageVersion.PackageURL != "" {
+		repo = image.NewDockerImageRef(pkg.PackageVersion.PackageURL).WithoutTag()
+	}
+	tag := pkg.PackageVersion.ContainerMetadata.Tag
+	return imageEvents(repo, tag.Name, tag.Digest), nil
+}
+
+// imageEvents returns an event for an image pushed with a tag. Pushes
+// without a tag are ignored, as triggers follow tags.
+func imageEvents(repo, tag, digest string) []Event {
+	if repo == "" || tag == "" {
+		return nil
+	}
+	ref := repo + ":" + tag
+	if digest != "" {
+		ref += "@" + digest
+	}
+	return []Event{{Image: ref}}
+}
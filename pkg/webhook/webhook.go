@@ -0,0 +1,208 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package webhook receives push events from container registries, for
+// "kcd serve".
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Event is an image pushed to a registry.
+type Event struct {
+	// Source is the kind of webhook the event came from, see Sources
+	Source string `json:"source"`
+	// Image is the pushed image with its tag, and its digest when known,
+	// like "registry.example.com/app:1.0@sha256:..."
+	Image string `json:"image"`
+}
+
+// Sources of events, which are also the paths of their webhooks below /hooks/.
+const (
+	SourceDockerHub    = "dockerhub"
+	SourceHarbor       = "harbor"
+	SourceGitLab       = "gitlab"
+	SourceGitHub       = "github"
+	SourceDistribution = "distribution"
+)
+
+// maxPayloadSize limits the size of webhook requests
+const maxPayloadSize = 1 << 20
+
+// Sources returns the supported event sources.
+func Sources() []string {
+	return []string{SourceDockerHub, SourceHarbor, SourceGitLab, SourceGitHub, SourceDistribution}
+}
+
+// ParsePayload decodes the events of a webhook payload from a source.
+func ParsePayload(source string, payload []byte) ([]Event, error) {
+	var events []Event
+	var err error
+	switch source {
+	case SourceDockerHub:
+		events, err = parseDockerHub(payload)
+	case SourceHarbor:
+		events, err = parseHarbor(payload)
+	case SourceGitLab, SourceDistribution:
+		events, err = parseDistribution(payload)
+	case SourceGitHub:
+		events, err = parseGitHub(payload)
+	default:
+		return nil, fmt.Errorf(`unknown webhook source %q`, source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf(`invalid %s payload: %v`, source, err)
+	}
+	for i := range events {
+		events[i].Source = source
+	}
+	return events, nil
+}
+
+// Verify checks a webhook request from a source against a shared secret, in
+// the one way each source can send it: GitHub requests must have an
+// HMAC-SHA256 signature of the payload in the X-Hub-Signature-256 header,
+// GitLab requests the secret in the X-Gitlab-Token header, Harbor and
+// distribution requests the secret in the Authorization header, optionally as
+// a bearer token, and Docker Hub requests, which can not have headers, the
+// secret in the "token" query parameter.
+func Verify(source string, r *http.Request, payload []byte, secret string) error {
+	var header, token string
+	switch source {
+	case SourceGitHub:
+		signature := r.Header.Get("X-Hub-Signature-256")
+		if signature == "" {
+			return fmt.Errorf(`no X-Hub-Signature-256`)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(payload)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			return fmt.Errorf(`invalid X-Hub-Signature-256`)
+		}
+		return nil
+	case SourceGitLab:
+		header, token = "X-Gitlab-Token", r.Header.Get("X-Gitlab-Token")
+	case SourceHarbor, SourceDistribution:
+		header, token = "Authorization", strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	case SourceDockerHub:
+		header, token = `"token" query parameter`, r.URL.Query().Get("token")
+	default:
+		return fmt.Errorf(`unknown webhook source %q`, source)
+	}
+	if token == "" {
+		return fmt.Errorf(`no token in %s`, header)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return fmt.Errorf(`invalid token`)
+	}
+	return nil
+}
+
+// Handler serves webhooks at /hooks/<source>, adding their events to a
+// queue, and a health check at /healthz.
+type Handler struct {
+	// Secret verifies webhook requests, see Verify
+	Secret string
+	Queue  chan<- Event
+	// Logf logs rejected requests and queued events
+	Logf func(format string, args ...interface{})
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/healthz":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "queued": len(h.Queue)})
+	case strings.HasPrefix(r.URL.Path, "/hooks/"):
+		h.serveHook(w, r, strings.TrimPrefix(r.URL.Path, "/hooks/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveHook(w http.ResponseWriter, r *http.Request, source string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		h.reject(w, http.StatusMethodNotAllowed, source, "method %s not allowed", r.Method)
+		return
+	}
+	if !stringInSlice(source, Sources()) {
+		h.reject(w, http.StatusNotFound, source, "unknown webhook source %q", source)
+		return
+	}
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		h.reject(w, http.StatusRequestEntityTooLarge, source, "could not read payload: %v", err)
+		return
+	}
+	if err = Verify(source, r, payload, h.Secret); err != nil {
+		h.reject(w, http.StatusUnauthorized, source, "%v", err)
+		return
+	}
+	events, err := ParsePayload(source, payload)
+	if err != nil {
+		h.reject(w, http.StatusBadRequest, source, "%v", err)
+		return
+	}
+	for i, event := range events {
+		select {
+		case h.Queue <- event:
+			h.logf("queued %s event for %s", event.Source, event.Image)
+		default:
+			h.reject(w, http.StatusServiceUnavailable, source, "queue is full, dropped %d of %d events", len(events)-i, len(events))
+			return
+		}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"queued": len(events)})
+}
+
+func (h *Handler) reject(w http.ResponseWriter, status int, source, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	h.logf("rejected %s webhook: %s", source, message)
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func (h *Handler) logf(format string, args ...interface{}) {
+	if h.Logf != nil {
+		h.Logf(format, args...)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func stringInSlice(str string, slice []string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParsePayload(t *testing.T) {
+	for _, tc := range []struct {
+		source  string
+		payload string
+		images  []string
+	}{
+		{
+			SourceDockerHub,
+			`{"push_data": {"tag": "1.2.3", "pusher": "kcd"}, "repository": {"repo_name": "kubecd/app"}}`,
+			[]string{"kubecd/app:1.2.3"},
+		},
+		{
+			SourceHarbor,
+			`{"type": "PUSH_ARTIFACT", "event_data": {"resources": [
+			  {"digest": "` + testDigest + `", "tag": "v1", "resource_url": "harbor.example.com/library/app:v1"}]}}`,
+			[]string{"harbor.example.com/library/app:v1@" + testDigest},
+		},
+		{
+			SourceHarbor,
+			`{"type": "DELETE_ARTIFACT", "event_data": {"resources": [{"tag": "v1", "resource_url": "harbor.example.com/library/app:v1"}]}}`,
+			nil,
+		},
+		{
+			SourceDistribution,
+			`{"events": [
+			  {"action": "push", "target": {"digest": "` + testDigest + `", "repository": "team/app", "tag": "2.0",
+			   "url": "https://registry.example.com:5000/v2/team/app/manifests/` + testDigest + `"}},
+			  {"action": "push", "target": {"repository": "team/app", "digest": "` + testDigest + `"}, "request": {"host": "registry.example.com"}},
+			  {"action": "pull", "target": {"repository": "team/app", "tag": "1.0"}, "request": {"host": "registry.example.com"}}]}`,
+			[]string{"registry.example.com:5000/team/app:2.0@" + testDigest},
+		},
+		{
+			SourceGitLab,
+			`{"events": [{"action": "push", "target": {"repository": "group/project", "tag": "main-1"}, "request": {"host": "registry.gitlab.com"}}]}`,
+			[]string{"registry.gitlab.com/group/project:main-1"},
+		},
+		{
+			SourceGitHub,
+			`{"action": "published", "package": {"name": "app", "namespace": "KubeCD", "package_type": "CONTAINER",
+			  "package_version": {"container_metadata": {"tag": {"name": "1.0", "digest": "` + testDigest + `"}}}}}`,
+			[]string{"ghcr.io/kubecd/app:1.0@" + testDigest},
+		},
+		{
+			SourceGitHub,
+			`{"action": "published", "package": {"name": "app", "namespace": "kubecd", "package_type": "container",
+			  "package_version": {"package_url": "ghcr.io/kubecd/app:", "container_metadata": {"tag": {"name": ""}}}}}`,
+			nil,
+		},
+	} {
+		t.Run(tc.source, func(t *testing.T) {
+			events, err := ParsePayload(tc.source, []byte(tc.payload))
+			require.NoError(t, err)
+			var images []string
+			for _, event := range events {
+				assert.Equal(t, tc.source, event.Source)
+				images = append(images, event.Image)
+			}
+			assert.Equal(t, tc.images, images)
+		})
+	}
+	_, err := ParsePayload(SourceDockerHub, []byte(`not json`))
+	assert.Error(t, err)
+	_, err = ParsePayload("quay", []byte(`{}`))
+	assert.EqualError(t, err, `unknown webhook source "quay"`)
+}
+
+func TestVerify(t *testing.T) {
+	payload := []byte(`{"action": "published"}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	_, _ = mac.Write(payload)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	for _, tc := range []struct {
+		name   string
+		source string
+		target string
+		header string
+		value  string
+		valid  bool
+	}{
+		{"hmac", SourceGitHub, "/hooks/github", "X-Hub-Signature-256", signature, true},
+		{"bad hmac", SourceGitHub, "/hooks/github", "X-Hub-Signature-256", "sha256=00", false},
+		{"github token", SourceGitHub, "/hooks/github?token=s3cret", "Authorization", "s3cret", false},
+		{"gitlab token", SourceGitLab, "/hooks/gitlab", "X-Gitlab-Token", "s3cret", true},
+		{"gitlab authorization", SourceGitLab, "/hooks/gitlab", "Authorization", "s3cret", false},
+		{"authorization", SourceHarbor, "/hooks/harbor", "Authorization", "s3cret", true},
+		{"harbor query token", SourceHarbor, "/hooks/harbor?token=s3cret", "", "", false},
+		{"bearer", SourceDistribution, "/hooks/distribution", "Authorization", "Bearer s3cret", true},
+		{"query token", SourceDockerHub, "/hooks/dockerhub?token=s3cret", "", "", true},
+		{"dockerhub authorization", SourceDockerHub, "/hooks/dockerhub", "Authorization", "s3cret", false},
+		{"bad token", SourceDockerHub, "/hooks/dockerhub?token=guess", "", "", false},
+		{"nothing", SourceDockerHub, "/hooks/dockerhub", "", "", false},
+		{"unknown source", "quay", "/hooks/quay?token=s3cret", "", "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tc.target, nil)
+			if tc.header != "" {
+				r.Header.Set(tc.header, tc.value)
+			}
+			err := Verify(tc.source, r, payload, "s3cret")
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	queue := make(chan Event, 1)
+	handler := &Handler{Secret: "s3cret", Queue: queue}
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+	payload := `{"push_data": {"tag": "1.0"}, "repository": {"repo_name": "kubecd/app"}}`
+
+	w := serve(http.MethodPost, "/hooks/dockerhub?token=s3cret", payload)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.JSONEq(t, `{"queued": 1}`, w.Body.String())
+	assert.Equal(t, Event{Source: SourceDockerHub, Image: "kubecd/app:1.0"}, <-queue)
+
+	w = serve(http.MethodGet, "/healthz", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ok", "queued": 0}`, w.Body.String())
+
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/hooks/dockerhub?token=guess", payload).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/hooks/github?token=s3cret", payload).Code, "github requests must be signed")
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet, "/hooks/dockerhub?token=s3cret", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/hooks/quay?token=s3cret", payload).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/hooks/dockerhub?token=s3cret", "{").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/", "").Code)
+
+	assert.Equal(t, http.StatusAccepted, serve(http.MethodPost, "/hooks/dockerhub?token=s3cret", payload).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodPost, "/hooks/dockerhub?token=s3cret", payload).Code)
+}
@@ -0,0 +1,466 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package yamlpatch edits YAML documents in place, changing only the bytes of
+// the nodes being edited, so that indentation, quoting style, comments and
+// blank lines are kept exactly as they were. Edits that cannot be made this way
+// fail rather than re-encoding the document.
+package yamlpatch
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Indent is the indentation used within nodes added to a document.
+const Indent = 2
+
+// Document is a parsed YAML document along with the edits made to it.
+//
+// Edits are made through the methods of Document, which update both the node
+// tree in Root and the list of byte-level changes applied by Bytes. Nodes added
+// by an edit may be edited further; they are rendered when Bytes is called.
+// Edits that cannot be made in place, such as changing block scalars or adding
+// to flow collections, return an error. Only the first document of a stream is
+// parsed, any documents after it are kept as they are.
+type Document struct {
+	// Root is the top-level node of the document, usually a mapping
+	Root *yaml.Node
+
+	doc         yaml.Node
+	data        []byte
+	lineOffsets []int
+	parents     map[*yaml.Node]*yaml.Node
+	edits       []edit
+	replaced    map[*yaml.Node]bool
+	spans       map[*yaml.Node][2]int
+}
+
+// edit replaces data[start:end] with the text rendered from the edit's nodes
+type edit struct {
+	start, end int
+	// scalar is set when replacing a scalar value
+	scalar *yaml.Node
+	// key and value are set when adding a mapping entry, value only when adding a sequence item
+	key, value *yaml.Node
+	indent     int
+}
+
+// Parse parses a YAML document for editing.
+func Parse(data []byte) (*Document, error) {
+	d := &Document{
+		data:     data,
+		parents:  make(map[*yaml.Node]*yaml.Node),
+		replaced: make(map[*yaml.Node]bool),
+		spans:    make(map[*yaml.Node][2]int),
+	}
+	if err := yaml.Unmarshal(data, &d.doc); err != nil {
+		return nil, err
+	}
+	if len(d.doc.Content) == 0 {
+		return nil, fmt.Errorf(`empty document`)
+	}
+	d.Root = d.doc.Content[0]
+	d.lineOffsets = []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			d.lineOffsets = append(d.lineOffsets, i+1)
+		}
+	}
+	d.indexParents(&d.doc)
+	return d, nil
+}
+
+func (d *Document) indexParents(node *yaml.Node) {
+	for _, child := range node.Content {
+		d.parents[child] = node
+		d.indexParents(child)
+	}
+}
+
+// isParsed tells whether a node was read from the original document, as
+// opposed to being added by an edit
+func (d *Document) isParsed(node *yaml.Node) bool {
+	_, found := d.parents[node]
+	return found
+}
+
+// SetScalar changes the value of a scalar node, keeping its quoting style.
+func (d *Document) SetScalar(node *yaml.Node, value string) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf(`line %d: not a scalar`, node.Line)
+	}
+	if d.isParsed(node) && !d.replaced[node] {
+		start, end, ok := d.scalarRange(node)
+		if !ok {
+			return notInPlace(node, "value")
+		}
+		d.replaced[node] = true
+		d.edits = append(d.edits, edit{start: start, end: end, scalar: node})
+	}
+	node.Value = value
+	return nil
+}
+
+// SetMapEntry sets the value of a key in a mapping. An existing scalar value is
+// changed with SetScalar, taking the tag of the new value if it has one,
+// otherwise the value node is added after the existing entries. Existing values
+// that are not scalars cannot be replaced.
+func (d *Document) SetMapEntry(mapping *yaml.Node, key string, value *yaml.Node) error {
+	if mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf(`line %d: not a mapping`, mapping.Line)
+	}
+	for i := 0; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != key {
+			continue
+		}
+		old := mapping.Content[i+1]
+		if old.Kind == yaml.ScalarNode && value.Kind == yaml.ScalarNode {
+			if value.Tag != "" {
+				old.Tag = value.Tag
+			}
+			return d.SetScalar(old, value.Value)
+		}
+		if d.isParsed(old) {
+			return notInPlace(old, fmt.Sprintf(`value of %q`, key))
+		}
+		mapping.Content[i+1] = value
+		return nil
+	}
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	if d.isParsed(mapping) {
+		if err := d.addAfter(mapping, keyNode, value); err != nil {
+			return err
+		}
+	}
+	mapping.Content = append(mapping.Content, keyNode, value)
+	return nil
+}
+
+// AppendToSequence adds an item at the end of a sequence.
+func (d *Document) AppendToSequence(sequence *yaml.Node, item *yaml.Node) error {
+	if sequence.Kind != yaml.SequenceNode {
+		return fmt.Errorf(`line %d: not a sequence`, sequence.Line)
+	}
+	if d.isParsed(sequence) {
+		if err := d.addAfter(sequence, nil, item); err != nil {
+			return err
+		}
+	}
+	sequence.Content = append(sequence.Content, item)
+	return nil
+}
+
+// addAfter adds an edit inserting a new mapping entry or sequence item after
+// the last line of a block collection
+func (d *Document) addAfter(collection, key, value *yaml.Node) error {
+	if collection.Style&yaml.FlowStyle != 0 || len(collection.Content) == 0 || d.inFlow(collection) {
+		return notInPlace(collection, "flow collection")
+	}
+	end, ok := d.nodeEnd(collection)
+	if !ok {
+		return notInPlace(collection, "collection")
+	}
+	if i := bytes.IndexByte(d.data[end:], '\n'); i >= 0 {
+		end += i + 1
+	} else {
+		end = len(d.data)
+	}
+	d.edits = append(d.edits, edit{start: end, end: end, key: key, value: value, indent: collection.Column - 1})
+	return nil
+}
+
+func notInPlace(node *yaml.Node, what string) error {
+	return fmt.Errorf(`line %d: %s cannot be patched in place`, node.Line, what)
+}
+
+// Bytes returns the edited document.
+func (d *Document) Bytes() ([]byte, error) {
+	// apply edits from the end so offsets stay valid, and inserts at the same
+	// offset last-first so they end up in the order they were made
+	edits := make([]edit, len(d.edits))
+	for i, e := range d.edits {
+		edits[len(edits)-1-i] = e
+	}
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	result := make([]byte, len(d.data))
+	copy(result, d.data)
+	if len(edits) > 0 && edits[0].start == len(d.data) && edits[0].scalar == nil && !bytes.HasSuffix(d.data, []byte("\n")) {
+		result = append(result, '\n')
+		for i := 0; i < len(edits) && edits[i].start == len(d.data); i++ {
+			edits[i].start++
+			edits[i].end++
+		}
+	}
+	for _, e := range edits {
+		text, err := d.render(e)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result[:e.start], append(text, result[e.end:]...)...)
+	}
+	return result, nil
+}
+
+func (d *Document) render(e edit) ([]byte, error) {
+	if e.scalar != nil {
+		return renderScalar(e.scalar)
+	}
+	indent := strings.Repeat(" ", e.indent)
+	var node *yaml.Node
+	if e.key != nil {
+		node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{e.key, e.value}}
+	} else {
+		node = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{e.value}}
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(Indent)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+	var result bytes.Buffer
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line != "" {
+			result.WriteString(indent + line)
+		}
+	}
+	return result.Bytes(), nil
+}
+
+// renderScalar encodes a scalar in the same style as it had, leaving out
+// comments, anchors and tags. Strings are quoted if needed to stay strings,
+// unless the tag written in the document says so, other values are written as
+// they are.
+func renderScalar(node *yaml.Node) ([]byte, error) {
+	tag := node.Tag
+	if tag != "!!str" || node.Style&yaml.TaggedStyle != 0 {
+		tag = ""
+	}
+	style := node.Style &^ yaml.TaggedStyle
+	out, err := yaml.Marshal(&yaml.Node{Kind: yaml.ScalarNode, Style: style, Tag: tag, Value: node.Value})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out, []byte("\n")), nil
+}
+
+// offset converts a node's line and column to a byte offset
+func (d *Document) offset(line, column int) (int, bool) {
+	if line < 1 || line > len(d.lineOffsets) {
+		return 0, false
+	}
+	offset := d.lineOffsets[line-1]
+	for i := 1; i < column; i++ {
+		if offset >= len(d.data) {
+			return 0, false
+		}
+		_, size := utf8.DecodeRune(d.data[offset:])
+		offset += size
+	}
+	return offset, true
+}
+
+func (d *Document) inFlow(node *yaml.Node) bool {
+	for parent := d.parents[node]; parent != nil; parent = d.parents[parent] {
+		if parent.Style&yaml.FlowStyle != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// scalarRange finds the bytes of a single-line scalar, including any quotes but
+// not its anchor or tag. Block scalars and multi-line scalars are not supported.
+// Ranges are remembered, as they can only be found before the value is changed.
+func (d *Document) scalarRange(node *yaml.Node) (int, int, bool) {
+	if span, found := d.spans[node]; found {
+		return span[0], span[1], true
+	}
+	start, end, ok := d.findScalarRange(node)
+	if ok {
+		d.spans[node] = [2]int{start, end}
+	}
+	return start, end, ok
+}
+
+func (d *Document) findScalarRange(node *yaml.Node) (int, int, bool) {
+	start, ok := d.offset(node.Line, node.Column)
+	if !ok {
+		return 0, 0, false
+	}
+	lineEnd := bytes.IndexByte(d.data[start:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(d.data)
+	} else {
+		lineEnd += start
+	}
+	line := d.data[start:lineEnd]
+	if node.Anchor != "" || node.Style&yaml.TaggedStyle != 0 {
+		n := propertiesLength(line)
+		if n < 0 {
+			return 0, 0, false
+		}
+		start += n
+		line = line[n:]
+	}
+	switch {
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		return 0, 0, false
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i := 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				return start, start + i + 1, line[0] == '"'
+			}
+		}
+		return 0, 0, false
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i := 1; i < len(line); i++ {
+			if line[i] == '\'' {
+				if i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					continue
+				}
+				return start, start + i + 1, line[0] == '\''
+			}
+		}
+		return 0, 0, false
+	}
+	end := len(line)
+	if i := bytes.Index(line, []byte(" #")); i >= 0 {
+		end = i
+	}
+	if d.inFlow(node) {
+		if i := bytes.IndexAny(line[:end], ",]}"); i >= 0 {
+			end = i
+		}
+	}
+	text := strings.TrimRight(string(line[:end]), " \t\r")
+	// a plain scalar continuing on the next line would not match its value
+	if text != node.Value {
+		return 0, 0, false
+	}
+	return start, start + len(text), true
+}
+
+// propertiesLength returns the length of the anchor and tag at the start of a
+// line, along with the blanks after them, or -1 if no value follows on the line
+func propertiesLength(line []byte) int {
+	i := 0
+	for i < len(line) && (line[i] == '&' || line[i] == '!') {
+		n := bytes.IndexAny(line[i:], " \t")
+		if n < 0 {
+			return -1
+		}
+		for i += n; i < len(line) && (line[i] == ' ' || line[i] == '\t'); i++ {
+		}
+	}
+	if i == len(line) || line[i] == '#' {
+		return -1
+	}
+	return i
+}
+
+// nodeEnd finds the offset just after the last scalar of a node
+func (d *Document) nodeEnd(node *yaml.Node) (int, bool) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		_, end, ok := d.scalarRange(node)
+		return end, ok
+	case yaml.AliasNode:
+		start, ok := d.offset(node.Line, node.Column)
+		if !ok {
+			return 0, false
+		}
+		end := bytes.IndexAny(d.data[start:], " \t\r\n,]}")
+		if end < 0 {
+			return len(d.data), true
+		}
+		return start + end, true
+	}
+	if node.Style&yaml.FlowStyle != 0 {
+		return 0, false
+	}
+	// skip any nodes added by edits
+	for i := len(node.Content) - 1; i >= 0; i-- {
+		if d.isParsed(node.Content[i]) {
+			return d.nodeEnd(node.Content[i])
+		}
+	}
+	return 0, false
+}
+
+// PatchFile edits a YAML file with fn, and writes it back if it changed.
+func PatchFile(fileName string, fn func(doc *Document) error) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf(`error reading %q: %v`, fileName, err)
+	}
+	doc, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf(`error decoding yaml in %q: %v`, fileName, err)
+	}
+	if err = fn(doc); err != nil {
+		return err
+	}
+	result, err := doc.Bytes()
+	if err != nil {
+		return fmt.Errorf(`error encoding yaml for %q: %v`, fileName, err)
+	}
+	if bytes.Equal(result, data) {
+		return nil
+	}
+	return writeFile(fileName, result)
+}
+
+// writeFile replaces a file by renaming a temporary file, keeping its permissions
+func writeFile(fileName string, data []byte) error {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(path.Dir(fileName), path.Base(fileName)+"*")
+	if err != nil {
+		return fmt.Errorf(`error creating tmpfile for %q: %v`, fileName, err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+	if _, err = tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpFile.Name(), info.Mode()); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), fileName); err != nil {
+		return fmt.Errorf(`error renaming %q to %q: %v`, tmpFile.Name(), fileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright 2018-2019 Zedge, Inc.
+ * Copyright 2019-2020 Stig Sæther Nordahl Bakken
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package yamlpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func mapEntry(node *yaml.Node, name string) *yaml.Node {
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func patch(t *testing.T, input string, fn func(doc *Document)) string {
+	doc, err := Parse([]byte(input))
+	require.NoError(t, err)
+	fn(doc)
+	output, err := doc.Bytes()
+	require.NoError(t, err)
+	return string(output)
+}
+
+func TestSetScalar(t *testing.T) {
+	input := `# leading comment
+releases:
+
+    - name: app   # the app
+      values:
+          - { key: image.tag, value: v1 }
+          - key: image.repository
+            value: 'example/app'
+    - name: "other"
+      chart: {reference: stable/other, version: "1.0.0"}
+      tags: [a, b]
+`
+	output := patch(t, input, func(doc *Document) {
+		releases := mapEntry(doc.Root, "releases")
+		app := releases.Content[0]
+		require.NoError(t, doc.SetScalar(mapEntry(app, "name"), "application"))
+		values := mapEntry(app, "values")
+		require.NoError(t, doc.SetScalar(mapEntry(values.Content[0], "value"), "v2"))
+		require.NoError(t, doc.SetScalar(mapEntry(values.Content[1], "value"), "it's/app"))
+		other := releases.Content[1]
+		require.NoError(t, doc.SetScalar(mapEntry(other, "name"), "another"))
+		require.NoError(t, doc.SetScalar(mapEntry(mapEntry(other, "chart"), "version"), "1.10"))
+		require.NoError(t, doc.SetScalar(mapEntry(other, "tags").Content[1], "c"))
+	})
+	assert.Equal(t, `# leading comment
+releases:
+
+    - name: application   # the app
+      values:
+          - { key: image.tag, value: v2 }
+          - key: image.repository
+            value: 'it''s/app'
+    - name: "another"
+      chart: {reference: stable/other, version: "1.10"}
+      tags: [a, c]
+`, output)
+}
+
+func TestSetScalarKeepsType(t *testing.T) {
+	output := patch(t, "version: 1.0\ntag: v1\n", func(doc *Document) {
+		require.NoError(t, doc.SetScalar(mapEntry(doc.Root, "version"), "1.1"))
+		require.NoError(t, doc.SetScalar(mapEntry(doc.Root, "tag"), "2.0"))
+	})
+	assert.Equal(t, "version: 1.1\ntag: \"2.0\"\n", output)
+}
+
+func TestSetMapEntryKeepsStringTag(t *testing.T) {
+	output := patch(t, "image:\n  tag: 1.9\n", func(doc *Document) {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "1.10"}
+		require.NoError(t, doc.SetMapEntry(mapEntry(doc.Root, "image"), "tag", value))
+	})
+	assert.Equal(t, "image:\n  tag: \"1.10\"\n", output)
+}
+
+func TestSetMapEntry(t *testing.T) {
+	input := `image:
+    repository: app # comment
+
+replicas: 1`
+	output := patch(t, input, func(doc *Document) {
+		require.NoError(t, doc.SetMapEntry(mapEntry(doc.Root, "image"), "tag", &yaml.Node{Kind: yaml.ScalarNode, Value: "v1"}))
+		resources := &yaml.Node{Kind: yaml.MappingNode}
+		require.NoError(t, doc.SetMapEntry(doc.Root, "resources", resources))
+		require.NoError(t, doc.SetMapEntry(resources, "cpu", &yaml.Node{Kind: yaml.ScalarNode, Value: "1"}))
+		require.NoError(t, doc.SetMapEntry(doc.Root, "replicas", &yaml.Node{Kind: yaml.ScalarNode, Value: "2"}))
+	})
+	assert.Equal(t, `image:
+    repository: app # comment
+    tag: v1
+
+replicas: 2
+resources:
+  cpu: 1
+`, output)
+}
+
+func TestAppendToSequence(t *testing.T) {
+	input := `values:
+  - key: a
+    value: b
+  # trailing comment
+other: x
+`
+	output := patch(t, input, func(doc *Document) {
+		item := &yaml.Node{Kind: yaml.MappingNode}
+		require.NoError(t, doc.AppendToSequence(mapEntry(doc.Root, "values"), item))
+		require.NoError(t, doc.SetMapEntry(item, "key", &yaml.Node{Kind: yaml.ScalarNode, Value: "c"}))
+		require.NoError(t, doc.SetMapEntry(item, "value", &yaml.Node{Kind: yaml.ScalarNode, Value: "d"}))
+	})
+	assert.Equal(t, `values:
+  - key: a
+    value: b
+  - key: c
+    value: d
+  # trailing comment
+other: x
+`, output)
+}
+
+func TestNotInPlace(t *testing.T) {
+	input := "values: [a, b]\ndescription: |\n  text\nimage:\n  tag: v1\n"
+	doc, err := Parse([]byte(input))
+	require.NoError(t, err)
+	err = doc.AppendToSequence(mapEntry(doc.Root, "values"), &yaml.Node{Kind: yaml.ScalarNode, Value: "c"})
+	assert.EqualError(t, err, `line 1: flow collection cannot be patched in place`)
+	err = doc.SetScalar(mapEntry(doc.Root, "description"), "other")
+	assert.EqualError(t, err, `line 2: value cannot be patched in place`)
+	err = doc.SetMapEntry(doc.Root, "image", &yaml.Node{Kind: yaml.ScalarNode, Value: "app"})
+	assert.EqualError(t, err, `line 5: value of "image" cannot be patched in place`)
+	output, err := doc.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, input, string(output))
+}
+
+func TestAnchorsAndTags(t *testing.T) {
+	input := `tag: &tag 1.0 # shared
+other: *tag
+name: !!str 1.9
+quoted: &q !!str "x"
+`
+	output := patch(t, input, func(doc *Document) {
+		require.NoError(t, doc.SetScalar(mapEntry(doc.Root, "tag"), "1.1"))
+		require.NoError(t, doc.SetScalar(mapEntry(doc.Root, "name"), "1.10"))
+		require.NoError(t, doc.SetScalar(mapEntry(doc.Root, "quoted"), "y"))
+		require.NoError(t, doc.SetMapEntry(doc.Root, "added", &yaml.Node{Kind: yaml.ScalarNode, Value: "z"}))
+	})
+	assert.Equal(t, `tag: &tag 1.1 # shared
+other: *tag
+name: !!str 1.10
+quoted: &q !!str "y"
+added: z
+`, output)
+	var values map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(output), &values))
+	assert.Equal(t, 1.1, values["other"])
+	assert.Equal(t, "1.10", values["name"])
+}
+
+func TestMultipleDocuments(t *testing.T) {
+	input := `image:
+    tag: v1
+---
+image:
+    tag: v1
+`
+	output := patch(t, input, func(doc *Document) {
+		image := mapEntry(doc.Root, "image")
+		require.NoError(t, doc.SetScalar(mapEntry(image, "tag"), "v2"))
+		require.NoError(t, doc.SetMapEntry(image, "repository", &yaml.Node{Kind: yaml.ScalarNode, Value: "app"}))
+	})
+	assert.Equal(t, `image:
+    tag: v2
+    repository: app
+---
+image:
+    tag: v1
+`, output)
+}
+
+func TestSetScalarThenAppend(t *testing.T) {
+	output := patch(t, "image:\n  tag: v1\n", func(doc *Document) {
+		image := mapEntry(doc.Root, "image")
+		require.NoError(t, doc.SetScalar(mapEntry(image, "tag"), "v2"))
+		require.NoError(t, doc.SetMapEntry(image, "repository", &yaml.Node{Kind: yaml.ScalarNode, Value: "app"}))
+	})
+	assert.Equal(t, "image:\n  tag: v2\n  repository: app\n", output)
+}